@@ -0,0 +1,164 @@
+// Package alert evaluates alert rules against the events uploaded by Santa
+// clients, and delivers the alerts they raise to notifiers: a webhook, the
+// log or email.
+package alert
+
+import (
+	"net"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Config is an alert rules file, made of [[notifier]] and [[rule]] tables.
+type Config struct {
+	Notifiers []NotifierConfig `toml:"notifier"`
+	Rules     []Rule           `toml:"rule"`
+}
+
+// NotifierConfig is a destination of alerts.
+type NotifierConfig struct {
+	Name string `toml:"name"`
+	// Type is webhook, log or email.
+	Type string `toml:"type"`
+
+	// URL and Secret configure a webhook. Requests are signed like the
+	// requests of event webhooks.
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+
+	// SMTPAddr, Username, Password, From and To configure email. Mail is
+	// sent with STARTTLS when the server supports it.
+	SMTPAddr string   `toml:"smtp_addr"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+}
+
+// Rule selects the events an alert is raised for. Empty filters match every event.
+type Rule struct {
+	Name        string `toml:"name"`
+	Description string `toml:"description"`
+
+	Decisions  []string `toml:"decisions"`
+	Groups     []string `toml:"groups"`
+	MachineIDs []string `toml:"machine_ids"`
+	TeamIDs    []string `toml:"team_ids"`
+	SigningIDs []string `toml:"signing_ids"`
+	FileSHA256 []string `toml:"file_sha256"`
+	// CertSHA256 matches events with one of the certificates anywhere in their signing chain.
+	CertSHA256 []string `toml:"cert_sha256"`
+	// BlocklistedCertificate matches events signed by a certificate which a
+	// CERTIFICATE rule of the machine's configuration blocklists, whatever
+	// the decision of the event.
+	BlocklistedCertificate bool `toml:"blocklisted_certificate"`
+
+	// GroupBy raises separate alerts for the events of the rule by event
+	// field: machine_id, decision, file_sha256, team_id, signing_id or file_bundle_id.
+	GroupBy []string `toml:"group_by"`
+	// Threshold only raises the alert of a group once more than Threshold of
+	// its events match within Window.
+	Threshold int      `toml:"threshold"`
+	Window    Duration `toml:"window"`
+	// GroupWait is how long the events of a group are collected into its
+	// first alert. The default is 30s.
+	GroupWait Duration `toml:"group_wait"`
+	// Throttle is the least time between two alerts of a group. Events which
+	// match in between are sent with the next alert. The default is 15m.
+	Throttle Duration `toml:"throttle"`
+
+	// Notify names the notifiers alerts are delivered to. The default is log.
+	Notify []string `toml:"notify"`
+}
+
+// Duration is a time.Duration decoded from a string such as "10m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// groupByFields are the event fields a rule can group alerts by.
+var groupByFields = map[string]bool{
+	"machine_id":     true,
+	"decision":       true,
+	"file_sha256":    true,
+	"team_id":        true,
+	"signing_id":     true,
+	"file_bundle_id": true,
+}
+
+// LoadConfig reads and validates an alert rules file.
+func LoadConfig(path string) (Config, error) {
+	var conf Config
+	if _, err := toml.DecodeFile(path, &conf); err != nil {
+		return Config{}, errors.Wrapf(err, "decode alert rules %s", path)
+	}
+	if err := conf.validate(); err != nil {
+		return Config{}, errors.Wrapf(err, "alert rules %s", path)
+	}
+	return conf, nil
+}
+
+func (conf Config) validate() error {
+	notifiers := map[string]bool{"log": true}
+	for _, n := range conf.Notifiers {
+		if n.Name == "" {
+			return errors.New("notifier needs a name")
+		}
+		switch n.Type {
+		case "log":
+		case "webhook":
+			if n.URL == "" {
+				return errors.Errorf("webhook notifier %q needs a url", n.Name)
+			}
+		case "email":
+			if n.SMTPAddr == "" || n.From == "" || len(n.To) == 0 {
+				return errors.Errorf("email notifier %q needs a smtp_addr, from and to", n.Name)
+			}
+			if _, _, err := net.SplitHostPort(n.SMTPAddr); err != nil {
+				return errors.Wrapf(err, "email notifier %q", n.Name)
+			}
+		default:
+			return errors.Errorf("notifier %q has type %q, want webhook, log or email", n.Name, n.Type)
+		}
+		if notifiers[n.Name] && n.Name != "log" {
+			return errors.Errorf("duplicate notifier name %q", n.Name)
+		}
+		notifiers[n.Name] = true
+	}
+
+	rules := make(map[string]bool)
+	for _, rule := range conf.Rules {
+		if rule.Name == "" {
+			return errors.New("rule needs a name")
+		}
+		if rules[rule.Name] {
+			return errors.Errorf("duplicate rule name %q", rule.Name)
+		}
+		rules[rule.Name] = true
+		for _, field := range rule.GroupBy {
+			if !groupByFields[field] {
+				return errors.Errorf("rule %q groups by unknown field %q", rule.Name, field)
+			}
+		}
+		if rule.Threshold < 0 || rule.Threshold > 0 && rule.Window.Duration <= 0 {
+			return errors.Errorf("rule %q needs a positive threshold and window", rule.Name)
+		}
+		for _, name := range rule.Notify {
+			if !notifiers[name] {
+				return errors.Errorf("rule %q notifies unknown notifier %q", rule.Name, name)
+			}
+		}
+	}
+	return nil
+}
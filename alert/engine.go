@@ -0,0 +1,338 @@
+package alert
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/match"
+	"github.com/groob/moroz/internal/retry"
+)
+
+const (
+	defaultGroupWait = 30 * time.Second
+	defaultThrottle  = 15 * time.Minute
+
+	// maxAlertEvents bounds the events sent with an alert. Count has the total.
+	maxAlertEvents = 10
+	// deliveryAttempts is how many times an alert is sent to a failing notifier.
+	deliveryAttempts = 3
+)
+
+// Event is an uploaded event, with what the server knows about it.
+type Event struct {
+	eventstore.Event
+	// BlocklistedCertificates are the certificates of the signing chain which a
+	// CERTIFICATE rule of the machine's configuration blocklists.
+	BlocklistedCertificates []string `json:"blocklisted_certificates,omitempty"`
+}
+
+// Alert is raised for the events of a rule.
+type Alert struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description,omitempty"`
+	// Group holds the GroupBy fields of the events.
+	Group map[string]string `json:"group,omitempty"`
+	// Count is the number of events the alert is raised for, and Events the
+	// latest of them.
+	Count   int       `json:"count"`
+	FirstAt time.Time `json:"first_at"`
+	LastAt  time.Time `json:"last_at"`
+	Events  []Event   `json:"events"`
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Match reports whether ev is selected by the filters of the rule.
+func (rule Rule) Match(ev Event) bool {
+	e := ev.Event.Event
+	switch {
+	case !match.Value(rule.Decisions, e.Decision):
+		return false
+	case !match.Value(rule.MachineIDs, ev.MachineID):
+		return false
+	case !match.Value(rule.TeamIDs, e.TeamID):
+		return false
+	case !match.Value(rule.SigningIDs, e.SigningID):
+		return false
+	case !match.Value(rule.FileSHA256, e.FileSHA256):
+		return false
+	case !match.Any(rule.Groups, ev.Groups):
+		return false
+	case rule.BlocklistedCertificate && len(ev.BlocklistedCertificates) == 0:
+		return false
+	}
+	if len(rule.CertSHA256) > 0 {
+		var chain []string
+		for _, cert := range e.SigningChain {
+			chain = append(chain, cert.SHA256)
+		}
+		return match.Any(rule.CertSHA256, chain)
+	}
+	return true
+}
+
+// group returns the GroupBy fields of ev, and a key identifying them.
+func (rule Rule) group(ev Event) (map[string]string, string) {
+	if len(rule.GroupBy) == 0 {
+		return nil, ""
+	}
+	e := ev.Event.Event
+	labels := make(map[string]string, len(rule.GroupBy))
+	values := make([]string, 0, len(rule.GroupBy))
+	for _, field := range rule.GroupBy {
+		var v string
+		switch field {
+		case "machine_id":
+			v = ev.MachineID
+		case "decision":
+			v = e.Decision
+		case "file_sha256":
+			v = e.FileSHA256
+		case "team_id":
+			v = e.TeamID
+		case "signing_id":
+			v = e.SigningID
+		case "file_bundle_id":
+			v = e.FileBundleID
+		}
+		labels[field] = v
+		values = append(values, v)
+	}
+	return labels, strings.Join(values, "\x00")
+}
+
+// Engine evaluates rules against events, and delivers the alerts they raise
+// from its Run method.
+type Engine struct {
+	rules     []Rule
+	notifiers map[string]Notifier
+	logger    log.Logger
+	now       func() time.Time
+
+	mtx    sync.Mutex
+	groups map[groupKey]*group
+}
+
+type groupKey struct {
+	rule, group string
+}
+
+// group is the state of the alerts of a rule for a set of GroupBy values.
+type group struct {
+	rule *Rule
+	// hits are the times the events of a threshold rule matched within its
+	// window, and recent the latest of those events. Only the count and the
+	// oldest hit over the threshold matter, so at most Threshold+1 are kept.
+	hits     []time.Time
+	recent   []Event
+	pending  *Alert
+	lastSent time.Time
+}
+
+// NewEngine creates an Engine for the rules and notifiers of conf. client
+// sends the requests of webhook notifiers.
+func NewEngine(conf Config, client *http.Client, logger log.Logger) (*Engine, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		notifiers: map[string]Notifier{"log": NewLogNotifier(logger)},
+		logger:    log.With(logger, "component", "alert"),
+		now:       time.Now,
+		groups:    make(map[groupKey]*group),
+	}
+	for _, n := range conf.Notifiers {
+		switch n.Type {
+		case "log":
+			e.notifiers[n.Name] = NewLogNotifier(logger)
+		case "webhook":
+			e.notifiers[n.Name] = NewWebhookNotifier(n.URL, n.Secret, client)
+		case "email":
+			e.notifiers[n.Name] = NewEmailNotifier(n)
+		}
+	}
+	for _, rule := range conf.Rules {
+		if rule.GroupWait.Duration <= 0 {
+			rule.GroupWait.Duration = defaultGroupWait
+		}
+		if rule.Throttle.Duration <= 0 {
+			rule.Throttle.Duration = defaultThrottle
+		}
+		if len(rule.Notify) == 0 {
+			rule.Notify = []string{"log"}
+		}
+		e.rules = append(e.rules, rule)
+	}
+	return e, nil
+}
+
+// Evaluate matches events against the rules, adding them to the alerts of
+// their group. Alerts are sent by Run.
+func (e *Engine) Evaluate(ctx context.Context, events []Event) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for i := range e.rules {
+		rule := &e.rules[i]
+		for _, ev := range events {
+			if !rule.Match(ev) {
+				continue
+			}
+			labels, key := rule.group(ev)
+			g, ok := e.groups[groupKey{rule.Name, key}]
+			if !ok {
+				g = &group{rule: rule}
+				e.groups[groupKey{rule.Name, key}] = g
+			}
+			if rule.Threshold == 0 {
+				g.add(labels, []Event{ev}, 1)
+				continue
+			}
+			g.hits = append(pruneHits(g.hits, ev.ReceivedAt.Add(-rule.Window.Duration)), ev.ReceivedAt)
+			if len(g.hits) > rule.Threshold+1 {
+				g.hits = g.hits[len(g.hits)-rule.Threshold-1:]
+			}
+			g.recent = appendEvents(g.recent, ev)
+			if len(g.hits) <= rule.Threshold {
+				continue
+			}
+			if g.pending != nil {
+				g.add(labels, []Event{ev}, 1)
+				continue
+			}
+			// the first alert over the threshold has the events of the window
+			// which no previous alert counted.
+			var count int
+			for _, hit := range g.hits {
+				if hit.After(g.lastSent) {
+					count++
+				}
+			}
+			var window []Event
+			for _, recent := range g.recent {
+				if recent.ReceivedAt.After(g.lastSent) && !recent.ReceivedAt.Before(g.hits[0]) {
+					window = append(window, recent)
+				}
+			}
+			g.add(labels, window, count)
+		}
+	}
+}
+
+// pruneHits drops the hits before since.
+func pruneHits(hits []time.Time, since time.Time) []time.Time {
+	i := sort.Search(len(hits), func(i int) bool { return !hits[i].Before(since) })
+	return hits[i:]
+}
+
+// add adds events to the pending alert of the group, counted as count events.
+func (g *group) add(labels map[string]string, events []Event, count int) {
+	if g.pending == nil {
+		g.pending = &Alert{
+			Rule:        g.rule.Name,
+			Description: g.rule.Description,
+			Group:       labels,
+			FirstAt:     events[0].ReceivedAt,
+		}
+	}
+	g.pending.Count += count
+	g.pending.LastAt = events[len(events)-1].ReceivedAt
+	for _, ev := range events {
+		g.pending.Events = appendEvents(g.pending.Events, ev)
+	}
+}
+
+// appendEvents appends ev, keeping the latest maxAlertEvents events.
+func appendEvents(events []Event, ev Event) []Event {
+	events = append(events, ev)
+	if len(events) > maxAlertEvents {
+		events = append(events[:0:0], events[len(events)-maxAlertEvents:]...)
+	}
+	return events
+}
+
+type delivery struct {
+	alert  Alert
+	notify []string
+}
+
+// flush returns the alerts due at now, and forgets the groups which are idle.
+func (e *Engine) flush(now time.Time) []delivery {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	var due []delivery
+	for key, g := range e.groups {
+		rule := g.rule
+		if g.pending != nil && !now.Before(g.pending.FirstAt.Add(rule.GroupWait.Duration)) && !now.Before(g.lastSent.Add(rule.Throttle.Duration)) {
+			due = append(due, delivery{alert: *g.pending, notify: rule.Notify})
+			g.pending = nil
+			g.lastSent = now
+		}
+		g.hits = pruneHits(g.hits, now.Add(-rule.Window.Duration))
+		if len(g.hits) == 0 {
+			g.recent = nil
+		}
+		if g.pending == nil && len(g.hits) == 0 && !now.Before(g.lastSent.Add(rule.Throttle.Duration)) {
+			delete(e.groups, key)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].alert.FirstAt.Before(due[j].alert.FirstAt) })
+	return due
+}
+
+// Run sends the alerts which are due every second, until ctx is cancelled.
+// It returns once the deliveries in progress stop.
+func (e *Engine) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ticker.C:
+			e.send(ctx, &wg, e.flush(e.now()))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// send delivers each alert to each of its notifiers on its own goroutine, so
+// a slow or failing notifier doesn't hold up the others, nor the next alerts.
+func (e *Engine) send(ctx context.Context, wg *sync.WaitGroup, due []delivery) {
+	for _, d := range due {
+		for _, name := range d.notify {
+			wg.Add(1)
+			go func(name string, a Alert) {
+				defer wg.Done()
+				e.deliver(ctx, name, a)
+			}(name, d.alert)
+		}
+	}
+}
+
+// deliver sends an alert to a notifier, retrying a few times before the alert
+// is dropped.
+func (e *Engine) deliver(ctx context.Context, name string, a Alert) {
+	var err error
+	for attempt := 1; attempt <= deliveryAttempts; attempt++ {
+		if err = e.notifiers[name].Notify(ctx, a); err == nil {
+			return
+		}
+		level.Info(e.logger).Log("msg", "deliver alert", "rule", a.Rule, "notifier", name, "attempt", attempt, "err", err)
+		if attempt < deliveryAttempts && retry.Sleep(ctx, time.Duration(attempt)*5*time.Second) {
+			return
+		}
+	}
+	level.Info(e.logger).Log("msg", "dropped alert", "rule", a.Rule, "notifier", name, "err", err)
+}
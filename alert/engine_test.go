@@ -0,0 +1,176 @@
+package alert
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+func event(machineID, decision string, at time.Time) Event {
+	return Event{Event: eventstore.Event{
+		MachineID:  machineID,
+		ReceivedAt: at,
+		Event:      santa.EventUploadEvent{FileSHA256: "aaa", Decision: decision},
+	}}
+}
+
+func TestEngineThreshold(t *testing.T) {
+	conf := Config{Rules: []Rule{{
+		Name:      "unknown-storm",
+		Decisions: []string{"BLOCK_UNKNOWN"},
+		GroupBy:   []string{"machine_id"},
+		Threshold: 20,
+		Window:    Duration{10 * time.Minute},
+		Throttle:  Duration{time.Hour},
+	}}}
+	e, err := NewEngine(conf, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 20 blocks on M1 and 5 on M2 stay under the threshold.
+	for i := 0; i < 20; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		e.Evaluate(ctx, []Event{event("M1", "BLOCK_UNKNOWN", at), event("M1", "ALLOW_BINARY", at)})
+	}
+	for i := 0; i < 5; i++ {
+		e.Evaluate(ctx, []Event{event("M2", "BLOCK_UNKNOWN", start)})
+	}
+	if due := e.flush(start.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("have %d alerts under the threshold, want none\n", len(due))
+	}
+
+	// the window of M1 ends, so 20 new blocks are needed again.
+	now := start.Add(time.Hour)
+	for i := 0; i < 22; i++ {
+		e.Evaluate(ctx, []Event{event("M1", "BLOCK_UNKNOWN", now)})
+	}
+	if hits := len(e.groups[groupKey{"unknown-storm", "M1"}].hits); hits != 21 {
+		t.Errorf("have %d hits kept, want the threshold and one\n", hits)
+	}
+	if due := e.flush(now); len(due) != 0 {
+		t.Fatalf("have %d alerts before the group wait, want none\n", len(due))
+	}
+	due := e.flush(now.Add(time.Minute))
+	if len(due) != 1 {
+		t.Fatalf("have %d alerts, want 1\n", len(due))
+	}
+	a := due[0].alert
+	if a.Count != 22 || a.Group["machine_id"] != "M1" || len(a.Events) != maxAlertEvents || due[0].notify[0] != "log" {
+		t.Errorf("have alert %+v, want 22 events on M1 sent to the log\n", a)
+	}
+
+	// further blocks are throttled, then sent together.
+	e.Evaluate(ctx, []Event{event("M1", "BLOCK_UNKNOWN", now.Add(2*time.Minute))})
+	if due := e.flush(now.Add(30 * time.Minute)); len(due) != 0 {
+		t.Fatalf("have %d alerts while throttled, want none\n", len(due))
+	}
+	due = e.flush(now.Add(2 * time.Hour))
+	if len(due) != 1 || due[0].alert.Count != 1 {
+		t.Fatalf("have %+v, want the throttled event\n", due)
+	}
+}
+
+func TestEngineGroups(t *testing.T) {
+	conf := Config{
+		Notifiers: []NotifierConfig{{Name: "helpdesk", Type: "email", SMTPAddr: "smtp.example.com:25", From: "moroz@example.com", To: []string{"helpdesk@example.com"}}},
+		Rules: []Rule{
+			{Name: "finance-block", Decisions: []string{"BLOCK_BINARY"}, Groups: []string{"finance"}, Notify: []string{"helpdesk"}},
+			{Name: "blocklisted-certificate", BlocklistedCertificate: true},
+		},
+	}
+	e, err := NewEngine(conf, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	finance := event("M1", "BLOCK_BINARY", now)
+	finance.Groups = []string{"finance", "laptops"}
+	signed := event("M2", "ALLOW_BINARY", now)
+	signed.BlocklistedCertificates = []string{"cert"}
+	e.Evaluate(context.Background(), []Event{finance, event("M2", "BLOCK_BINARY", now), signed})
+
+	due := e.flush(now.Add(time.Minute))
+	if len(due) != 2 {
+		t.Fatalf("have %d alerts, want 2\n", len(due))
+	}
+	for _, d := range due {
+		if d.alert.Count != 1 {
+			t.Errorf("have alert %+v, want a single event\n", d.alert)
+		}
+	}
+
+	var mail string
+	email := e.notifiers["helpdesk"].(*EmailNotifier)
+	email.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		mail = string(msg)
+		return nil
+	}
+	var wg sync.WaitGroup
+	e.send(context.Background(), &wg, due)
+	wg.Wait()
+	if !strings.Contains(mail, "Subject: [moroz] finance-block: 1 event\r\n") || !strings.Contains(mail, "M1  BLOCK_BINARY") {
+		t.Errorf("have mail %q, want the finance block\n", mail)
+	}
+}
+
+type notifierFunc func(ctx context.Context, a Alert) error
+
+func (f notifierFunc) Notify(ctx context.Context, a Alert) error { return f(ctx, a) }
+
+func TestEngineSendConcurrently(t *testing.T) {
+	e, err := NewEngine(Config{}, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	sent := make(chan string, 2)
+	e.notifiers["slow"] = notifierFunc(func(ctx context.Context, a Alert) error {
+		<-release
+		return nil
+	})
+	e.notifiers["fast"] = notifierFunc(func(ctx context.Context, a Alert) error {
+		sent <- a.Rule
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	e.send(context.Background(), &wg, []delivery{
+		{alert: Alert{Rule: "first"}, notify: []string{"slow", "fast"}},
+		{alert: Alert{Rule: "second"}, notify: []string{"fast"}},
+	})
+	// both alerts reach the fast notifier while the slow one is stuck.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an alert behind a slow notifier")
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, conf := range []Config{
+		{Rules: []Rule{{Name: "a"}, {Name: "a"}}},
+		{Rules: []Rule{{Name: "a", Threshold: 5}}},
+		{Rules: []Rule{{Name: "a", GroupBy: []string{"hostname"}}}},
+		{Rules: []Rule{{Name: "a", Notify: []string{"pager"}}}},
+		{Notifiers: []NotifierConfig{{Name: "mail", Type: "email", SMTPAddr: "smtp.example.com"}}},
+	} {
+		if err := conf.validate(); err == nil {
+			t.Errorf("config %+v is valid, want an error\n", conf)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventsink"
+)
+
+// LogNotifier logs alerts at the warn level.
+type LogNotifier struct {
+	logger log.Logger
+}
+
+func NewLogNotifier(logger log.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+// Notify logs the alert with its group, and the latest of its events.
+func (n *LogNotifier) Notify(ctx context.Context, a Alert) error {
+	keyvals := []interface{}{"msg", "alert", "rule", a.Rule, "count", a.Count, "first_at", a.FirstAt, "last_at", a.LastAt}
+	for _, field := range sortedKeys(a.Group) {
+		keyvals = append(keyvals, field, a.Group[field])
+	}
+	if len(a.Events) > 0 {
+		ev := a.Events[len(a.Events)-1]
+		latest := []interface{}{
+			"machine_id", ev.MachineID,
+			"decision", ev.Event.Event.Decision,
+			"file_sha256", ev.Event.Event.FileSHA256,
+			"file_path", ev.Event.Event.FilePath,
+		}
+		for i := 0; i < len(latest); i += 2 {
+			if _, ok := a.Group[latest[i].(string)]; !ok {
+				keyvals = append(keyvals, latest[i], latest[i+1])
+			}
+		}
+	}
+	return level.Warn(n.logger).Log(keyvals...)
+}
+
+// WebhookNotifier POSTs alerts to a URL as JSON. Requests carry the
+// eventsink.SignatureHeader when the notifier has a secret.
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url, secret string, client *http.Client) *WebhookNotifier {
+	return &WebhookNotifier{url: url, secret: secret, client: client}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "marshal alert")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create alert webhook request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		req.Header.Set(eventsink.SignatureHeader, "sha256="+eventsink.Sign(n.secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send alert webhook request")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier mails alerts as plain text through an SMTP server.
+type EmailNotifier struct {
+	conf NotifierConfig
+	send func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEmailNotifier(conf NotifierConfig) *EmailNotifier {
+	return &EmailNotifier{conf: conf, send: smtp.SendMail}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, a Alert) error {
+	var auth smtp.Auth
+	if n.conf.Username != "" {
+		host, _, _ := net.SplitHostPort(n.conf.SMTPAddr)
+		auth = smtp.PlainAuth("", n.conf.Username, n.conf.Password, host)
+	}
+	err := n.send(n.conf.SMTPAddr, auth, n.conf.From, n.conf.To, n.message(a))
+	return errors.Wrapf(err, "send alert email through %s", n.conf.SMTPAddr)
+}
+
+// message formats the alert as a mail, with a line per event.
+func (n *EmailNotifier) message(a Alert) []byte {
+	var buf bytes.Buffer
+	subject := fmt.Sprintf("[moroz] %s: %d events", a.Rule, a.Count)
+	if a.Count == 1 {
+		subject = fmt.Sprintf("[moroz] %s: 1 event", a.Rule)
+	}
+	if machineID, ok := a.Group["machine_id"]; ok {
+		subject += " on " + machineID
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", n.conf.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.conf.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", sanitizeHeader(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	if a.Description != "" {
+		fmt.Fprintf(&buf, "%s\r\n\r\n", a.Description)
+	}
+	for _, field := range sortedKeys(a.Group) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", field, a.Group[field])
+	}
+	if a.Count == 1 {
+		fmt.Fprintf(&buf, "1 event received at %s.\r\n", a.FirstAt.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(&buf, "%d events received between %s and %s.\r\n", a.Count, a.FirstAt.Format(time.RFC3339), a.LastAt.Format(time.RFC3339))
+	}
+	if a.Count > len(a.Events) {
+		fmt.Fprintf(&buf, "The latest %d are:\r\n", len(a.Events))
+	}
+	buf.WriteString("\r\n")
+	for _, ev := range a.Events {
+		e := ev.Event.Event
+		fmt.Fprintf(&buf, "%s  %s  %s  %s  %s\r\n", ev.ReceivedAt.Format(time.RFC3339), ev.MachineID, e.Decision, e.FilePath, e.FileSHA256)
+	}
+	return buf.Bytes()
+}
+
+// sanitizeHeader keeps user provided values such as rule names on one header line.
+func sanitizeHeader(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
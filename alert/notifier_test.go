@@ -0,0 +1,94 @@
+package alert
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestEmailNotifier(t *testing.T) {
+	n := NewEmailNotifier(NotifierConfig{
+		Name:     "helpdesk",
+		Type:     "email",
+		SMTPAddr: "smtp.example.com:587",
+		Username: "moroz",
+		Password: "secret",
+		From:     "moroz@example.com",
+		To:       []string{"helpdesk@example.com", "security@example.com"},
+	})
+	var (
+		sentAddr string
+		sentAuth smtp.Auth
+		sentFrom string
+		sentTo   []string
+		mail     string
+	)
+	n.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sentAddr, sentAuth, sentFrom, sentTo, mail = addr, auth, from, to, string(msg)
+		return nil
+	}
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := Alert{
+		// a rule name can't add headers.
+		Rule:        "block\r\nBcc: attacker@example.com",
+		Description: "A binary was blocked.",
+		Group:       map[string]string{"machine_id": "M1"},
+		Count:       3,
+		FirstAt:     now,
+		LastAt:      now.Add(time.Minute),
+		Events:      []Event{event("M1", "BLOCK_BINARY", now)},
+	}
+	if err := n.Notify(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	if sentAddr != "smtp.example.com:587" || sentFrom != "moroz@example.com" || sentAuth == nil {
+		t.Errorf("have mail sent through %s from %s with auth %v, want smtp.example.com:587 from moroz@example.com with auth\n", sentAddr, sentFrom, sentAuth)
+	}
+	if len(sentTo) != 2 || sentTo[0] != "helpdesk@example.com" || sentTo[1] != "security@example.com" {
+		t.Errorf("have recipients %v, want helpdesk and security\n", sentTo)
+	}
+	header, body, ok := strings.Cut(mail, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("have mail %q, want headers and a body\n", mail)
+	}
+	for _, want := range []string{
+		"From: moroz@example.com",
+		"To: helpdesk@example.com, security@example.com",
+		"Subject: [moroz] block  Bcc: attacker@example.com: 3 events on M1",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header, want+"\r\n") && !strings.HasSuffix(header, want) {
+			t.Errorf("have headers %q, want %q\n", header, want)
+		}
+	}
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Errorf("have headers %q, want no Bcc header from the rule name\n", header)
+	}
+	for _, want := range []string{"A binary was blocked.", "machine_id: M1", "The latest 1 are:", "M1  BLOCK_BINARY"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("have body %q, want %q\n", body, want)
+		}
+	}
+
+	// without a username, mail is sent without auth.
+	n.conf.Username = ""
+	if err := n.Notify(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if sentAuth != nil {
+		t.Errorf("have auth %v, want none without a username\n", sentAuth)
+	}
+
+	failed := errors.New("connection refused")
+	n.send = func(string, smtp.Auth, string, []string, []byte) error { return failed }
+	if err := n.Notify(context.Background(), a); errors.Cause(err) != failed {
+		t.Errorf("have error %v, want %v\n", err, failed)
+	}
+}
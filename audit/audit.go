@@ -0,0 +1,93 @@
+// Package audit writes structured records of the requests made by Santa clients.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Record describes one sync request as a flat set of fields.
+// Every record has the event_type, machine_id, timestamp and took_ms fields,
+// and an error field when the request failed.
+type Record map[string]interface{}
+
+// Event types of the four sync stages.
+const (
+	Preflight    = "preflight"
+	RuleDownload = "ruledownload"
+	EventUpload  = "eventupload"
+	Postflight   = "postflight"
+)
+
+// NewRecord creates a Record with the fields common to every event type.
+func NewRecord(eventType, machineID string, begin time.Time, err error) Record {
+	r := Record{
+		"event_type": eventType,
+		"machine_id": machineID,
+		"timestamp":  begin.UTC().Format(time.RFC3339),
+		"took_ms":    time.Since(begin).Milliseconds(),
+	}
+	if err != nil {
+		r["error"] = err.Error()
+	}
+	return r
+}
+
+// EventType returns the event_type field of the record.
+func (r Record) EventType() string {
+	t, _ := r["event_type"].(string)
+	return t
+}
+
+// MachineID returns the machine_id field of the record.
+func (r Record) MachineID() string {
+	id, _ := r["machine_id"].(string)
+	return id
+}
+
+// Sink writes audit records.
+type Sink interface {
+	Write(ctx context.Context, r Record) error
+}
+
+// Multi writes each record to every sink, returning the first error.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Write(ctx context.Context, r Record) error {
+	var first error
+	for _, s := range m {
+		if err := s.Write(ctx, r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// SelectFields only passes the listed fields on to next.
+// The event_type and machine_id fields are always kept.
+func SelectFields(fields []string, next Sink) Sink {
+	keep := map[string]bool{"event_type": true, "machine_id": true}
+	for _, f := range fields {
+		keep[f] = true
+	}
+	return selectSink{keep: keep, next: next}
+}
+
+type selectSink struct {
+	keep map[string]bool
+	next Sink
+}
+
+func (s selectSink) Write(ctx context.Context, r Record) error {
+	selected := make(Record, len(s.keep))
+	for k, v := range r {
+		if s.keep[k] {
+			selected[k] = v
+		}
+	}
+	return s.next.Write(ctx, selected)
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileSink appends records as lines of JSON to a file, rotating it once it
+// grows past MaxBytes. Rotated files are renamed path.1, path.2, ... and
+// only the keep most recent ones are kept.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mtx  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending. A maxBytes of 0 disables rotation.
+func NewFileSink(path string, maxBytes int64, keep int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, keep: keep}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "open audit log %s", s.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "stat audit log %s", s.path)
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return errors.Wrapf(err, "close audit log %s", s.path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.keep))
+	for i := s.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if s.keep > 0 {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return errors.Wrapf(err, "rotate audit log %s", s.path)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return errors.Wrapf(err, "remove audit log %s", s.path)
+	}
+	return s.open()
+}
+
+func (s *FileSink) Write(ctx context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit record")
+	}
+	line = append(line, '\n')
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return errors.Wrapf(err, "write audit log %s", s.path)
+}
+
+// Close closes the current file.
+func (s *FileSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(ctx, NewRecord(Preflight, "machine", time.Now(), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("stat %s: %s\n", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("have %s size %d, want at most 200\n", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept\n")
+	}
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// HTTPSink pushes batches of records to a URL as newline delimited JSON.
+// Records are queued by Write and sent by Run; when the queue is full, new
+// records are dropped rather than slowing down sync requests.
+type HTTPSink struct {
+	url           string
+	client        *http.Client
+	batchSize     int
+	flushInterval time.Duration
+	logger        log.Logger
+
+	queue chan Record
+}
+
+// NewHTTPSink creates an HTTPSink posting to url.
+func NewHTTPSink(url string, client *http.Client, logger log.Logger) *HTTPSink {
+	return &HTTPSink{
+		url:           url,
+		client:        client,
+		batchSize:     100,
+		flushInterval: 5 * time.Second,
+		logger:        logger,
+		queue:         make(chan Record, 10000),
+	}
+}
+
+var errQueueFull = errors.New("audit queue is full, record dropped")
+
+func (s *HTTPSink) Write(ctx context.Context, r Record) error {
+	select {
+	case s.queue <- r:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Run sends queued records until ctx is cancelled, then flushes what is left.
+func (s *HTTPSink) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var batch []Record
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.post(batch); err != nil {
+			level.Info(s.logger).Log("msg", "push audit records", "count", len(batch), "err", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case r := <-s.queue:
+			batch = append(batch, r)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case r := <-s.queue:
+					batch = append(batch, r)
+				default:
+					flush()
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+func (s *HTTPSink) post(batch []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range batch {
+		if err := enc.Encode(r); err != nil {
+			return errors.Wrap(err, "marshal audit record")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return errors.Wrap(err, "create audit push request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send audit push request")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("audit push returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WriterSink writes each record as a line of JSON, ie. to os.Stdout.
+type WriterSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(ctx context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit record")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return errors.Wrap(err, "write audit record")
+}
@@ -0,0 +1,227 @@
+package catalog
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/santa"
+)
+
+// maxValues bounds the file names, paths and bundle versions kept for a
+// binary, and the team IDs kept for a certificate.
+const maxValues = 20
+
+// maxMachines bounds the machines listed in a record. Stores keep every
+// machine apart, and count them.
+const maxMachines = 100
+
+// Binary aggregates what the events of every machine reported about a binary.
+type Binary struct {
+	SHA256    string   `json:"sha256"`
+	FileNames []string `json:"file_names,omitempty"`
+	FilePaths []string `json:"file_paths,omitempty"`
+
+	BundleID       string   `json:"bundle_id,omitempty"`
+	BundleVersions []string `json:"bundle_versions,omitempty"`
+
+	TeamID       string               `json:"team_id,omitempty"`
+	SigningID    string               `json:"signing_id,omitempty"`
+	CDHash       string               `json:"cdhash,omitempty"`
+	SigningChain []santa.SigningEntry `json:"signing_chain,omitempty"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Machines lists up to 100 of the machines which reported the binary,
+	// and MachineCount counts all of them.
+	Machines     []string `json:"machines"`
+	MachineCount int      `json:"machine_count"`
+	// Decisions counts the events of the binary by decision.
+	Decisions map[string]int `json:"decisions"`
+}
+
+// Observe updates the binary from an event uploaded by a machine.
+func (b *Binary) Observe(machineID string, ev santa.EventUploadEvent, now time.Time) {
+	b.SHA256 = ev.FileSHA256
+	if b.FirstSeen.IsZero() {
+		b.FirstSeen = now
+	}
+	b.LastSeen = now
+	b.FileNames = addValue(b.FileNames, ev.FileName)
+	b.FilePaths = addValue(b.FilePaths, ev.FilePath)
+	if ev.FileBundleID != "" {
+		b.BundleID = ev.FileBundleID
+	}
+	version := ev.FileBundleShortVersionString
+	if ev.FileBundleVersion != "" {
+		version += " (" + ev.FileBundleVersion + ")"
+	}
+	b.BundleVersions = addValue(b.BundleVersions, version)
+	if ev.TeamID != "" {
+		b.TeamID = ev.TeamID
+	}
+	if ev.SigningID != "" {
+		b.SigningID = ev.SigningID
+	}
+	if ev.CdHash != "" {
+		b.CDHash = ev.CdHash
+	}
+	if len(ev.SigningChain) > 0 {
+		b.SigningChain = ev.SigningChain
+	}
+	b.Machines = addMachine(b.Machines, machineID)
+	if len(b.Machines) > b.MachineCount {
+		b.MachineCount = len(b.Machines)
+	}
+	if b.Decisions == nil {
+		b.Decisions = make(map[string]int)
+	}
+	if ev.Decision != "" {
+		b.Decisions[ev.Decision]++
+	}
+}
+
+// addValue appends v to values when it is new, keeping at most maxValues.
+func addValue(values []string, v string) []string {
+	if v == "" || slices.Contains(values, v) || len(values) >= maxValues {
+		return values
+	}
+	return append(values, v)
+}
+
+// addMachine appends machineID to machines when it is new, keeping at most maxMachines.
+func addMachine(machines []string, machineID string) []string {
+	if machineID == "" || slices.Contains(machines, machineID) || len(machines) >= maxMachines {
+		return machines
+	}
+	return append(machines, machineID)
+}
+
+const (
+	// DefaultLimit is the page size used when a query doesn't set one.
+	DefaultLimit = 100
+	// MaxLimit is the largest page size a store returns.
+	MaxLimit = 1000
+)
+
+// ErrInvalidCursor is returned for a query with a cursor the store didn't return.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// BinaryQuery selects binaries. Empty fields match every binary.
+type BinaryQuery struct {
+	TeamID    string
+	SigningID string
+	BundleID  string
+	// Decision selects binaries with at least one event with the decision.
+	Decision string
+	// CertSHA256 selects binaries with the certificate anywhere in their signing chain.
+	CertSHA256 string
+
+	// Limit and Cursor page through the results, the binaries seen on the
+	// most machines first. Cursor is the Next value of the previous page, and
+	// holds the machine count and SHA-256 of its last binary.
+	Limit  int
+	Cursor string
+}
+
+// PageLimit returns the page size of q, bounded by MaxLimit.
+func (q BinaryQuery) PageLimit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+// binaryCursor is the position of a binary in the results.
+type binaryCursor struct {
+	machineCount int
+	sha256       string
+}
+
+func parseBinaryCursor(cursor string) (*binaryCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	count, sha256, ok := strings.Cut(cursor, ":")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 0 || sha256 == "" {
+		return nil, errors.Wrapf(ErrInvalidCursor, "cursor %q", cursor)
+	}
+	return &binaryCursor{machineCount: n, sha256: sha256}, nil
+}
+
+func (c binaryCursor) String() string {
+	return strconv.Itoa(c.machineCount) + ":" + c.sha256
+}
+
+// after reports whether b comes after the cursor in the results.
+func (c *binaryCursor) after(b Binary) bool {
+	return c == nil || b.MachineCount < c.machineCount || b.MachineCount == c.machineCount && b.SHA256 > c.sha256
+}
+
+// Match reports whether b is selected by q.
+func (q BinaryQuery) Match(b Binary) bool {
+	switch {
+	case q.TeamID != "" && q.TeamID != b.TeamID:
+		return false
+	case q.SigningID != "" && q.SigningID != b.SigningID:
+		return false
+	case q.BundleID != "" && q.BundleID != b.BundleID:
+		return false
+	case q.Decision != "" && b.Decisions[q.Decision] == 0:
+		return false
+	case q.CertSHA256 != "" && !signedBy(b.SigningChain, q.CertSHA256):
+		return false
+	}
+	return true
+}
+
+func signedBy(chain []santa.SigningEntry, certSHA256 string) bool {
+	for _, cert := range chain {
+		if cert.SHA256 == certSHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// BinaryPage is a page of query results.
+// Next is empty when there are no more results.
+type BinaryPage struct {
+	Binaries []Binary `json:"binaries"`
+	Next     string   `json:"next,omitempty"`
+}
+
+// sortBinaries orders binaries as the results: seen on the most machines
+// first, then by SHA-256.
+func sortBinaries(binaries []Binary) {
+	sort.SliceStable(binaries, func(i, j int) bool {
+		if binaries[i].MachineCount != binaries[j].MachineCount {
+			return binaries[i].MachineCount > binaries[j].MachineCount
+		}
+		return binaries[i].SHA256 < binaries[j].SHA256
+	})
+}
+
+// binaryPage returns the page of the sorted binaries, which hold one more
+// binary than the page when there is a next page.
+func binaryPage(binaries []Binary, limit int) BinaryPage {
+	page := BinaryPage{Binaries: binaries}
+	if len(binaries) > limit {
+		page.Binaries = binaries[:limit]
+		last := binaries[limit-1]
+		page.Next = binaryCursor{machineCount: last.MachineCount, sha256: last.SHA256}.String()
+	}
+	if page.Binaries == nil {
+		page.Binaries = []Binary{}
+	}
+	return page
+}
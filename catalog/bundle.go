@@ -0,0 +1,111 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/groob/moroz/santa"
+)
+
+// Bundle is an application bundle, identified by the hash Santa computes
+// over the binaries it contains.
+type Bundle struct {
+	Hash          string `json:"hash"`
+	BundleID      string `json:"bundle_id"`
+	Name          string `json:"name"`
+	Path          string `json:"path"`
+	Version       string `json:"version"`
+	VersionString string `json:"version_string"`
+	// BinaryCount is the number of binaries in the bundle, as reported by Santa.
+	BinaryCount int64 `json:"binary_count"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// RequestedAt is when a machine was last asked to upload the binaries of the bundle.
+	RequestedAt time.Time `json:"requested_at"`
+
+	// Binaries are the binaries uploaded as BUNDLE_BINARY events.
+	Binaries []BundleBinary `json:"binaries"`
+}
+
+// BundleBinary is a binary of a bundle.
+type BundleBinary struct {
+	SHA256    string `json:"sha256"`
+	Path      string `json:"path"`
+	SigningID string `json:"signing_id,omitempty"`
+	TeamID    string `json:"team_id,omitempty"`
+	CDHash    string `json:"cdhash,omitempty"`
+}
+
+// Observe updates the bundle from an event executed from it. A BUNDLE_BINARY
+// event adds its binary to the bundle.
+func (b *Bundle) Observe(ev santa.EventUploadEvent, now time.Time) {
+	b.Hash = ev.FileBundleHash
+	if b.FirstSeen.IsZero() {
+		b.FirstSeen = now
+	}
+	b.LastSeen = now
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&b.BundleID, ev.FileBundleID)
+	set(&b.Name, ev.FileBundleName)
+	set(&b.Path, ev.FileBundlePath)
+	set(&b.Version, ev.FileBundleVersion)
+	set(&b.VersionString, ev.FileBundleShortVersionString)
+	if ev.FileBundleBinaryCount > 0 {
+		b.BinaryCount = ev.FileBundleBinaryCount
+	}
+
+	if ev.Decision != "BUNDLE_BINARY" || ev.FileSHA256 == "" {
+		return
+	}
+	path := ev.FileBundleExecutableRelPath
+	if path == "" {
+		path = ev.FilePath
+	}
+	for _, bin := range b.Binaries {
+		if bin.SHA256 == ev.FileSHA256 && bin.Path == path {
+			return
+		}
+	}
+	b.Binaries = append(b.Binaries, BundleBinary{
+		SHA256:    ev.FileSHA256,
+		Path:      path,
+		SigningID: ev.SigningID,
+		TeamID:    ev.TeamID,
+		CDHash:    ev.CdHash,
+	})
+}
+
+// Complete reports whether every binary of the bundle was uploaded.
+func (b Bundle) Complete() bool {
+	return b.BinaryCount > 0 && int64(len(b.Binaries)) >= b.BinaryCount
+}
+
+// Rules returns a BINARY rule with policy for every binary of the bundle.
+// The rules carry the bundle hash and binary count, so Santa knows they
+// cover the whole bundle.
+func (b Bundle) Rules(policy santa.Policy) []santa.Rule {
+	var (
+		rules []santa.Rule
+		seen  = make(map[string]bool)
+		hash  = b.Hash
+		count = int(b.BinaryCount)
+	)
+	for _, bin := range b.Binaries {
+		if seen[bin.SHA256] {
+			continue
+		}
+		seen[bin.SHA256] = true
+		rules = append(rules, santa.Rule{
+			RuleType:              santa.Binary,
+			Policy:                policy,
+			Identifier:            bin.SHA256,
+			FileBundleHash:        &hash,
+			FileBundleBinaryCount: &count,
+		})
+	}
+	return rules
+}
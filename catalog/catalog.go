@@ -0,0 +1,25 @@
+// Package catalog aggregates what machines report in their events, such as
+// the binaries of application bundles, into records kept across uploads.
+package catalog
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when a record is not in the catalog.
+var ErrNotFound = errors.New("not found in catalog")
+
+// IsNotFound reports whether err means the record is not in the catalog.
+func IsNotFound(err error) bool {
+	return errors.Cause(err) == ErrNotFound
+}
+
+// validKey rejects keys which can't safely be used as a file name.
+func validKey(key string) error {
+	if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+		return errors.Errorf("invalid catalog key %q", key)
+	}
+	return nil
+}
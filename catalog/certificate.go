@@ -0,0 +1,74 @@
+package catalog
+
+import (
+	"slices"
+	"time"
+
+	"github.com/groob/moroz/santa"
+)
+
+// maxCertBinaries bounds the binaries kept for a certificate. Certificates
+// such as Apple's sign more binaries than are useful to list.
+const maxCertBinaries = 1000
+
+// Certificate aggregates the signing chains which contained a certificate.
+type Certificate struct {
+	SHA256             string    `json:"sha256"`
+	CommonName         string    `json:"cn"`
+	Organization       string    `json:"org,omitempty"`
+	OrganizationalUnit string    `json:"ou,omitempty"`
+	ValidFrom          time.Time `json:"valid_from"`
+	ValidUntil         time.Time `json:"valid_until"`
+	// Leaf is set when the certificate signed a binary directly, so CERTIFICATE
+	// rules can match it.
+	Leaf bool `json:"leaf"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Binaries lists the SHA-256 of up to 1000 binaries in whose signing chain the certificate was.
+	Binaries []string `json:"binaries"`
+	// TeamIDs are up to 20 of the team IDs of the binaries.
+	TeamIDs []string `json:"team_ids,omitempty"`
+	// Machines lists up to 100 of the machines which reported the
+	// certificate, and MachineCount counts all of them.
+	Machines     []string `json:"machines"`
+	MachineCount int      `json:"machine_count"`
+}
+
+// Observe updates the certificate from the event of a machine, where it is
+// the entry at position in the signing chain.
+func (c *Certificate) Observe(machineID string, ev santa.EventUploadEvent, position int, now time.Time) {
+	entry := ev.SigningChain[position]
+	c.SHA256 = entry.SHA256
+	c.CommonName = entry.CertificateName
+	c.Organization = entry.Organization
+	c.OrganizationalUnit = entry.OrganizationalUnit
+	if entry.ValidFrom != 0 {
+		c.ValidFrom = time.Unix(int64(entry.ValidFrom), 0).UTC()
+	}
+	if entry.ValidUntil != 0 {
+		c.ValidUntil = time.Unix(int64(entry.ValidUntil), 0).UTC()
+	}
+	if position == 0 {
+		c.Leaf = true
+	}
+	if c.FirstSeen.IsZero() {
+		c.FirstSeen = now
+	}
+	c.LastSeen = now
+
+	if ev.FileSHA256 != "" && len(c.Binaries) < maxCertBinaries && !slices.Contains(c.Binaries, ev.FileSHA256) {
+		c.Binaries = append(c.Binaries, ev.FileSHA256)
+	}
+	c.TeamIDs = addValue(c.TeamIDs, ev.TeamID)
+	c.Machines = addMachine(c.Machines, machineID)
+	if len(c.Machines) > c.MachineCount {
+		c.MachineCount = len(c.Machines)
+	}
+}
+
+// Expired reports whether the certificate is no longer valid at t.
+func (c Certificate) Expired(t time.Time) bool {
+	return !c.ValidUntil.IsZero() && !t.Before(c.ValidUntil)
+}
@@ -0,0 +1,25 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestCertificateObserveBounds(t *testing.T) {
+	var cert Certificate
+	now := time.Now().UTC()
+	chain := []santa.SigningEntry{{SHA256: "cert", CertificateName: "Developer ID"}}
+	for i := 0; i < 2*maxMachines; i++ {
+		ev := santa.EventUploadEvent{FileSHA256: fmt.Sprintf("sha%d", i), TeamID: fmt.Sprintf("TEAM%d", i), SigningChain: chain}
+		cert.Observe(fmt.Sprintf("M%d", i), ev, 0, now)
+	}
+	if len(cert.TeamIDs) != maxValues || cert.TeamIDs[0] != "TEAM0" {
+		t.Errorf("have %d team IDs, want the first %d\n", len(cert.TeamIDs), maxValues)
+	}
+	if len(cert.Machines) != maxMachines || cert.MachineCount != maxMachines {
+		t.Errorf("have %d machines counted %d, want %d\n", len(cert.Machines), cert.MachineCount, maxMachines)
+	}
+}
@@ -0,0 +1,229 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// FileStore keeps one JSON file per record, in a subdirectory per kind of record.
+type FileStore struct {
+	mtx sync.RWMutex
+	dir string
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating the directories if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, kind := range []string{"bundles", "binaries", "certificates"} {
+		if err := os.MkdirAll(filepath.Join(dir, kind), 0750); err != nil {
+			return nil, errors.Wrapf(err, "create catalog directory %s", dir)
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) Bundle(ctx context.Context, hash string) (Bundle, error) {
+	var b Bundle
+	err := f.read("bundles", hash, &b)
+	return b, errors.Wrapf(err, "bundle %s", hash)
+}
+
+// Bundles returns every bundle, ordered by hash.
+func (f *FileStore) Bundles(ctx context.Context) ([]Bundle, error) {
+	var bundles []Bundle
+	err := f.list("bundles", func(data []byte) error {
+		var b Bundle
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		bundles = append(bundles, b)
+		return nil
+	})
+	return bundles, err
+}
+
+func (f *FileStore) SaveBundle(ctx context.Context, b Bundle) error {
+	return f.write("bundles", b.Hash, b)
+}
+
+func (f *FileStore) Binary(ctx context.Context, sha256 string) (Binary, error) {
+	var b Binary
+	err := f.read("binaries", sha256, &b)
+	return b, errors.Wrapf(err, "binary %s", sha256)
+}
+
+// Binaries scans the binaries. It is meant for small deployments.
+func (f *FileStore) Binaries(ctx context.Context, q BinaryQuery) (BinaryPage, error) {
+	after, err := parseBinaryCursor(q.Cursor)
+	if err != nil {
+		return BinaryPage{}, err
+	}
+	limit := q.PageLimit()
+	var binaries []Binary
+	err = f.list("binaries", func(data []byte) error {
+		var b Binary
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		if !q.Match(b) || !after.after(b) {
+			return nil
+		}
+		binaries = append(binaries, b)
+		// keep the page and the binary telling whether there is a next page.
+		if len(binaries) > 2*(limit+1) {
+			sortBinaries(binaries)
+			binaries = binaries[:limit+1]
+		}
+		return nil
+	})
+	if err != nil {
+		return BinaryPage{}, err
+	}
+	sortBinaries(binaries)
+	if len(binaries) > limit+1 {
+		binaries = binaries[:limit+1]
+	}
+	return binaryPage(binaries, limit), nil
+}
+
+// AddBinaryMachine records that machineID reported the binary, and returns
+// the number of machines which did.
+func (f *FileStore) AddBinaryMachine(ctx context.Context, sha256, machineID string) (int, error) {
+	n, err := f.addMachine("binaries", sha256, machineID)
+	return n, errors.Wrapf(err, "binary %s", sha256)
+}
+
+func (f *FileStore) SaveBinary(ctx context.Context, b Binary) error {
+	return f.write("binaries", b.SHA256, b)
+}
+
+func (f *FileStore) Certificate(ctx context.Context, sha256 string) (Certificate, error) {
+	var c Certificate
+	err := f.read("certificates", sha256, &c)
+	return c, errors.Wrapf(err, "certificate %s", sha256)
+}
+
+// Certificates returns every certificate, ordered by SHA-256.
+func (f *FileStore) Certificates(ctx context.Context) ([]Certificate, error) {
+	var certs []Certificate
+	err := f.list("certificates", func(data []byte) error {
+		var c Certificate
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		certs = append(certs, c)
+		return nil
+	})
+	return certs, err
+}
+
+func (f *FileStore) SaveCertificate(ctx context.Context, c Certificate) error {
+	return f.write("certificates", c.SHA256, c)
+}
+
+// AddCertificateMachine records that machineID reported the certificate, and
+// returns the number of machines which did.
+func (f *FileStore) AddCertificateMachine(ctx context.Context, sha256, machineID string) (int, error) {
+	n, err := f.addMachine("certificates", sha256, machineID)
+	return n, errors.Wrapf(err, "certificate %s", sha256)
+}
+
+func (f *FileStore) read(kind, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	data, err := os.ReadFile(filepath.Join(f.dir, kind, key+".json"))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.Wrapf(err, "read %s %s", kind, key)
+	}
+	return errors.Wrapf(json.Unmarshal(data, v), "decode %s %s", kind, key)
+}
+
+// list calls fn with the data of every record of kind, ordered by key.
+func (f *FileStore) list(kind string, fn func(data []byte) error) error {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(f.dir, kind))
+	if err != nil {
+		return errors.Wrapf(err, "list %s directory", kind)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, kind, entry.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "read %s file %s", kind, entry.Name())
+		}
+		if err := fn(data); err != nil {
+			return errors.Wrapf(err, "decode %s file %s", kind, entry.Name())
+		}
+	}
+	return nil
+}
+
+// addMachine appends machineID to the machines file of a record, one ID per
+// line, unless it is already there, and returns the number of machines.
+func (f *FileStore) addMachine(kind, key, machineID string) (int, error) {
+	if err := validKey(key); err != nil {
+		return 0, err
+	}
+	if machineID == "" || strings.ContainsAny(machineID, "\r\n") {
+		return 0, errors.Errorf("invalid machine ID %q", machineID)
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	path := filepath.Join(f.dir, kind, key+".machines")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrapf(err, "read %s machines", kind)
+	}
+	machines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		machines = nil
+	}
+	if slices.Contains(machines, machineID) {
+		return len(machines), nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open %s machines", kind)
+	}
+	_, err = file.WriteString(machineID + "\n")
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return len(machines) + 1, errors.Wrapf(err, "append to %s machines", kind)
+}
+
+func (f *FileStore) write(kind, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s to json", kind)
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	path := filepath.Join(f.dir, kind, key+".json")
+	return errors.Wrapf(storage.WriteFileAtomic(path, append(data, '\n')), "save %s %s", kind, key)
+}
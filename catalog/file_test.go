@@ -0,0 +1,23 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFileStoreBinaries(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBinaries(t, store)
+
+	// a machine is counted once, and IDs can't break the machines file.
+	ctx := context.Background()
+	if n, err := store.AddBinaryMachine(ctx, "chrome", "M1"); err != nil || n != 2 {
+		t.Errorf("have %d machines and error %v, want 2\n", n, err)
+	}
+	if _, err := store.AddBinaryMachine(ctx, "chrome", "M3\nM4"); err == nil {
+		t.Error("machine ID with a newline added without an error")
+	}
+}
@@ -0,0 +1,259 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// SQLStore keeps the catalog in a SQLite database, with a table per kind of record.
+type SQLStore struct {
+	db *sql.DB
+}
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS catalog_bundles (
+	bundle_hash TEXT PRIMARY KEY,
+	bundle_id   TEXT NOT NULL DEFAULT '',
+	last_seen   INTEGER NOT NULL,
+	data        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS catalog_bundles_bundle_id ON catalog_bundles (bundle_id);
+CREATE TABLE IF NOT EXISTS catalog_binaries (
+	sha256        TEXT PRIMARY KEY,
+	team_id       TEXT NOT NULL DEFAULT '',
+	signing_id    TEXT NOT NULL DEFAULT '',
+	bundle_id     TEXT NOT NULL DEFAULT '',
+	machine_count INTEGER NOT NULL,
+	last_seen     INTEGER NOT NULL,
+	data          TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS catalog_binaries_team_id ON catalog_binaries (team_id);
+CREATE INDEX IF NOT EXISTS catalog_binaries_signing_id ON catalog_binaries (signing_id);
+CREATE INDEX IF NOT EXISTS catalog_binaries_bundle_id ON catalog_binaries (bundle_id);
+CREATE INDEX IF NOT EXISTS catalog_binaries_machine_count ON catalog_binaries (machine_count DESC, sha256);
+CREATE TABLE IF NOT EXISTS catalog_binary_machines (
+	sha256     TEXT NOT NULL,
+	machine_id TEXT NOT NULL,
+	PRIMARY KEY (sha256, machine_id)
+);
+CREATE TABLE IF NOT EXISTS catalog_certificates (
+	sha256      TEXT PRIMARY KEY,
+	common_name TEXT NOT NULL DEFAULT '',
+	valid_until INTEGER NOT NULL,
+	data        TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS catalog_certificate_machines (
+	sha256     TEXT NOT NULL,
+	machine_id TEXT NOT NULL,
+	PRIMARY KEY (sha256, machine_id)
+)
+`
+
+// NewSQLStore creates a SQLStore, creating the catalog tables if they don't exist.
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	if err := storage.CreateTables(ctx, db, catalogSchema); err != nil {
+		return nil, errors.Wrap(err, "create catalog tables")
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Bundle(ctx context.Context, hash string) (Bundle, error) {
+	var b Bundle
+	err := s.read(ctx, `SELECT data FROM catalog_bundles WHERE bundle_hash = ?`, hash, &b)
+	return b, errors.Wrapf(err, "bundle %s", hash)
+}
+
+// Bundles returns every bundle, ordered by hash.
+func (s *SQLStore) Bundles(ctx context.Context) ([]Bundle, error) {
+	var bundles []Bundle
+	err := s.list(ctx, `SELECT data FROM catalog_bundles ORDER BY bundle_hash`, func(data []byte) error {
+		var b Bundle
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		bundles = append(bundles, b)
+		return nil
+	})
+	return bundles, errors.Wrap(err, "list bundles")
+}
+
+func (s *SQLStore) SaveBundle(ctx context.Context, b Bundle) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return errors.Wrap(err, "marshal bundle to json")
+	}
+	err = storage.Upsert(ctx, s.db,
+		`UPDATE catalog_bundles SET bundle_id = ?, last_seen = ?, data = ? WHERE bundle_hash = ?`,
+		`INSERT INTO catalog_bundles (bundle_id, last_seen, data, bundle_hash) VALUES (?, ?, ?, ?)`,
+		b.BundleID, b.LastSeen.Unix(), string(data), b.Hash,
+	)
+	return errors.Wrapf(err, "save bundle %s", b.Hash)
+}
+
+func (s *SQLStore) Binary(ctx context.Context, sha256 string) (Binary, error) {
+	var b Binary
+	err := s.read(ctx, `SELECT data FROM catalog_binaries WHERE sha256 = ?`, sha256, &b)
+	return b, errors.Wrapf(err, "binary %s", sha256)
+}
+
+// Binaries selects a page of binaries, seen on the most machines first.
+// The signing chain and decisions are matched in the JSON data.
+func (s *SQLStore) Binaries(ctx context.Context, q BinaryQuery) (BinaryPage, error) {
+	after, err := parseBinaryCursor(q.Cursor)
+	if err != nil {
+		return BinaryPage{}, err
+	}
+	var (
+		conds = []string{"1 = 1"}
+		args  []interface{}
+	)
+	eq := func(column, value string) {
+		if value != "" {
+			conds = append(conds, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	eq("team_id", q.TeamID)
+	eq("signing_id", q.SigningID)
+	eq("bundle_id", q.BundleID)
+	if q.Decision != "" {
+		conds = append(conds, `COALESCE(json_extract(data, ?), 0) > 0`)
+		args = append(args, `$.decisions."`+q.Decision+`"`)
+	}
+	if q.CertSHA256 != "" {
+		conds = append(conds, `EXISTS (SELECT 1 FROM json_each(data, '$.signing_chain') WHERE json_extract(value, '$.sha256') = ?)`)
+		args = append(args, q.CertSHA256)
+	}
+	if after != nil {
+		conds = append(conds, `(machine_count < ? OR machine_count = ? AND sha256 > ?)`)
+		args = append(args, after.machineCount, after.machineCount, after.sha256)
+	}
+	limit := q.PageLimit()
+	args = append(args, limit+1)
+
+	var binaries []Binary
+	query := `SELECT data FROM catalog_binaries WHERE ` + strings.Join(conds, " AND ") +
+		` ORDER BY machine_count DESC, sha256 LIMIT ?`
+	err = s.list(ctx, query, func(data []byte) error {
+		var b Binary
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		binaries = append(binaries, b)
+		return nil
+	}, args...)
+	if err != nil {
+		return BinaryPage{}, errors.Wrap(err, "list binaries")
+	}
+	return binaryPage(binaries, limit), nil
+}
+
+// AddBinaryMachine records that machineID reported the binary, and returns
+// the number of machines which did.
+func (s *SQLStore) AddBinaryMachine(ctx context.Context, sha256, machineID string) (int, error) {
+	n, err := s.addMachine(ctx, "catalog_binary_machines", sha256, machineID)
+	return n, errors.Wrapf(err, "binary %s", sha256)
+}
+
+func (s *SQLStore) SaveBinary(ctx context.Context, b Binary) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return errors.Wrap(err, "marshal binary to json")
+	}
+	err = storage.Upsert(ctx, s.db,
+		`UPDATE catalog_binaries SET team_id = ?, signing_id = ?, bundle_id = ?, machine_count = ?, last_seen = ?, data = ?
+		WHERE sha256 = ?`,
+		`INSERT INTO catalog_binaries (team_id, signing_id, bundle_id, machine_count, last_seen, data, sha256)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		b.TeamID, b.SigningID, b.BundleID, b.MachineCount, b.LastSeen.Unix(), string(data), b.SHA256,
+	)
+	return errors.Wrapf(err, "save binary %s", b.SHA256)
+}
+
+func (s *SQLStore) Certificate(ctx context.Context, sha256 string) (Certificate, error) {
+	var c Certificate
+	err := s.read(ctx, `SELECT data FROM catalog_certificates WHERE sha256 = ?`, sha256, &c)
+	return c, errors.Wrapf(err, "certificate %s", sha256)
+}
+
+// Certificates returns every certificate, ordered by SHA-256.
+func (s *SQLStore) Certificates(ctx context.Context) ([]Certificate, error) {
+	var certs []Certificate
+	err := s.list(ctx, `SELECT data FROM catalog_certificates ORDER BY sha256`, func(data []byte) error {
+		var c Certificate
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		certs = append(certs, c)
+		return nil
+	})
+	return certs, errors.Wrap(err, "list certificates")
+}
+
+func (s *SQLStore) SaveCertificate(ctx context.Context, c Certificate) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "marshal certificate to json")
+	}
+	err = storage.Upsert(ctx, s.db,
+		`UPDATE catalog_certificates SET common_name = ?, valid_until = ?, data = ? WHERE sha256 = ?`,
+		`INSERT INTO catalog_certificates (common_name, valid_until, data, sha256) VALUES (?, ?, ?, ?)`,
+		c.CommonName, c.ValidUntil.Unix(), string(data), c.SHA256,
+	)
+	return errors.Wrapf(err, "save certificate %s", c.SHA256)
+}
+
+// AddCertificateMachine records that machineID reported the certificate, and
+// returns the number of machines which did.
+func (s *SQLStore) AddCertificateMachine(ctx context.Context, sha256, machineID string) (int, error) {
+	n, err := s.addMachine(ctx, "catalog_certificate_machines", sha256, machineID)
+	return n, errors.Wrapf(err, "certificate %s", sha256)
+}
+
+// addMachine inserts machineID for the record unless it is already in table,
+// and returns the number of machines of the record.
+func (s *SQLStore) addMachine(ctx context.Context, table, sha256, machineID string) (int, error) {
+	_, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO `+table+` (sha256, machine_id) VALUES (?, ?)`, sha256, machineID)
+	if err != nil {
+		return 0, errors.Wrap(err, "insert machine")
+	}
+	var n int
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE sha256 = ?`, sha256).Scan(&n)
+	return n, errors.Wrap(err, "count machines")
+}
+
+func (s *SQLStore) read(ctx context.Context, query, key string, v interface{}) error {
+	var data string
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "select")
+	}
+	return errors.Wrap(json.Unmarshal([]byte(data), v), "decode")
+}
+
+// list calls fn with the data column of every row selected by query.
+func (s *SQLStore) list(ctx context.Context, query string, fn func(data []byte) error, args ...interface{}) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "select")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return errors.Wrap(err, "scan row")
+		}
+		if err := fn([]byte(data)); err != nil {
+			return errors.Wrap(err, "decode row")
+		}
+	}
+	return errors.Wrap(rows.Err(), "iterate rows")
+}
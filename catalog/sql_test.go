@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestSQLStoreBinaries(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewSQLStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBinaries(t, store)
+}
+
+type binaryStore interface {
+	Binary(ctx context.Context, sha256 string) (Binary, error)
+	Binaries(ctx context.Context, q BinaryQuery) (BinaryPage, error)
+	SaveBinary(ctx context.Context, b Binary) error
+	AddBinaryMachine(ctx context.Context, sha256, machineID string) (int, error)
+}
+
+// testBinaries checks a store the way the service records binaries.
+func testBinaries(t *testing.T, store binaryStore) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	observe := func(b *Binary, machineID string, ev santa.EventUploadEvent) {
+		t.Helper()
+		b.Observe(machineID, ev, now)
+		n, err := store.AddBinaryMachine(ctx, ev.FileSHA256, machineID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.MachineCount = n
+	}
+
+	chain := []santa.SigningEntry{{SHA256: "cert", CertificateName: "Developer ID Application: Example"}}
+	var chrome Binary
+	for _, machineID := range []string{"M1", "M2", "M1"} {
+		observe(&chrome, machineID, santa.EventUploadEvent{
+			FileSHA256:   "chrome",
+			FileName:     "Google Chrome",
+			Decision:     "BLOCK_UNKNOWN",
+			TeamID:       "EQHXZ8M8AV",
+			SigningChain: chain,
+		})
+	}
+	var helper Binary
+	observe(&helper, "M1", santa.EventUploadEvent{FileSHA256: "helper", Decision: "ALLOW_BINARY", TeamID: "EQHXZ8M8AV"})
+	var shell Binary
+	for i := 0; i < maxMachines+50; i++ {
+		observe(&shell, "M"+strconv.Itoa(i), santa.EventUploadEvent{FileSHA256: "shell", Decision: "ALLOW_BINARY"})
+	}
+	for _, b := range []Binary{helper, chrome, shell} {
+		if err := store.SaveBinary(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := store.Binary(ctx, "chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MachineCount != 2 || b.Decisions["BLOCK_UNKNOWN"] != 3 || len(b.FileNames) != 1 {
+		t.Errorf("have binary %+v, want 2 machines and 3 blocks\n", b)
+	}
+	b, err = store.Binary(ctx, "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(b.Machines), maxMachines; have != want || b.MachineCount != maxMachines+50 {
+		t.Errorf("have %d machines listed of %d, want %d of %d\n", have, b.MachineCount, want, maxMachines+50)
+	}
+	if _, err := store.Binary(ctx, "missing"); !IsNotFound(err) {
+		t.Errorf("have error %v, want not found\n", err)
+	}
+
+	page, err := store.Binaries(ctx, BinaryQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "shell" || page.Next == "" {
+		t.Fatalf("have page %+v, want shell first, with a cursor\n", page)
+	}
+	// a binary moving ahead of the cursor between pages doesn't shift the next page.
+	helper.MachineCount = maxMachines + 100
+	if err := store.SaveBinary(ctx, helper); err != nil {
+		t.Fatal(err)
+	}
+	page, err = store.Binaries(ctx, BinaryQuery{Limit: 1, Cursor: page.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "chrome" || page.Next != "" {
+		t.Errorf("have page %+v, want chrome last\n", page)
+	}
+
+	page, err = store.Binaries(ctx, BinaryQuery{CertSHA256: "cert", Decision: "BLOCK_UNKNOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "chrome" {
+		t.Errorf("have page %+v, want chrome signed by cert\n", page)
+	}
+	page, err = store.Binaries(ctx, BinaryQuery{TeamID: "EQHXZ8M8AV", Decision: "ALLOW_BINARY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "helper" {
+		t.Errorf("have page %+v, want the allowed helper\n", page)
+	}
+
+	for _, cursor := range []string{"chrome", "-1:chrome", "2:"} {
+		if _, err := store.Binaries(ctx, BinaryQuery{Cursor: cursor}); errors.Cause(err) != ErrInvalidCursor {
+			t.Errorf("have error %v for cursor %q, want %v\n", err, cursor, ErrInvalidCursor)
+		}
+	}
+}
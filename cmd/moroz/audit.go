@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventsink"
+)
+
+type auditFlags struct {
+	sinks  string
+	file   string
+	maxMB  int
+	keep   int
+	url    string
+	fields string
+	syslog *eventsink.Syslog
+	loki   *eventsink.Loki
+}
+
+// openAuditSink builds the audit sink from a comma separated list of sink names.
+// It returns nil when audit logging is disabled. The HTTP sink, if any, is
+// returned separately so that it can be run.
+func openAuditSink(fl auditFlags, logger log.Logger) (audit.Sink, *audit.HTTPSink, error) {
+	var (
+		sinks   []audit.Sink
+		httpOut *audit.HTTPSink
+	)
+	for _, name := range strings.Split(fl.sinks, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "stdout":
+			sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+		case "file":
+			s, err := audit.NewFileSink(fl.file, int64(fl.maxMB)<<20, fl.keep)
+			if err != nil {
+				return nil, nil, err
+			}
+			sinks = append(sinks, s)
+		case "http":
+			if fl.url == "" {
+				return nil, nil, errors.New("the http audit sink requires -audit-url")
+			}
+			httpOut = audit.NewHTTPSink(fl.url, &http.Client{Timeout: 30 * time.Second}, logger)
+			sinks = append(sinks, httpOut)
+		case "syslog":
+			if fl.syslog == nil {
+				return nil, nil, errors.New("the syslog audit sink requires -syslog-addr")
+			}
+			sinks = append(sinks, fl.syslog)
+		case "loki":
+			if fl.loki == nil {
+				return nil, nil, errors.New("the loki audit sink requires -loki-url")
+			}
+			sinks = append(sinks, fl.loki)
+		default:
+			return nil, nil, errors.Errorf("unknown audit sink %q", name)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, nil, nil
+	}
+
+	sink := audit.Multi(sinks...)
+	if fl.fields != "" {
+		sink = audit.SelectFields(strings.Split(fl.fields, ","), sink)
+	}
+	return sink, httpOut, nil
+}
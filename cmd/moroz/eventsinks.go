@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/alert"
+	"github.com/groob/moroz/eventsink"
+	"github.com/groob/moroz/moroz"
+)
+
+// runner is a sink which delivers queued events from its Run method.
+type runner interface {
+	Run(ctx context.Context) error
+}
+
+type eventSinkFlags struct {
+	webhooks     string
+	webhookQueue string
+
+	// syslog also receives audit records with `-audit-sink syslog`.
+	syslog       *eventsink.Syslog
+	syslogEvents bool
+
+	// loki also receives audit records with `-audit-sink loki`.
+	loki       *eventsink.Loki
+	lokiEvents bool
+
+	elasticsearch      eventsink.ElasticsearchConfig
+	elasticsearchQueue string
+}
+
+// openEventSinks creates the configured event sinks. Every sink is also returned
+// as a runner, to be added to the run group.
+func openEventSinks(fl eventSinkFlags, logger log.Logger) ([]moroz.EventSink, []runner, error) {
+	var (
+		sinks   []moroz.EventSink
+		runners []runner
+	)
+	if fl.webhooks != "" {
+		configs, err := eventsink.LoadWebhookConfigs(fl.webhooks)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		for _, conf := range configs {
+			hook, err := eventsink.NewWebhook(conf, fl.webhookQueue, client, logger)
+			if err != nil {
+				return nil, nil, err
+			}
+			sinks = append(sinks, hook)
+			runners = append(runners, hook)
+		}
+	}
+	if fl.syslog != nil {
+		if fl.syslogEvents {
+			sinks = append(sinks, fl.syslog)
+		}
+		runners = append(runners, fl.syslog)
+	}
+	if fl.elasticsearch.URL != "" {
+		client := &http.Client{Timeout: 2 * time.Minute}
+		es, err := eventsink.NewElasticsearch(fl.elasticsearch, fl.elasticsearchQueue, client, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, es)
+		runners = append(runners, es)
+	}
+	if fl.loki != nil {
+		if fl.lokiEvents {
+			sinks = append(sinks, fl.loki)
+		}
+		runners = append(runners, fl.loki)
+	}
+	return sinks, runners, nil
+}
+
+// openAlerts creates the alert engine for the rules file at path, or returns
+// nil when path is empty.
+func openAlerts(path string, logger log.Logger) (*alert.Engine, error) {
+	if path == "" {
+		return nil, nil
+	}
+	conf, err := alert.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return alert.NewEngine(conf, &http.Client{Timeout: 30 * time.Second}, logger)
+}
+
+// openLoki creates the Loki sink, or returns nil when pushURL is empty.
+// labels is a comma separated list of stream labels.
+func openLoki(pushURL, labels, tenant string, logger log.Logger) (*eventsink.Loki, error) {
+	if pushURL == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, label := range strings.Split(labels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			names = append(names, label)
+		}
+	}
+	conf := eventsink.LokiConfig{URL: pushURL, Labels: names, Tenant: tenant}
+	return eventsink.NewLoki(conf, &http.Client{Timeout: 30 * time.Second}, logger)
+}
+
+// openSyslog creates the syslog sink, or returns nil when collector is empty.
+// caFile is a PEM bundle used to verify TLS collectors, instead of the system roots.
+func openSyslog(collector, caFile string, logger log.Logger) (*eventsink.Syslog, error) {
+	if collector == "" {
+		return nil, nil
+	}
+	var tlsConfig *tls.Config
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read syslog CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return eventsink.NewSyslog(collector, tlsConfig, logger)
+}
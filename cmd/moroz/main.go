@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,10 +19,15 @@ import (
 	"github.com/kolide/kit/env"
 	"github.com/kolide/kit/httputil"
 	"github.com/kolide/kit/logutil"
+	"github.com/kolide/kit/tlsutil"
 	"github.com/kolide/kit/version"
 	"github.com/oklog/run"
+	"github.com/pkg/errors"
 
+	"github.com/groob/moroz/eventsink"
+	"github.com/groob/moroz/eventstore"
 	"github.com/groob/moroz/moroz"
+	"github.com/groob/moroz/push"
 	"github.com/groob/moroz/santaconfig"
 )
 
@@ -48,6 +58,56 @@ func main() {
 		flConfigs       = flag.String("configs", env.String("MOROZ_CONFIGS", "../../configs"), "path to config folder")
 		flEvents        = flag.String("event-dir", env.String("MOROZ_EVENT_DIR", "/tmp/santa_events"), "Path to root directory where events will be stored.")
 		flPersistEvents = flag.Bool("persist-events", env.Bool("MOROZ_WRITE_EVENTS", true), "Enable or disable event persistence to disk. Defaults to enabled.")
+		flEventStores   = flag.String("event-store", env.String("MOROZ_EVENT_STORE", "file"), "Comma separated event stores: file, jsonl, sql or none. Events are queried from the first one.")
+		flEventLogDir   = flag.String("event-log-dir", env.String("MOROZ_EVENT_LOG_DIR", "/tmp/santa_event_log"), "Path to directory where the jsonl event log is stored.")
+		flEventLogMaxMB = flag.Int("event-log-max-mb", envInt("MOROZ_EVENT_LOG_MAX_MB", 64), "Size in MB at which the jsonl event log segment is rotated.")
+		flEventLogRot   = flag.Duration("event-log-rotate", env.Duration("MOROZ_EVENT_LOG_ROTATE", 24*time.Hour), "Age at which the jsonl event log segment is rotated.")
+		flEventLogAge   = flag.Duration("event-log-max-age", env.Duration("MOROZ_EVENT_LOG_MAX_AGE", 0), "Age after which rotated jsonl event log segments are pruned. Segments are kept when 0.")
+		flRetention     = flag.Duration("event-retention", env.Duration("MOROZ_EVENT_RETENTION", 0), "Age after which stored events are pruned. Events are kept when 0.")
+		flRetentionDec  = flag.String("event-retention-decisions", env.String("MOROZ_EVENT_RETENTION_DECISIONS", ""), "Comma separated decision=age retention overrides, ex: BLOCK=8760h,ALLOW=168h.")
+		flEventMaxMB    = flag.Int("event-max-mb", envInt("MOROZ_EVENT_MAX_MB", 0), "Size in MB past which the oldest stored events are pruned. The size is unlimited when 0.")
+		flRetentionInt  = flag.Duration("event-retention-interval", env.Duration("MOROZ_EVENT_RETENTION_INTERVAL", time.Hour), "How often stored events are pruned.")
+		flDedupWindow   = flag.Duration("event-dedup-window", env.Duration("MOROZ_EVENT_DEDUP_WINDOW", 0), "Drop events uploaded again within this window. Duplicates are kept when 0.")
+		flDedupFile     = flag.String("event-dedup-file", env.String("MOROZ_EVENT_DEDUP_FILE", "/tmp/santa_event_fingerprints"), "Path to the file where fingerprints of uploaded events are kept.")
+		flWebhooks      = flag.String("webhooks", env.String("MOROZ_WEBHOOKS", ""), "Path to a TOML file of [[webhook]] destinations uploaded events are forwarded to.")
+		flWebhookQueue  = flag.String("webhook-queue-dir", env.String("MOROZ_WEBHOOK_QUEUE_DIR", "/tmp/santa_webhook_queue"), "Path to directory where events are queued until webhooks receive them.")
+		flSyslogAddr    = flag.String("syslog-addr", env.String("MOROZ_SYSLOG_ADDR", ""), "Syslog collector URL events are sent to, ex: udp://host:514, tcp://host:601 or tls://host:6514.")
+		flSyslogCA      = flag.String("syslog-ca", env.String("MOROZ_SYSLOG_CA", ""), "Path to PEM CA bundle used to verify a tls syslog collector.")
+		flSyslogEvents  = flag.Bool("syslog-events", env.Bool("MOROZ_SYSLOG_EVENTS", true), "Send uploaded events to the syslog collector. Audit records are sent with -audit-sink syslog.")
+		flLokiURL       = flag.String("loki-url", env.String("MOROZ_LOKI_URL", ""), "Loki push URL events are sent to, ex: http://loki:3100/loki/api/v1/push.")
+		flLokiLabels    = flag.String("loki-labels", env.String("MOROZ_LOKI_LABELS", "decision"), "Comma separated Loki stream labels: machine_id, decision, client_mode or hostname.")
+		flLokiTenant    = flag.String("loki-tenant", env.String("MOROZ_LOKI_TENANT", ""), "Loki tenant, sent in the X-Scope-OrgID header.")
+		flESURL         = flag.String("elasticsearch-url", env.String("MOROZ_ELASTICSEARCH_URL", ""), "Elasticsearch or OpenSearch URL events are indexed in, ex: https://es.example.com:9200.")
+		flESIndex       = flag.String("elasticsearch-index", env.String("MOROZ_ELASTICSEARCH_INDEX", "moroz-events"), "Prefix of the daily Elasticsearch indices.")
+		flESUser        = flag.String("elasticsearch-user", env.String("MOROZ_ELASTICSEARCH_USER", ""), "Elasticsearch basic auth username.")
+		flESPassword    = flag.String("elasticsearch-password", env.String("MOROZ_ELASTICSEARCH_PASSWORD", ""), "Elasticsearch basic auth password.")
+		flESAPIKey      = flag.String("elasticsearch-api-key", env.String("MOROZ_ELASTICSEARCH_API_KEY", ""), "Elasticsearch API key, used instead of basic auth.")
+		flESQueue       = flag.String("elasticsearch-queue-dir", env.String("MOROZ_ELASTICSEARCH_QUEUE_DIR", "/tmp/santa_elasticsearch_queue"), "Path to directory where events are queued until they are indexed.")
+		flESQueueMax    = flag.Int("elasticsearch-queue-max", envInt("MOROZ_ELASTICSEARCH_QUEUE_MAX", eventsink.DefaultMaxBatches), "Number of uploads queued for Elasticsearch, after which the oldest are dropped.")
+		flLokiEvents    = flag.Bool("loki-events", env.Bool("MOROZ_LOKI_EVENTS", true), "Send uploaded events to Loki. Audit records are sent with -audit-sink loki.")
+		flAlertRules    = flag.String("alert-rules", env.String("MOROZ_ALERT_RULES", ""), "Path to a TOML file of [[rule]] alert rules evaluated on uploaded events, and the [[notifier]] tables they are sent to.")
+		flEventFsync    = flag.Bool("event-fsync", env.Bool("MOROZ_EVENT_FSYNC", false), "Sync every stored event to disk before acknowledging the upload.")
+		flMachineStore  = flag.String("machine-store", env.String("MOROZ_MACHINE_STORE", "none"), "Machine inventory backend: file, sql or none.")
+		flMachineDir    = flag.String("machine-dir", env.String("MOROZ_MACHINE_DIR", "/tmp/santa_machines"), "Path to directory where the file machine inventory is stored.")
+		flCatalogStore  = flag.String("catalog-store", env.String("MOROZ_CATALOG_STORE", "none"), "Catalog backend for the bundles, binaries and certificates reported in events: file, sql or none.")
+		flCatalogDir    = flag.String("catalog-dir", env.String("MOROZ_CATALOG_DIR", "/tmp/santa_catalog"), "Path to directory where the file catalog is stored.")
+		flSQLDriver     = flag.String("sql-driver", env.String("MOROZ_SQL_DRIVER", "sqlite"), "database/sql driver used by SQL stores.")
+		flSQLDSN        = flag.String("sql-dsn", env.String("MOROZ_SQL_DSN", "moroz.db"), "Data source name used by SQL stores.")
+		flPushURL       = flag.String("push-url", env.String("MOROZ_PUSH_URL", ""), "URL of the push gateway used by the admin push API.")
+		flPushToken     = flag.String("push-token", env.String("MOROZ_PUSH_TOKEN", ""), "Bearer token sent to the push gateway.")
+		flEnroll        = flag.Bool("require-enrollment", env.Bool("MOROZ_REQUIRE_ENROLLMENT", false), "Require machines to enroll with a secret or client certificate before serving their configuration.")
+		flEnrollSecrets = flag.String("enrollment-secrets", env.String("MOROZ_ENROLLMENT_SECRETS", ""), "Path to a file with one enrollment secret per line.")
+		flQuarantine    = flag.String("quarantine-config", env.String("MOROZ_QUARANTINE_CONFIG", ""), "Name of the configuration served to machines which are not enrolled. They are refused when empty.")
+		flEnrollAnyCert = flag.Bool("enrollment-any-certificate", env.Bool("MOROZ_ENROLLMENT_ANY_CERTIFICATE", false), "Enroll machines presenting any verified client certificate, instead of one naming the machine ID.")
+		flTLSClientCA   = flag.String("tls-client-ca", env.String("MOROZ_TLS_CLIENT_CA", ""), "Path to PEM CA bundle used to verify client certificates.")
+		flIDPolicy      = flag.String("machine-id-policy", env.String("MOROZ_MACHINE_ID_POLICY", string(moroz.PreferPayloadID)), "How to handle a machine ID in the URL which differs from the request body: prefer-payload, prefer-url, reject or certificate.")
+		flAuditSinks    = flag.String("audit-sink", env.String("MOROZ_AUDIT_SINK", "stdout"), "Comma separated audit log sinks: stdout, file, http, syslog, loki or none.")
+		flAuditFile     = flag.String("audit-file", env.String("MOROZ_AUDIT_FILE", "moroz_audit.log"), "Path of the file audit log.")
+		flAuditMaxMB    = flag.Int("audit-file-max-mb", envInt("MOROZ_AUDIT_FILE_MAX_MB", 100), "Size in MB at which the file audit log is rotated.")
+		flAuditKeep     = flag.Int("audit-file-keep", envInt("MOROZ_AUDIT_FILE_KEEP", 5), "Number of rotated file audit logs to keep.")
+		flAuditURL      = flag.String("audit-url", env.String("MOROZ_AUDIT_URL", ""), "URL the http audit sink pushes records to.")
+		flAuditFields   = flag.String("audit-fields", env.String("MOROZ_AUDIT_FIELDS", ""), "Comma separated audit record fields to keep. All fields are kept when empty.")
+		flAdminToken    = flag.String("admin-token", env.String("MOROZ_ADMIN_TOKEN", ""), "Bearer token required by the admin API. The admin API is disabled when empty.")
 		flVersion       = flag.Bool("version", false, "print version information")
 		flDebug         = flag.Bool("debug", false, "log at a debug level by default.")
 		flUseTLS        = flag.Bool("use-tls", true, "I promise I terminated TLS elsewhere when changing this")
@@ -72,20 +132,173 @@ func main() {
 	logger := logutil.NewServerLogger(*flDebug)
 
 	repo := santaconfig.NewFileRepo(*flConfigs)
-	var svc moroz.Service
+	db := &sqlDB{driver: *flSQLDriver, dsn: *flSQLDSN}
+	opts := []moroz.Option{moroz.WithLogger(logger)}
 	{
-		s, err := moroz.NewService(repo, *flEvents, *flPersistEvents)
+		machines, err := openMachineStore(context.Background(), *flMachineStore, *flMachineDir, db)
 		if err != nil {
 			logutil.Fatal(logger, err)
 		}
+		if machines != nil {
+			opts = append(opts, moroz.WithMachineStore(machines))
+		}
+		if sessions, ok := machines.(moroz.SessionStore); ok {
+			opts = append(opts, moroz.WithSessionStore(sessions))
+		}
+	}
+	{
+		bundles, err := openCatalogStore(context.Background(), *flCatalogStore, *flCatalogDir, db)
+		if err != nil {
+			logutil.Fatal(logger, err)
+		}
+		if bundles != nil {
+			opts = append(opts, moroz.WithBundleStore(bundles))
+		}
+		if binaries, ok := bundles.(moroz.BinaryStore); ok {
+			opts = append(opts, moroz.WithBinaryStore(binaries))
+		}
+		if certs, ok := bundles.(moroz.CertificateStore); ok {
+			opts = append(opts, moroz.WithCertificateStore(certs))
+		}
+	}
+	if *flEnroll {
+		secrets, err := loadEnrollmentSecrets(*flEnrollSecrets)
+		if err != nil {
+			logutil.Fatal(logger, err)
+		}
+		opts = append(opts, moroz.WithEnrollment(moroz.Enrollment{
+			Secrets:          secrets,
+			QuarantineConfig: *flQuarantine,
+			AnyCertificate:   *flEnrollAnyCert,
+		}))
+	}
+	if *flDedupWindow > 0 {
+		dedup, err := eventstore.NewDedup(*flDedupFile, *flDedupWindow)
+		if err != nil {
+			logutil.Fatal(logger, err)
+		}
+		opts = append(opts, moroz.WithDeduplicator(dedup))
+	}
+	syslogOut, err := openSyslog(*flSyslogAddr, *flSyslogCA, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	lokiOut, err := openLoki(*flLokiURL, *flLokiLabels, *flLokiTenant, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	eventSinks, sinkRunners, err := openEventSinks(eventSinkFlags{
+		webhooks:     *flWebhooks,
+		webhookQueue: *flWebhookQueue,
+		syslog:       syslogOut,
+		syslogEvents: *flSyslogEvents,
+		loki:         lokiOut,
+		lokiEvents:   *flLokiEvents,
+		elasticsearch: eventsink.ElasticsearchConfig{
+			URL:              *flESURL,
+			Index:            *flESIndex,
+			Username:         *flESUser,
+			Password:         *flESPassword,
+			APIKey:           *flESAPIKey,
+			MaxQueuedBatches: *flESQueueMax,
+		},
+		elasticsearchQueue: *flESQueue,
+	}, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	if len(eventSinks) > 0 {
+		opts = append(opts, moroz.WithEventSinks(eventSinks...))
+	}
+	alerts, err := openAlerts(*flAlertRules, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	if alerts != nil {
+		opts = append(opts, moroz.WithAlerter(alerts))
+		sinkRunners = append(sinkRunners, alerts)
+	}
+	if *flPushURL != "" {
+		opts = append(opts, moroz.WithPusher(&push.HTTPPusher{
+			URL:    *flPushURL,
+			Token:  *flPushToken,
+			Client: &http.Client{Timeout: 30 * time.Second},
+		}))
+	}
+
+	decisionAges, err := eventstore.ParseDecisionAges(*flRetentionDec)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	events, janitors, err := openEventStore(context.Background(), eventFlags{
+		stores:    *flEventStores,
+		persist:   *flPersistEvents,
+		dir:       *flEvents,
+		fsync:     *flEventFsync,
+		logDir:    *flEventLogDir,
+		logMaxMB:  *flEventLogMaxMB,
+		logRotate: *flEventLogRot,
+		logMaxAge: *flEventLogAge,
+		retention: eventstore.RetentionPolicy{
+			MaxAge:    *flRetention,
+			Decisions: decisionAges,
+			MaxBytes:  int64(*flEventMaxMB) << 20,
+		},
+		retentionInterval: *flRetentionInt,
+	}, db, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+
+	var (
+		svc      moroz.Service
+		adminSvc moroz.AdminService
+	)
+	for _, janitor := range janitors {
+		opts = append(opts, moroz.WithRetentionJanitor(janitor))
+	}
+	{
+		s, err := moroz.NewService(repo, events, opts...)
+		if err != nil {
+			logutil.Fatal(logger, err)
+		}
+		adminSvc = s
 		svc = s
 		svc = moroz.LoggingMiddleware(logger)(svc)
 	}
 
+	auditSink, auditHTTP, err := openAuditSink(auditFlags{
+		sinks:  *flAuditSinks,
+		file:   *flAuditFile,
+		maxMB:  *flAuditMaxMB,
+		keep:   *flAuditKeep,
+		url:    *flAuditURL,
+		fields: *flAuditFields,
+		syslog: syslogOut,
+		loki:   lokiOut,
+	}, logger)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	if auditSink != nil {
+		svc = moroz.AuditMiddleware(auditSink, logger)(svc)
+	}
+
+	idPolicy, err := moroz.ParseMachineIDPolicy(*flIDPolicy)
+	if err != nil {
+		logutil.Fatal(logger, err)
+	}
+	if idPolicy == moroz.CertificateID && (*flTLSClientCA == "" || !*flUseTLS) {
+		logutil.Fatal(logger, "msg", "machine ID policy certificate requires -tls-client-ca and -use-tls")
+	}
+
 	endpoints := moroz.MakeServerEndpoints(svc)
 
 	r := mux.NewRouter()
-	moroz.AddHTTPRoutes(r, endpoints, logger)
+	moroz.AddHTTPRoutes(r, endpoints, logger, moroz.WithMachineIDPolicy(idPolicy))
+	if *flAdminToken != "" {
+		moroz.AddAdminHTTPRoutes(r, moroz.MakeAdminEndpoints(adminSvc), logger, *flAdminToken)
+	}
 
 	var g run.Group
 	{
@@ -104,8 +317,45 @@ func main() {
 		})
 	}
 
+	if auditHTTP != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return auditHTTP.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	for _, janitor := range janitors {
+		janitor := janitor
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return janitor.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	for _, sink := range sinkRunners {
+		sink := sink
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return sink.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	{
-		srv := httputil.NewServer(*flAddr, r)
+		var srvOpts []httputil.Option
+		if *flTLSClientCA != "" {
+			tlsConfig, err := clientCertTLSConfig(*flTLSClientCA)
+			if err != nil {
+				logutil.Fatal(logger, err)
+			}
+			srvOpts = append(srvOpts, httputil.WithTLSConfig(tlsConfig))
+		}
+		srv := httputil.NewServer(*flAddr, r, srvOpts...)
 		g.Add(func() error {
 			level.Debug(logger).Log("msg", "serve http", "tls", *flUseTLS, "addr", *flAddr)
 			if *flUseTLS {
@@ -123,6 +373,57 @@ func main() {
 	logutil.Fatal(logger, "msg", "terminated", "err", g.Run())
 }
 
+// envInt returns the integer value of the environment variable key, or def
+// when it is unset. Like env.Duration, it exits when the value doesn't parse.
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "env: parse int from %s: %s\n", key, err)
+		os.Exit(1)
+	}
+	return n
+}
+
+// loadEnrollmentSecrets reads one secret per line, ignoring blank lines and # comments.
+func loadEnrollmentSecrets(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read enrollment secrets")
+	}
+	var secrets []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		secrets = append(secrets, line)
+	}
+	return secrets, nil
+}
+
+// clientCertTLSConfig verifies client certificates against the CA bundle at path, when clients send one.
+func clientCertTLSConfig(path string) (*tls.Config, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read client CA bundle")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in %s", path)
+	}
+	cfg := tlsutil.NewConfig()
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
 func validateConfigExists(configsPath string) bool {
 	var hasConfig = true
 	if _, err := os.Stat(configsPath); os.IsNotExist(err) {
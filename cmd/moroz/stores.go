@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/storage"
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/moroz"
+)
+
+// sqlDB opens the database shared by all SQL-backed stores on first use.
+// A SQLite database is opened to wait on locks, as stores write concurrently.
+type sqlDB struct {
+	driver, dsn string
+
+	once sync.Once
+	db   *sql.DB
+	err  error
+}
+
+func (s *sqlDB) open() (*sql.DB, error) {
+	s.once.Do(func() {
+		dsn := s.dsn
+		if s.driver == "sqlite" {
+			dsn = storage.SQLiteDSN(dsn)
+		}
+		s.db, s.err = sql.Open(s.driver, dsn)
+		if s.err == nil {
+			s.err = s.db.Ping()
+		}
+		s.err = errors.Wrapf(s.err, "open %s database", s.driver)
+	})
+	return s.db, s.err
+}
+
+func openMachineStore(ctx context.Context, backend, dir string, db *sqlDB) (moroz.MachineStore, error) {
+	switch backend {
+	case "file":
+		return inventory.NewFileStore(dir)
+	case "sql":
+		conn, err := db.open()
+		if err != nil {
+			return nil, err
+		}
+		return inventory.NewSQLStore(ctx, conn)
+	case "none":
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unknown machine store %q", backend)
+	}
+}
+
+// openCatalogStore opens the catalog of the bundles, binaries and certificates reported in events.
+func openCatalogStore(ctx context.Context, backend, dir string, db *sqlDB) (moroz.BundleStore, error) {
+	switch backend {
+	case "file":
+		return catalog.NewFileStore(dir)
+	case "sql":
+		conn, err := db.open()
+		if err != nil {
+			return nil, err
+		}
+		return catalog.NewSQLStore(ctx, conn)
+	case "none":
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unknown catalog store %q", backend)
+	}
+}
+
+// eventFlags configure the event stores.
+type eventFlags struct {
+	stores  string
+	persist bool
+	dir     string
+	fsync   bool
+
+	logDir    string
+	logMaxMB  int
+	logRotate time.Duration
+	logMaxAge time.Duration
+
+	retention         eventstore.RetentionPolicy
+	retentionInterval time.Duration
+}
+
+// openEventStore chains the comma separated event stores. Events are queried from the first one.
+// When the retention policy bounds the age or size of events, every store gets a janitor.
+func openEventStore(ctx context.Context, fl eventFlags, db *sqlDB, logger log.Logger) (moroz.EventStore, []*eventstore.Janitor, error) {
+	if !fl.persist {
+		return nil, nil, nil
+	}
+	var (
+		stores   []moroz.EventStore
+		janitors []*eventstore.Janitor
+	)
+	retain := fl.retention.MaxAge > 0 || len(fl.retention.Decisions) > 0 || fl.retention.MaxBytes > 0
+	for _, name := range strings.Split(fl.stores, ",") {
+		name = strings.TrimSpace(name)
+		n := len(stores)
+		switch name {
+		case "file":
+			var opts []eventstore.FileOption
+			if fl.fsync {
+				opts = append(opts, eventstore.WithFileSync())
+			}
+			store, err := eventstore.NewFileStore(fl.dir, opts...)
+			if err != nil {
+				return nil, nil, err
+			}
+			stores = append(stores, store)
+		case "jsonl":
+			opts := []eventstore.JSONLOption{
+				eventstore.WithMaxSegmentBytes(int64(fl.logMaxMB) << 20),
+				eventstore.WithRotateInterval(fl.logRotate),
+				eventstore.WithMaxAge(fl.logMaxAge),
+				eventstore.WithJSONLLogger(log.With(logger, "component", "jsonl")),
+			}
+			if fl.fsync {
+				opts = append(opts, eventstore.WithJSONLSync())
+			}
+			store, err := eventstore.NewJSONLStore(fl.logDir, opts...)
+			if err != nil {
+				return nil, nil, err
+			}
+			stores = append(stores, store)
+		case "sql":
+			conn, err := db.open()
+			if err != nil {
+				return nil, nil, err
+			}
+			store, err := eventstore.NewSQLStore(ctx, conn)
+			if err != nil {
+				return nil, nil, err
+			}
+			stores = append(stores, store)
+		case "none", "":
+		default:
+			return nil, nil, errors.Errorf("unknown event store %q", name)
+		}
+		if retain && len(stores) > n {
+			janitor, err := eventstore.NewJanitor(name, stores[n], fl.retention, fl.retentionInterval, logger)
+			if err != nil {
+				return nil, nil, err
+			}
+			janitors = append(janitors, janitor)
+		}
+	}
+	if len(stores) == 0 {
+		return nil, nil, nil
+	}
+	return moroz.MultiEventStore(stores...), janitors, nil
+}
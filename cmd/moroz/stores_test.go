@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestSQLDBConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db := &sqlDB{driver: "sqlite", dsn: filepath.Join(t.TempDir(), "moroz.db")}
+	conn, err := db.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	events, err := eventstore.NewSQLStore(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machines, err := inventory.NewSQLStore(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// uploads from a fleet write to the shared database at the same time.
+	const uploads = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			batch := []eventstore.Event{{
+				MachineID:  id,
+				ReceivedAt: time.Now(),
+				Event: santa.EventUploadEvent{
+					FileSHA256:   "aaa",
+					Decision:     "BLOCK_BINARY",
+					SigningChain: []santa.SigningEntry{{SHA256: "leaf"}, {SHA256: "root"}},
+				},
+			}}
+			errs <- events.Put(ctx, batch)
+			errs <- machines.SaveMachine(ctx, inventory.Machine{MachineID: id})
+		}("M" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	page, err := events.Query(ctx, eventstore.Query{Limit: uploads})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != uploads {
+		t.Errorf("have %d events stored, want %d\n", len(page.Events), uploads)
+	}
+}
@@ -0,0 +1,383 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/retry"
+	"github.com/groob/moroz/santa"
+)
+
+// ElasticsearchConfig configures an Elasticsearch or OpenSearch sink.
+type ElasticsearchConfig struct {
+	// URL is the cluster URL, ie. https://es.example.com:9200.
+	URL string
+	// Index is the prefix of the daily indices, which are named
+	// <index>-YYYY.MM.DD after the day the events were received.
+	// The default is moroz-events.
+	Index string
+
+	// Username and Password authenticate with basic auth, APIKey with
+	// an `Authorization: ApiKey` header.
+	Username string
+	Password string
+	APIKey   string
+
+	// BatchSize is the number of events sent in a bulk request. The default is 500.
+	BatchSize int
+
+	// MaxQueuedBatches is the number of uploads queued while the cluster is
+	// down, after which the oldest are dropped. The default is DefaultMaxBatches.
+	MaxQueuedBatches int
+}
+
+// Elasticsearch indexes events with the _bulk API of an Elasticsearch or
+// OpenSearch cluster. Events are queued on disk until they are indexed.
+//
+// Each event is created with its fingerprint as the document ID, so events
+// which are sent again after a failed request aren't indexed twice.
+// When part of a bulk request fails, events rejected with a retryable status
+// (429 or a server error) are queued again, and the others are dropped.
+type Elasticsearch struct {
+	conf   ElasticsearchConfig
+	client *http.Client
+	logger log.Logger
+	queue  *DiskQueue
+	notify chan struct{}
+
+	backoff func(attempt int) time.Duration
+}
+
+// NewElasticsearch creates an Elasticsearch sink which queues events in queueDir.
+func NewElasticsearch(conf ElasticsearchConfig, queueDir string, client *http.Client, logger log.Logger) (*Elasticsearch, error) {
+	if conf.URL == "" {
+		return nil, errors.New("elasticsearch sink requires a URL")
+	}
+	if conf.Index == "" {
+		conf.Index = "moroz-events"
+	}
+	if conf.Index != strings.ToLower(conf.Index) || strings.ContainsAny(conf.Index, `/\*?"<>| ,#:`) {
+		return nil, errors.Errorf("invalid elasticsearch index %q", conf.Index)
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 500
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+	logger = log.With(logger, "sink", "elasticsearch")
+	queue, err := NewDiskQueue(queueDir, WithMaxBatches(conf.MaxQueuedBatches), WithQueueLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	return &Elasticsearch{
+		conf:    conf,
+		client:  client,
+		logger:  logger,
+		queue:   queue,
+		notify:  make(chan struct{}, 1),
+		backoff: retry.Backoff,
+	}, nil
+}
+
+// WriteEvents queues events to be indexed.
+func (es *Elasticsearch) WriteEvents(ctx context.Context, events []eventstore.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if err := es.queue.Push(events); err != nil {
+		return err
+	}
+	select {
+	case es.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run installs the index template, then indexes queued events until ctx is cancelled.
+func (es *Elasticsearch) Run(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := es.putTemplate(ctx)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(rejectedError); ok {
+			level.Info(es.logger).Log("msg", "elasticsearch rejected the index template", "err", err)
+			break
+		}
+		level.Info(es.logger).Log("msg", "put elasticsearch index template", "attempt", attempt, "err", err)
+		if retry.Sleep(ctx, es.backoff(attempt)) {
+			return ctx.Err()
+		}
+	}
+
+	var attempt int
+	for {
+		events, batches, err := es.queue.Peek(es.conf.BatchSize)
+		var retried []eventstore.Event
+		if err == nil && len(events) > 0 {
+			retried, err = es.bulk(ctx, events)
+			if _, ok := err.(rejectedError); ok {
+				level.Info(es.logger).Log("msg", "dropped elasticsearch events", "count", len(events), "err", err)
+				err = nil
+			}
+			if err == nil && len(retried) > 0 {
+				err = es.queue.Push(retried)
+			}
+			if err == nil {
+				err = es.queue.Ack(batches)
+			}
+		}
+		switch {
+		case err != nil:
+			attempt++
+			level.Info(es.logger).Log("msg", "index elasticsearch events", "count", len(events), "attempt", attempt, "err", err)
+			if retry.Sleep(ctx, es.backoff(attempt)) {
+				return ctx.Err()
+			}
+		case len(retried) > 0:
+			// the retried events are queued again, but wait before sending
+			// them to a cluster which is pushing back.
+			attempt++
+			if retry.Sleep(ctx, es.backoff(attempt)) {
+				return ctx.Err()
+			}
+		case len(events) > 0:
+			attempt = 0
+		default:
+			select {
+			case <-es.notify:
+			case <-time.After(time.Minute):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// esDocument is the indexed form of an event, with the event fields at the top level.
+type esDocument struct {
+	Timestamp  time.Time `json:"@timestamp"`
+	MachineID  string    `json:"machine_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Groups     []string  `json:"groups,omitempty"`
+	santa.EventUploadEvent
+}
+
+// index returns the daily index ev is written to.
+func (es *Elasticsearch) index(ev eventstore.Event) string {
+	return es.conf.Index + "-" + ev.ReceivedAt.UTC().Format("2006.01.02")
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends events in a bulk request, and returns the events which failed
+// with a retryable status.
+func (es *Elasticsearch) bulk(ctx context.Context, events []eventstore.Event) ([]eventstore.Event, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, ev := range events {
+		action := map[string]map[string]string{
+			"create": {"_index": es.index(ev), "_id": eventstore.Fingerprint(ev)},
+		}
+		doc := esDocument{
+			Timestamp:        ev.ExecutedAt(),
+			MachineID:        ev.MachineID,
+			ReceivedAt:       ev.ReceivedAt,
+			Groups:           ev.Groups,
+			EventUploadEvent: ev.Event,
+		}
+		if err := enc.Encode(action); err != nil {
+			return nil, errors.Wrap(err, "marshal bulk action")
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, errors.Wrap(err, "marshal bulk document")
+		}
+	}
+
+	resp, err := es.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil, err
+	}
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "decode bulk response")
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	if len(result.Items) != len(events) {
+		return nil, errors.Errorf("bulk response has %d items for %d events", len(result.Items), len(events))
+	}
+
+	var retried []eventstore.Event
+	var dropped int
+	for i, item := range result.Items {
+		for _, r := range item {
+			switch {
+			case r.Status/100 == 2, r.Status == http.StatusConflict:
+				// a conflict means the event was already indexed.
+			case r.Status == http.StatusTooManyRequests, r.Status >= 500:
+				retried = append(retried, events[i])
+			default:
+				dropped++
+				level.Info(es.logger).Log(
+					"msg", "elasticsearch rejected event",
+					"machine_id", events[i].MachineID,
+					"sha256", events[i].Event.FileSHA256,
+					"status", r.Status,
+					"type", r.Error.Type,
+					"reason", r.Error.Reason,
+				)
+			}
+		}
+	}
+	if len(retried) > 0 || dropped > 0 {
+		level.Info(es.logger).Log("msg", "elasticsearch bulk request partially failed", "count", len(events), "retry", len(retried), "dropped", dropped)
+	}
+	return retried, nil
+}
+
+// putTemplate installs the index template of the daily indices.
+func (es *Elasticsearch) putTemplate(ctx context.Context) error {
+	body, err := json.Marshal(IndexTemplate(es.conf.Index))
+	if err != nil {
+		return errors.Wrap(err, "marshal index template")
+	}
+	resp, err := es.do(ctx, http.MethodPut, "/_index_template/"+es.conf.Index, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return checkStatus(resp)
+}
+
+func (es *Elasticsearch) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	req, err := http.NewRequest(method, es.conf.URL+path, body)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "create elasticsearch request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case es.conf.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+es.conf.APIKey)
+	case es.conf.Username != "":
+		req.SetBasicAuth(es.conf.Username, es.conf.Password)
+	}
+	resp, err := es.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "send elasticsearch request")
+	}
+	resp.Body = cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the request context once the response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// IndexTemplate returns the composable index template of the daily indices
+// named after index. Strings are keywords, timestamps are dates and the
+// signing chain is nested, so its certificates can be matched one at a time.
+func IndexTemplate(index string) map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword"}
+	text := map[string]interface{}{
+		"type":   "text",
+		"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 1024}},
+	}
+	return map[string]interface{}{
+		"index_patterns": []string{index + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match_mapping_type": "string",
+							"mapping":            keyword,
+						},
+					},
+				},
+				"properties": map[string]interface{}{
+					"@timestamp":                      map[string]interface{}{"type": "date"},
+					"received_at":                     map[string]interface{}{"type": "date"},
+					"machine_id":                      keyword,
+					"groups":                          keyword,
+					"decision":                        keyword,
+					"executing_user":                  keyword,
+					"execution_time":                  map[string]interface{}{"type": "double"},
+					"current_sessions":                keyword,
+					"logged_in_users":                 keyword,
+					"file_sha256":                     keyword,
+					"file_name":                       keyword,
+					"file_path":                       text,
+					"file_bundle_id":                  keyword,
+					"file_bundle_name":                keyword,
+					"file_bundle_path":                text,
+					"file_bundle_hash":                keyword,
+					"file_bundle_hash_millis":         map[string]interface{}{"type": "double"},
+					"file_bundle_binary_count":        map[string]interface{}{"type": "long"},
+					"file_bundle_executable_rel_path": keyword,
+					"file_bundle_version":             keyword,
+					"file_bundle_version_string":      keyword,
+					"parent_name":                     keyword,
+					"pid":                             map[string]interface{}{"type": "long"},
+					"ppid":                            map[string]interface{}{"type": "long"},
+					"quarantine_agent_bundle_id":      keyword,
+					"quarantine_data_url":             keyword,
+					"quarantine_referer_url":          keyword,
+					"quarantine_timestamp":            map[string]interface{}{"type": "double"},
+					"signing_id":                      keyword,
+					"team_id":                         keyword,
+					"cdhash":                          keyword,
+					"signing_chain": map[string]interface{}{
+						"type": "nested",
+						"properties": map[string]interface{}{
+							"cn":          keyword,
+							"org":         keyword,
+							"ou":          keyword,
+							"sha256":      keyword,
+							"valid_from":  map[string]interface{}{"type": "date", "format": "epoch_second"},
+							"valid_until": map[string]interface{}{"type": "date", "format": "epoch_second"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,167 @@
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+// fakeBulk is a stand-in for the _bulk API. It fails the events listed in
+// fail with their status code, once.
+type fakeBulk struct {
+	mu       sync.Mutex
+	template map[string]interface{}
+	docs     map[string]map[string]interface{} // by index/id
+	fail     map[string]int                    // by sha256
+	requests chan struct{}
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "moroz" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/_index_template/santa":
+		json.NewDecoder(r.Body).Decode(&f.template)
+		return
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type result struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type string `json:"type,omitempty"`
+		} `json:"error"`
+	}
+	var resp struct {
+		Errors bool                `json:"errors"`
+		Items  []map[string]result `json:"items"`
+	}
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		var action map[string]map[string]string
+		json.Unmarshal(sc.Bytes(), &action)
+		sc.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(sc.Bytes(), &doc)
+
+		key := action["create"]["_index"] + "/" + action["create"]["_id"]
+		var res result
+		sha, _ := doc["file_sha256"].(string)
+		switch {
+		case f.fail[sha] != 0:
+			res.Status = f.fail[sha]
+			res.Error.Type = "failed"
+			delete(f.fail, sha)
+		case f.docs[key] != nil:
+			res.Status = http.StatusConflict
+		default:
+			res.Status = http.StatusCreated
+			f.docs[key] = doc
+		}
+		resp.Errors = resp.Errors || res.Status >= 300
+		resp.Items = append(resp.Items, map[string]result{"create": res})
+	}
+	json.NewEncoder(w).Encode(resp)
+	f.requests <- struct{}{}
+}
+
+func TestElasticsearch(t *testing.T) {
+	fake := &fakeBulk{
+		docs: make(map[string]map[string]interface{}),
+		fail: map[string]int{
+			"retry":  http.StatusTooManyRequests,
+			"reject": http.StatusBadRequest,
+		},
+		requests: make(chan struct{}, 10),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	es, err := NewElasticsearch(ElasticsearchConfig{
+		URL:      srv.URL,
+		Index:    "santa",
+		Username: "moroz",
+		Password: "secret",
+	}, t.TempDir(), srv.Client(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.backoff = func(int) time.Duration { return time.Millisecond }
+
+	received := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var events []eventstore.Event
+	for _, sha := range []string{"ok", "retry", "reject"} {
+		events = append(events, eventstore.Event{
+			MachineID:  "M1",
+			ReceivedAt: received,
+			Event: santa.EventUploadEvent{
+				FileSHA256:    sha,
+				Decision:      "BLOCK_BINARY",
+				ExecutionTime: float64(received.Unix()),
+				SigningChain:  []santa.SigningEntry{{CertificateName: "Developer ID", SHA256: "cert"}},
+			},
+		})
+	}
+	// the same event twice is indexed once.
+	events = append(events, events[0])
+	if err := es.WriteEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go es.Run(ctx)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-fake.requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for bulk request")
+		}
+	}
+	cancel()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.docs) != 2 {
+		t.Fatalf("have %d documents, want ok and retry\n", len(fake.docs))
+	}
+	for key, doc := range fake.docs {
+		want := fmt.Sprintf("santa-2024.03.01/%s", eventstore.Fingerprint(eventstore.Event{
+			MachineID: "M1",
+			Event:     santa.EventUploadEvent{FileSHA256: doc["file_sha256"].(string), Decision: "BLOCK_BINARY", ExecutionTime: float64(received.Unix())},
+		}))
+		if key != want {
+			t.Errorf("have document %s, want %s\n", key, want)
+		}
+		if doc["machine_id"] != "M1" || doc["@timestamp"] != "2024-03-01T12:00:00Z" {
+			t.Errorf("have document %v\n", doc)
+		}
+	}
+	if len(fake.fail) != 0 {
+		t.Errorf("have events which were never sent: %v\n", fake.fail)
+	}
+
+	mappings := fake.template["template"].(map[string]interface{})["mappings"].(map[string]interface{})
+	chain := mappings["properties"].(map[string]interface{})["signing_chain"].(map[string]interface{})
+	if chain["type"] != "nested" {
+		t.Errorf("have signing_chain mapping %v, want nested\n", chain)
+	}
+}
@@ -0,0 +1,28 @@
+// Package eventsink forwards the events uploaded by Santa clients to
+// external systems. Sinks queue events when they are written, and deliver
+// them from their Run method.
+package eventsink
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// rejectedError is returned for requests which would fail again if retried.
+type rejectedError struct {
+	error
+}
+
+// checkStatus returns an error unless resp is successful. Client errors
+// other than 408 and 429 are a rejectedError.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err := errors.Errorf("%s returned %s", resp.Request.URL.Host, resp.Status)
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return rejectedError{err}
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/retry"
+)
+
+// LokiLabels are the stream labels a Loki sink can be configured with.
+// Labels a record doesn't have are left out of its stream. Every label value
+// makes new streams, so machine_id and hostname only suit small fleets; the
+// machine ID is always in the log line.
+var LokiLabels = []string{"machine_id", "decision", "client_mode", "hostname"}
+
+// LokiConfig configures a Loki sink.
+type LokiConfig struct {
+	// URL is the push endpoint, ie. http://loki:3100/loki/api/v1/push.
+	URL string
+	// Labels are the stream labels, from LokiLabels. Every stream also has the
+	// job="moroz" label, and a type label which is "event" or the audit event type.
+	Labels []string
+	// Tenant is sent in the X-Scope-OrgID header when set.
+	Tenant string
+}
+
+// Loki pushes events and audit records to a Loki push endpoint.
+// Entries are queued by WriteEvents and Write, and pushed in batches by Run.
+// A batch which fails is retried with exponential backoff, up to 10 times.
+type Loki struct {
+	conf   LokiConfig
+	client *http.Client
+	logger log.Logger
+
+	queue         chan lokiEntry
+	dropped       int64
+	batchSize     int
+	flushInterval time.Duration
+	backoff       func(attempt int) time.Duration
+}
+
+type lokiEntry struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+// NewLoki creates a Loki sink.
+func NewLoki(conf LokiConfig, client *http.Client, logger log.Logger) (*Loki, error) {
+	if conf.URL == "" {
+		return nil, errors.New("loki sink requires a push URL")
+	}
+	for _, label := range conf.Labels {
+		if !slices.Contains(LokiLabels, label) {
+			return nil, errors.Errorf("unknown loki label %q, want one of %s", label, strings.Join(LokiLabels, ", "))
+		}
+	}
+	return &Loki{
+		conf:          conf,
+		client:        client,
+		logger:        log.With(logger, "sink", "loki"),
+		queue:         make(chan lokiEntry, 10000),
+		batchSize:     500,
+		flushInterval: 5 * time.Second,
+		backoff:       retry.Backoff,
+	}, nil
+}
+
+// WriteEvents queues an entry per event.
+func (l *Loki) WriteEvents(ctx context.Context, events []eventstore.Event) error {
+	for _, ev := range events {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "marshal loki entry")
+		}
+		fields := map[string]string{
+			"machine_id":  ev.MachineID,
+			"decision":    ev.Event.Decision,
+			"client_mode": ev.ClientMode,
+			"hostname":    ev.Hostname,
+		}
+		if err := l.enqueue("event", fields, ev.ReceivedAt, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write queues an entry for an audit record, so Loki is also an audit.Sink.
+func (l *Loki) Write(ctx context.Context, r audit.Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "marshal loki entry")
+	}
+	fields := make(map[string]string)
+	for _, label := range l.conf.Labels {
+		switch v := r[label].(type) {
+		case nil:
+		case encoding.TextMarshaler:
+			if text, err := v.MarshalText(); err == nil {
+				fields[label] = string(text)
+			}
+		default:
+			fields[label] = fmt.Sprint(v)
+		}
+	}
+	return l.enqueue(r.EventType(), fields, time.Now(), line)
+}
+
+var errLokiQueueFull = errors.New("loki queue is full, entry dropped")
+
+func (l *Loki) enqueue(entryType string, fields map[string]string, ts time.Time, line []byte) error {
+	labels := map[string]string{"job": "moroz", "type": entryType}
+	for _, label := range l.conf.Labels {
+		if v := fields[label]; v != "" {
+			labels[label] = v
+		}
+	}
+	select {
+	case l.queue <- lokiEntry{labels: labels, ts: ts, line: string(line)}:
+		return nil
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+		return errLokiQueueFull
+	}
+}
+
+// Dropped returns the number of entries dropped because the queue was full,
+// or because their batch failed too many times.
+func (l *Loki) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// Run pushes queued entries until ctx is cancelled, then tries to flush what
+// is left for up to 10 seconds.
+func (l *Loki) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	var batch []lokiEntry
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		l.pushWithRetry(ctx, batch)
+		batch = nil
+	}
+	for {
+		select {
+		case e := <-l.queue:
+			batch = append(batch, e)
+			if len(batch) >= l.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-l.queue:
+					batch = append(batch, e)
+				default:
+					shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					flush(shutdown)
+					cancel()
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+const lokiMaxAttempts = 10
+
+func (l *Loki) pushWithRetry(ctx context.Context, batch []lokiEntry) {
+	for attempt := 1; ; attempt++ {
+		err := l.push(ctx, batch)
+		if err == nil {
+			return
+		}
+		_, rejected := err.(rejectedError)
+		if rejected || attempt == lokiMaxAttempts || ctx.Err() != nil {
+			atomic.AddInt64(&l.dropped, int64(len(batch)))
+			level.Info(l.logger).Log("msg", "dropped loki entries", "count", len(batch), "attempt", attempt, "err", err)
+			return
+		}
+		level.Info(l.logger).Log("msg", "push loki entries", "count", len(batch), "attempt", attempt, "err", err)
+		if retry.Sleep(ctx, l.backoff(attempt)) {
+			atomic.AddInt64(&l.dropped, int64(len(batch)))
+			return
+		}
+	}
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// streams groups entries by label set, sorted by timestamp.
+func streams(batch []lokiEntry) []lokiStream {
+	byKey := make(map[string]*lokiStream)
+	var keys []string
+	for _, e := range batch {
+		names := make([]string, 0, len(e.labels))
+		for name := range e.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var key strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&key, "%s=%q,", name, e.labels[name])
+		}
+		s, ok := byKey[key.String()]
+		if !ok {
+			s = &lokiStream{Stream: e.labels}
+			byKey[key.String()] = s
+			keys = append(keys, key.String())
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+	}
+
+	out := make([]lokiStream, 0, len(keys))
+	for _, key := range keys {
+		s := byKey[key]
+		sort.SliceStable(s.Values, func(i, j int) bool {
+			a, _ := strconv.ParseInt(s.Values[i][0], 10, 64)
+			b, _ := strconv.ParseInt(s.Values[j][0], 10, 64)
+			return a < b
+		})
+		out = append(out, *s)
+	}
+	return out
+}
+
+func (l *Loki) push(ctx context.Context, batch []lokiEntry) error {
+	body, err := json.Marshal(struct {
+		Streams []lokiStream `json:"streams"`
+	}{streams(batch)})
+	if err != nil {
+		return errors.Wrap(err, "marshal loki push request")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, l.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create loki push request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if l.conf.Tenant != "" {
+		req.Header.Set("X-Scope-OrgID", l.conf.Tenant)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send loki push request")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return checkStatus(resp)
+}
@@ -0,0 +1,96 @@
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+func TestLoki(t *testing.T) {
+	type push struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	pushes := make(chan push, 10)
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "fleet" {
+			t.Errorf("have request to %s for tenant %q\n", r.URL.Path, r.Header.Get("X-Scope-OrgID"))
+		}
+		// the first push fails, and must be retried.
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p push
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		pushes <- p
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, err := NewLoki(LokiConfig{
+		URL:    srv.URL + "/loki/api/v1/push",
+		Labels: []string{"machine_id", "decision", "client_mode"},
+		Tenant: "fleet",
+	}, srv.Client(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.flushInterval = 10 * time.Millisecond
+	sink.backoff = func(int) time.Duration { return time.Millisecond }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	events := []eventstore.Event{
+		{MachineID: "M1", ClientMode: "LOCKDOWN", ReceivedAt: now, Event: santa.EventUploadEvent{Decision: "BLOCK_BINARY"}},
+		{MachineID: "M1", ClientMode: "LOCKDOWN", ReceivedAt: now.Add(-time.Second), Event: santa.EventUploadEvent{Decision: "BLOCK_BINARY"}},
+		{MachineID: "M1", ClientMode: "LOCKDOWN", ReceivedAt: now, Event: santa.EventUploadEvent{Decision: "ALLOW_BINARY"}},
+	}
+	if err := sink.WriteEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	r := audit.NewRecord(audit.Preflight, "M1", now, nil)
+	r["client_mode"] = santa.Lockdown
+	if err := sink.Write(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	go sink.Run(ctx)
+
+	var p push
+	select {
+	case p = <-pushes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for loki push")
+	}
+	if len(p.Streams) != 3 {
+		t.Fatalf("have %d streams, want 3\n", len(p.Streams))
+	}
+	blocks := p.Streams[0]
+	if blocks.Stream["decision"] != "BLOCK_BINARY" || blocks.Stream["client_mode"] != "LOCKDOWN" || blocks.Stream["type"] != "event" || len(blocks.Values) != 2 {
+		t.Errorf("have stream %v with %d values, want 2 blocks\n", blocks.Stream, len(blocks.Values))
+	}
+	if blocks.Values[0][0] > blocks.Values[1][0] {
+		t.Errorf("want stream values sorted by timestamp\n")
+	}
+	preflight := p.Streams[2]
+	if preflight.Stream["type"] != "preflight" || preflight.Stream["client_mode"] != "LOCKDOWN" || preflight.Stream["machine_id"] != "M1" {
+		t.Errorf("have preflight stream %v\n", preflight.Stream)
+	}
+
+	if _, err := NewLoki(LokiConfig{URL: srv.URL, Labels: []string{"serial"}}, srv.Client(), log.NewNopLogger()); err == nil {
+		t.Errorf("want error for unknown label\n")
+	}
+}
@@ -0,0 +1,232 @@
+package eventsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+// DefaultMaxBatches is the number of batches a DiskQueue holds by default.
+const DefaultMaxBatches = 10000
+
+// DiskQueue is a durable FIFO queue of event batches. Each batch is a file
+// in the queue directory, removed once it is acknowledged.
+//
+// Once the queue holds its maximum number of batches, the oldest batches are
+// dropped. Batches which can't be read are renamed to <batch>.bad and skipped.
+type DiskQueue struct {
+	dir    string
+	max    int
+	logger log.Logger
+
+	mtx sync.Mutex
+	seq uint64
+	len int
+}
+
+// QueueOption configures a DiskQueue.
+type QueueOption func(*DiskQueue)
+
+// WithMaxBatches drops the oldest batches once the queue holds n batches.
+// The default is DefaultMaxBatches.
+func WithMaxBatches(n int) QueueOption {
+	return func(q *DiskQueue) {
+		if n > 0 {
+			q.max = n
+		}
+	}
+}
+
+// WithQueueLogger logs the batches the queue drops or moves aside.
+func WithQueueLogger(logger log.Logger) QueueOption {
+	return func(q *DiskQueue) {
+		q.logger = logger
+	}
+}
+
+// NewDiskQueue opens the queue in dir, keeping the batches queued by a previous process.
+func NewDiskQueue(dir string, opts ...QueueOption) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "create queue directory %s", dir)
+	}
+	q := &DiskQueue{dir: dir, max: DefaultMaxBatches, logger: log.NewNopLogger()}
+	for _, opt := range opts {
+		opt(q)
+	}
+	names, err := q.batches()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		fmt.Sscanf(strings.TrimSuffix(names[len(names)-1], ".json"), "%d", &q.seq)
+	}
+	q.len = len(names)
+	return q, nil
+}
+
+// batches lists the queued batch files, oldest first.
+func (q *DiskQueue) batches() ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list queue directory %s", q.dir)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Push appends a batch of events to the queue.
+func (q *DiskQueue) Push(events []eventstore.Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return errors.Wrap(err, "marshal queued events")
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.seq++
+	name := fmt.Sprintf("%020d.json", q.seq)
+
+	// batches are written to a hidden file first, so Peek never reads a partial batch.
+	tmp, err := os.CreateTemp(q.dir, "."+name+"-*")
+	if err != nil {
+		return errors.Wrap(err, "create queued batch")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write queued batch")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync queued batch")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write queued batch")
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(q.dir, name)); err != nil {
+		return errors.Wrap(err, "save queued batch")
+	}
+	q.len++
+	if q.len > q.max {
+		return q.dropOldest(q.len - q.max)
+	}
+	return nil
+}
+
+// dropOldest removes the n oldest batches.
+func (q *DiskQueue) dropOldest(n int) error {
+	names, err := q.batches()
+	if err != nil {
+		return err
+	}
+	if n > len(names) {
+		n = len(names)
+	}
+	for _, name := range names[:n] {
+		if err := os.Remove(filepath.Join(q.dir, name)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove queued batch %s", name)
+		}
+	}
+	q.len = len(names) - n
+	level.Info(q.logger).Log("msg", "queue is full, dropped the oldest batches", "dir", q.dir, "batches", n)
+	return nil
+}
+
+// Peek returns the oldest queued events, reading whole batches until it has
+// at least max events. The returned batch names are passed to Ack once the
+// events are delivered.
+func (q *DiskQueue) Peek(max int) ([]eventstore.Event, []string, error) {
+	names, err := q.batches()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		events []eventstore.Event
+		peeked []string
+	)
+	for _, name := range names {
+		if len(events) >= max {
+			break
+		}
+		batch, err := q.read(name)
+		if os.IsNotExist(errors.Cause(err)) {
+			// dropped since it was listed.
+			continue
+		}
+		if err != nil {
+			if err := q.moveAside(name, err); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		events = append(events, batch...)
+		peeked = append(peeked, name)
+	}
+	return events, peeked, nil
+}
+
+func (q *DiskQueue) read(name string) ([]eventstore.Event, error) {
+	data, err := os.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read queued batch %s", name)
+	}
+	var batch []eventstore.Event
+	err = json.Unmarshal(data, &batch)
+	return batch, errors.Wrapf(err, "decode queued batch %s", name)
+}
+
+// moveAside renames a batch which can't be read to <batch>.bad, so it
+// doesn't block the batches queued after it.
+func (q *DiskQueue) moveAside(name string, cause error) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	path := filepath.Join(q.dir, name)
+	if err := os.Rename(path, path+".bad"); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "move aside queued batch %s", name)
+	}
+	q.len--
+	level.Info(q.logger).Log("msg", "moved aside unreadable queued batch", "path", path+".bad", "err", cause)
+	return nil
+}
+
+// Ack removes delivered batches from the queue.
+func (q *DiskQueue) Ack(names []string) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for _, name := range names {
+		err := os.Remove(filepath.Join(q.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "remove queued batch %s", name)
+		}
+		q.len--
+	}
+	return nil
+}
+
+// Len returns the number of queued batches.
+func (q *DiskQueue) Len() (int, error) {
+	names, err := q.batches()
+	return len(names), err
+}
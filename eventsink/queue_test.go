@@ -0,0 +1,72 @@
+package eventsink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, WithMaxBatches(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"M1", "M2", "M3", "M4"} {
+		if err := q.Push([]eventstore.Event{{MachineID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest batch is dropped once the queue is full.
+	events, batches, err := q.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].MachineID != "M2" {
+		t.Fatalf("have %d events starting with %+v, want 3 starting with M2\n", len(events), events)
+	}
+
+	// a corrupt batch is moved aside, and doesn't block the others.
+	if err := os.WriteFile(filepath.Join(dir, batches[0]), []byte("{not json"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	events, batches, err = q.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].MachineID != "M3" {
+		t.Fatalf("have %d events starting with %+v, want 2 starting with M3\n", len(events), events)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000002.json.bad")); err != nil {
+		t.Errorf("want the corrupt batch moved aside: %s\n", err)
+	}
+
+	if err := q.Ack(batches); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Len(); err != nil || n != 0 {
+		t.Errorf("have %d batches left, want none\n", n)
+	}
+
+	// batches queued by a previous process are kept, and numbered after.
+	if err := q.Push([]eventstore.Event{{MachineID: "M5"}}); err != nil {
+		t.Fatal(err)
+	}
+	q, err = NewDiskQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push([]eventstore.Event{{MachineID: "M6"}}); err != nil {
+		t.Fatal(err)
+	}
+	events, _, err = q.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].MachineID != "M5" || events[1].MachineID != "M6" {
+		t.Errorf("have %+v after reopening, want M5 then M6\n", events)
+	}
+}
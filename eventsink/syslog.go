@@ -0,0 +1,275 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/retry"
+)
+
+// Syslog facility and severities used in message priorities.
+const (
+	facilityLocal0  = 16
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// sdID is the structured data ID of messages. 32473 is the private
+// enterprise number reserved for documentation by RFC 5612.
+const sdID = "moroz@32473"
+
+// Syslog sends events and audit records as RFC 5424 messages, with the main
+// fields as structured data and the JSON encoded record as the message.
+// Messages are framed with octet counting (RFC 6587) over TCP and TLS.
+//
+// Messages are queued in a bounded buffer, and sent by Run, which reconnects
+// to the collector after errors. Messages are dropped when the buffer is full.
+type Syslog struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+	hostname  string
+	appName   string
+	logger    log.Logger
+
+	queue   chan []byte
+	dropped int64
+	backoff func(attempt int) time.Duration
+}
+
+// NewSyslog creates a Syslog sink for a collector URL such as
+// udp://host:514, tcp://host:601 or tls://host:6514.
+// tlsConfig is used for tls URLs, and may be nil.
+func NewSyslog(collector string, tlsConfig *tls.Config, logger log.Logger) (*Syslog, error) {
+	u, err := url.Parse(collector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse syslog collector %q", collector)
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, errors.Errorf("syslog collector %q must be a udp, tcp or tls URL", collector)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &Syslog{
+		network:   u.Scheme,
+		addr:      u.Host,
+		tlsConfig: tlsConfig,
+		hostname:  hostname,
+		appName:   "moroz",
+		logger:    log.With(logger, "sink", "syslog"),
+		queue:     make(chan []byte, 10000),
+		backoff:   retry.Backoff,
+	}, nil
+}
+
+// WriteEvents queues a message per event.
+func (s *Syslog) WriteEvents(ctx context.Context, events []eventstore.Event) error {
+	for _, ev := range events {
+		severity := severityInfo
+		if strings.HasPrefix(ev.Event.Decision, "BLOCK") {
+			severity = severityWarning
+		}
+		sd := map[string]string{
+			"machine_id": ev.MachineID,
+			"decision":   ev.Event.Decision,
+			"sha256":     ev.Event.FileSHA256,
+			"file_path":  ev.Event.FilePath,
+			"user":       ev.Event.ExecutingUser,
+		}
+		if err := s.enqueue(severity, "event", ev.ReceivedAt, sd, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write queues a message for an audit record, so Syslog is also an audit.Sink.
+func (s *Syslog) Write(ctx context.Context, r audit.Record) error {
+	severity := severityInfo
+	if _, failed := r["error"]; failed {
+		severity = severityError
+	}
+	sd := map[string]string{"machine_id": r.MachineID()}
+	return s.enqueue(severity, r.EventType(), time.Now(), sd, r)
+}
+
+var errSyslogQueueFull = errors.New("syslog queue is full, message dropped")
+
+func (s *Syslog) enqueue(severity int, msgID string, ts time.Time, sd map[string]string, body interface{}) error {
+	msg, err := s.format(severity, msgID, ts, sd, body)
+	if err != nil {
+		return err
+	}
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		return errSyslogQueueFull
+	}
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (s *Syslog) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// format returns an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID key="value"...] MSG
+// syslogTime is the RFC 5424 TIMESTAMP layout, which allows at most 6
+// fractional digits of the second.
+const syslogTime = "2006-01-02T15:04:05.000000Z07:00"
+
+func (s *Syslog) format(severity int, msgID string, ts time.Time, sd map[string]string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal syslog message")
+	}
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	keys := make([]string, 0, len(sd))
+	for k, v := range sd {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		facilityLocal0*8+severity,
+		ts.UTC().Format(syslogTime),
+		s.hostname, s.appName, os.Getpid(), msgID,
+	)
+	if len(keys) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + sdID)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ` %s="%s"`, k, sdEscaper.Replace(sd[k]))
+		}
+		b.WriteString("]")
+	}
+	b.WriteString(" ")
+	b.Write(data)
+	return b.Bytes(), nil
+}
+
+// sdEscaper escapes structured data parameter values.
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// Run sends queued messages until ctx is cancelled.
+func (s *Syslog) Run(ctx context.Context) error {
+	var (
+		conn    net.Conn
+		attempt int
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var msg []byte
+		select {
+		case msg = <-s.queue:
+		default:
+			select {
+			case msg = <-s.queue:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// a write to a connection the collector closed while it was idle
+			// succeeds, and the message is lost, so it is checked first.
+			if conn != nil && s.network != "udp" && closedByPeer(conn) {
+				conn.Close()
+				conn = nil
+			}
+		}
+
+		// a message is retried until it is sent or ctx is cancelled.
+		for {
+			err := s.send(&conn, msg)
+			if err == nil {
+				attempt = 0
+				break
+			}
+			attempt++
+			level.Info(s.logger).Log("msg", "send syslog message", "attempt", attempt, "err", err)
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			if retry.Sleep(ctx, s.backoff(attempt)) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (s *Syslog) send(conn *net.Conn, msg []byte) error {
+	if *conn == nil {
+		c, err := s.dial()
+		if err != nil {
+			return err
+		}
+		*conn = c
+	}
+	(*conn).SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if s.network != "udp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	_, err := (*conn).Write(msg)
+	return errors.Wrap(err, "write syslog message")
+}
+
+// closedByPeer reports whether the collector closed the connection. Collectors
+// don't send anything, so a read returns right away with the close, or times out.
+// A deadline which already passed would fail the read before trying it.
+func closedByPeer(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(s.network, s.addr)
+	}
+	return conn, errors.Wrapf(err, "connect to syslog collector %s", s.addr)
+}
@@ -0,0 +1,308 @@
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+func TestSyslog(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	messages := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(t, conn, messages, 0)
+	}()
+
+	sink, err := NewSyslog("tcp://"+ln.Addr().String(), nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+
+	ev := eventstore.Event{
+		MachineID:  "M1",
+		ReceivedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Event:      santa.EventUploadEvent{Decision: "BLOCK_BINARY", FileSHA256: "aaa", FilePath: `/tmp/a"]`},
+	}
+	if err := sink.WriteEvents(ctx, []eventstore.Event{ev}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(ctx, audit.NewRecord(audit.Preflight, "M1", time.Now(), nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range [][]string{
+		{
+			`<132>1 2025-01-02T03:04:05.000000Z ` + sink.hostname + ` moroz `,
+			` event [moroz@32473 decision="BLOCK_BINARY" file_path="/tmp/a\"\]" machine_id="M1" sha256="aaa"] {"machine_id":"M1"`,
+		},
+		{
+			`<134>1 `,
+			` preflight [moroz@32473 machine_id="M1"] {`,
+		},
+	} {
+		select {
+		case msg := <-messages:
+			for _, part := range want {
+				if !strings.Contains(msg, part) {
+					t.Errorf("have message %q, want it to contain %q\n", msg, part)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for syslog message")
+		}
+	}
+}
+
+func TestSyslogTimestamp(t *testing.T) {
+	sink := &Syslog{hostname: "host", appName: "moroz"}
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.FixedZone("CET", 3600))
+	msg, err := sink.format(6, "event", ts, nil, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 5424 TIME-SECFRAC is at most 6 digits.
+	fields := strings.Fields(string(msg))
+	if len(fields) < 2 || !regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z$`).MatchString(fields[1]) {
+		t.Fatalf("have message %q, want a timestamp with microseconds in UTC\n", msg)
+	}
+	if fields[1] != "2025-01-02T02:04:05.123456Z" {
+		t.Errorf("have timestamp %s, want 2025-01-02T02:04:05.123456Z\n", fields[1])
+	}
+}
+
+// readFrames sends the octet counted messages read from conn to messages,
+// and returns after n messages, or when conn is closed when n is 0.
+func readFrames(t *testing.T, conn net.Conn, messages chan<- string, n int) {
+	r := bufio.NewReader(conn)
+	for i := 0; n == 0 || i < n; i++ {
+		// octet counting framing: MSG-LEN SP SYSLOG-MSG
+		size, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			t.Errorf("invalid frame length %q\n", size)
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		messages <- string(msg)
+	}
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syslog message")
+	}
+	return ""
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	messages := make(chan string)
+	dropped := make(chan struct{})
+	go func() {
+		// the collector drops the first connection after a message.
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		readFrames(t, conn, messages, 1)
+		conn.Close()
+		close(dropped)
+
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(t, conn, messages, 0)
+	}()
+
+	sink, err := NewSyslog("tcp://"+ln.Addr().String(), nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.backoff = func(int) time.Duration { return time.Millisecond }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+
+	write := func(machineID string) {
+		ev := eventstore.Event{MachineID: machineID, Event: santa.EventUploadEvent{Decision: "ALLOW_BINARY"}}
+		if err := sink.WriteEvents(ctx, []eventstore.Event{ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("M1")
+	if msg := receive(t, messages); !strings.Contains(msg, `machine_id="M1"`) {
+		t.Errorf("have message %q, want the event of M1\n", msg)
+	}
+	<-dropped
+
+	// the messages written after the collector dropped the connection aren't lost.
+	write("M2")
+	write("M3")
+	for _, id := range []string{"M2", "M3"} {
+		if msg := receive(t, messages); !strings.Contains(msg, `machine_id="`+id+`"`) {
+			t.Errorf("have message %q, want the event of %s\n", msg, id)
+		}
+	}
+}
+
+func TestSyslogQueueFull(t *testing.T) {
+	sink, err := NewSyslog("tcp://127.0.0.1:1", nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Run isn't started, so nothing drains the queue.
+	sink.queue = make(chan []byte, 1)
+
+	ctx := context.Background()
+	events := []eventstore.Event{{MachineID: "M1"}, {MachineID: "M2"}}
+	if err := sink.WriteEvents(ctx, events); err != errSyslogQueueFull {
+		t.Errorf("have error %v, want %v\n", err, errSyslogQueueFull)
+	}
+	if err := sink.Write(ctx, audit.NewRecord(audit.Preflight, "M1", time.Now(), nil)); err != errSyslogQueueFull {
+		t.Errorf("have error %v for an audit record, want %v\n", err, errSyslogQueueFull)
+	}
+	if have := sink.Dropped(); have != 2 {
+		t.Errorf("have %d dropped messages, want 2\n", have)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslog("udp://"+pc.LocalAddr().String(), nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+
+	if err := sink.WriteEvents(ctx, []eventstore.Event{{MachineID: "M1"}}); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a datagram holds a single message, without framing.
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<134>1 ") || !strings.Contains(msg, `machine_id="M1"`) {
+		t.Errorf("have datagram %q, want an unframed message for M1\n", msg)
+	}
+}
+
+func TestSyslogTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	messages := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(t, conn, messages, 0)
+	}()
+
+	sink, err := NewSyslog("tls://"+ln.Addr().String(), &tls.Config{RootCAs: pool}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+
+	for _, id := range []string{"M1", "M2"} {
+		if err := sink.WriteEvents(ctx, []eventstore.Event{{MachineID: id}}); err != nil {
+			t.Fatal(err)
+		}
+		if msg := receive(t, messages); !strings.Contains(msg, `machine_id="`+id+`"`) {
+			t.Errorf("have message %q, want the event of %s\n", msg, id)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1, and a pool trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "collector"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
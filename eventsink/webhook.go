@@ -0,0 +1,200 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/internal/match"
+	"github.com/groob/moroz/internal/retry"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the webhook request
+// body, keyed with the destination secret, as `sha256=<hmac>`.
+const SignatureHeader = "X-Moroz-Signature-256"
+
+// WebhookConfig is a webhook destination.
+type WebhookConfig struct {
+	// Name identifies the destination, and names its queue directory.
+	Name   string `toml:"name"`
+	URL    string `toml:"url"`
+	Secret string `toml:"secret,omitempty"`
+
+	// Decisions and Groups only forward events with one of the decisions,
+	// from machines in one of the groups. Empty lists forward every event.
+	Decisions []string `toml:"decisions,omitempty"`
+	Groups    []string `toml:"groups,omitempty"`
+
+	// BatchSize is the number of events sent in a request. The default is 100.
+	BatchSize int `toml:"batch_size,omitempty"`
+
+	// MaxQueuedBatches is the number of uploads queued while the destination
+	// is down, after which the oldest are dropped. The default is DefaultMaxBatches.
+	MaxQueuedBatches int `toml:"max_queued_batches,omitempty"`
+}
+
+// LoadWebhookConfigs reads the [[webhook]] tables of a TOML file.
+func LoadWebhookConfigs(path string) ([]WebhookConfig, error) {
+	var file struct {
+		Webhooks []WebhookConfig `toml:"webhook"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Wrapf(err, "decode webhook configuration %s", path)
+	}
+	names := make(map[string]bool)
+	for _, conf := range file.Webhooks {
+		if conf.Name == "" || conf.URL == "" {
+			return nil, errors.Errorf("webhook in %s needs a name and url", path)
+		}
+		if filepath.Base(conf.Name) != conf.Name || conf.Name == "." || conf.Name == ".." {
+			return nil, errors.Errorf("invalid webhook name %q", conf.Name)
+		}
+		if names[conf.Name] {
+			return nil, errors.Errorf("duplicate webhook name %q", conf.Name)
+		}
+		names[conf.Name] = true
+	}
+	return file.Webhooks, nil
+}
+
+// Webhook POSTs events to a URL as `{"events": [...]}`.
+// Events are queued on disk, so they are delivered once the receiver
+// recovers from an outage, or after a restart.
+type Webhook struct {
+	conf   WebhookConfig
+	client *http.Client
+	logger log.Logger
+	queue  *DiskQueue
+	notify chan struct{}
+
+	backoff func(attempt int) time.Duration
+}
+
+// NewWebhook creates a Webhook which queues events in queueDir/<name>.
+func NewWebhook(conf WebhookConfig, queueDir string, client *http.Client, logger log.Logger) (*Webhook, error) {
+	logger = log.With(logger, "webhook", conf.Name)
+	queue, err := NewDiskQueue(filepath.Join(queueDir, conf.Name), WithMaxBatches(conf.MaxQueuedBatches), WithQueueLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 100
+	}
+	return &Webhook{
+		conf:    conf,
+		client:  client,
+		logger:  logger,
+		queue:   queue,
+		notify:  make(chan struct{}, 1),
+		backoff: retry.Backoff,
+	}, nil
+}
+
+func (w *Webhook) match(ev eventstore.Event) bool {
+	return match.Value(w.conf.Decisions, ev.Event.Decision) && match.Any(w.conf.Groups, ev.Groups)
+}
+
+// WriteEvents queues the events matching the destination filters.
+func (w *Webhook) WriteEvents(ctx context.Context, events []eventstore.Event) error {
+	var matched []eventstore.Event
+	for _, ev := range events {
+		if w.match(ev) {
+			matched = append(matched, ev)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	if err := w.queue.Push(matched); err != nil {
+		return err
+	}
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued events until ctx is cancelled, retrying failed
+// requests with exponential backoff. Events the receiver rejects with a
+// client error other than 408 or 429 are dropped, as a retry would fail too.
+func (w *Webhook) Run(ctx context.Context) error {
+	var attempt int
+	for {
+		events, batches, err := w.queue.Peek(w.conf.BatchSize)
+		if err == nil && len(events) > 0 {
+			err = w.post(ctx, events)
+			if _, ok := err.(rejectedError); ok {
+				level.Info(w.logger).Log("msg", "dropped webhook events", "count", len(events), "err", err)
+				err = nil
+			}
+			if err == nil {
+				err = w.queue.Ack(batches)
+			}
+		}
+		switch {
+		case err != nil:
+			attempt++
+			level.Info(w.logger).Log("msg", "deliver webhook events", "count", len(events), "attempt", attempt, "err", err)
+			if retry.Sleep(ctx, w.backoff(attempt)) {
+				return ctx.Err()
+			}
+		case len(events) > 0:
+			attempt = 0
+		default:
+			select {
+			case <-w.notify:
+			case <-time.After(time.Minute):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, events []eventstore.Event) error {
+	body, err := json.Marshal(struct {
+		Events []eventstore.Event `json:"events"`
+	}{events})
+	if err != nil {
+		return errors.Wrap(err, "marshal webhook events")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create webhook request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.conf.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.conf.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send webhook request")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return checkStatus(resp)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
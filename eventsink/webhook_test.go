@@ -0,0 +1,156 @@
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		failures = 2
+		received []eventstore.Event
+		done     = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if have, want := r.Header.Get(SignatureHeader), "sha256="+Sign("secret", body); have != want {
+			t.Errorf("have signature %q, want %q\n", have, want)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		// the receiver is down for the first requests.
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload struct {
+			Events []eventstore.Event `json:"events"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received = append(received, payload.Events...)
+		if len(received) == 2 {
+			close(done)
+		}
+	}))
+	defer srv.Close()
+
+	conf := WebhookConfig{
+		Name:      "siem",
+		URL:       srv.URL,
+		Secret:    "secret",
+		Decisions: []string{"BLOCK_BINARY"},
+		Groups:    []string{"laptops"},
+	}
+	hook, err := NewWebhook(conf, t.TempDir(), srv.Client(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook.backoff = func(int) time.Duration { return time.Millisecond }
+
+	event := func(decision string, groups ...string) eventstore.Event {
+		return eventstore.Event{MachineID: "M1", Groups: groups, Event: santa.EventUploadEvent{Decision: decision}}
+	}
+	events := []eventstore.Event{
+		event("BLOCK_BINARY", "laptops"),
+		event("ALLOW_BINARY", "laptops"),
+		event("BLOCK_BINARY", "desktops"),
+		event("BLOCK_BINARY", "laptops", "desktops"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := hook.WriteEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	go hook.Run(ctx)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+	// the batch is removed from the queue once delivered.
+	for i := 0; i < 100; i++ {
+		if n, _ := hook.queue.Len(); n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("want delivered events removed from the queue\n")
+}
+
+func TestWebhookRejected(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		received []string
+		done     = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Events []eventstore.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, ev := range payload.Events {
+			received = append(received, ev.MachineID)
+		}
+		// the receiver rejects the events of M1.
+		if payload.Events[0].MachineID == "M1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		close(done)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	hook, err := NewWebhook(WebhookConfig{Name: "siem", URL: srv.URL, BatchSize: 1}, dir, srv.Client(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook.backoff = func(int) time.Duration { return time.Millisecond }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, id := range []string{"M0", "M1", "M2"} {
+		if err := hook.WriteEvents(ctx, []eventstore.Event{{MachineID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first batch is corrupt, and moved aside.
+	if err := os.WriteFile(filepath.Join(dir, "siem", "00000000000000000001.json"), []byte("[{"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	go hook.Run(ctx)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	// the rejected batch is dropped instead of retried.
+	if len(received) != 2 || received[0] != "M1" || received[1] != "M2" {
+		t.Errorf("have requests for %v, want M1 then M2\n", received)
+	}
+}
@@ -0,0 +1,183 @@
+package eventstore
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// Fingerprint identifies an event across uploads. Santa retries uploads
+// after network errors, and the retried events have the same fingerprint.
+func Fingerprint(e Event) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00%s",
+		e.MachineID,
+		e.Event.FileSHA256,
+		e.Event.ProcessID,
+		strconv.FormatFloat(e.Event.ExecutionTime, 'f', -1, 64),
+		e.Event.Decision,
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Dedup remembers the fingerprints of events ingested within a window.
+// Fingerprints are appended to a file, so they survive restarts. The file
+// is compacted once per window, dropping expired fingerprints.
+//
+// Filter reserves the fingerprints of the events it returns until they are
+// recorded or released, so concurrent uploads of the same events don't both
+// pass.
+type Dedup struct {
+	path   string
+	window time.Duration
+
+	mtx       sync.Mutex
+	seen      map[string]time.Time
+	reserved  map[string]bool
+	file      *os.File
+	compacted time.Time
+}
+
+// NewDedup loads the fingerprints recorded in path within window.
+func NewDedup(path string, window time.Duration) (*Dedup, error) {
+	d := &Dedup{
+		path:     path,
+		window:   window,
+		seen:     make(map[string]time.Time),
+		reserved: make(map[string]bool),
+	}
+	if err := d.load(time.Now()); err != nil {
+		return nil, err
+	}
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Dedup) load(now time.Time) error {
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "open event fingerprints")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			// a crash may leave a partial last line.
+			continue
+		}
+		nsec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if seenAt := time.Unix(0, nsec); now.Sub(seenAt) < d.window {
+			d.seen[fields[0]] = seenAt
+		}
+	}
+	return errors.Wrap(scanner.Err(), "read event fingerprints")
+}
+
+// compact rewrites the file with the fingerprints within the window, and
+// reopens it for appending.
+func (d *Dedup) compact() error {
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+	}
+	now := time.Now()
+	var b strings.Builder
+	for fp, seenAt := range d.seen {
+		if now.Sub(seenAt) >= d.window {
+			delete(d.seen, fp)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d\n", fp, seenAt.UnixNano())
+	}
+
+	if err := storage.WriteFileAtomic(d.path, []byte(b.String())); err != nil {
+		return errors.Wrap(err, "save event fingerprints")
+	}
+
+	var err error
+	d.file, err = os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND, 0640)
+	d.compacted = now
+	return errors.Wrap(err, "open event fingerprints")
+}
+
+// Filter returns the events which were not ingested within the window, and
+// the number of duplicates dropped, including duplicates within events and
+// events reserved by another upload. The fingerprints of the returned events
+// are reserved, and must be passed to Record or Release.
+func (d *Dedup) Filter(events []Event) ([]Event, int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := time.Now()
+	fresh := make([]Event, 0, len(events))
+	for _, ev := range events {
+		fp := Fingerprint(ev)
+		if seenAt, ok := d.seen[fp]; ok && now.Sub(seenAt) < d.window || d.reserved[fp] {
+			continue
+		}
+		d.reserved[fp] = true
+		fresh = append(fresh, ev)
+	}
+	return fresh, len(events) - len(fresh)
+}
+
+// Release forgets the reserved fingerprints of events which couldn't be
+// stored, so the client's retry is accepted.
+func (d *Dedup) Release(events []Event) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, ev := range events {
+		delete(d.reserved, Fingerprint(ev))
+	}
+}
+
+// Record remembers the fingerprints of ingested events.
+func (d *Dedup) Record(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := time.Now()
+	var b strings.Builder
+	for _, ev := range events {
+		fp := Fingerprint(ev)
+		delete(d.reserved, fp)
+		d.seen[fp] = now
+		fmt.Fprintf(&b, "%s %d\n", fp, now.UnixNano())
+	}
+	if _, err := d.file.WriteString(b.String()); err != nil {
+		return errors.Wrap(err, "append event fingerprints")
+	}
+	if now.Sub(d.compacted) > d.window {
+		return d.compact()
+	}
+	return nil
+}
+
+// Close closes the fingerprints file.
+func (d *Dedup) Close() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.file.Close()
+}
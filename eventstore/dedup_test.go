@@ -0,0 +1,85 @@
+package eventstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestDedup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fingerprints")
+	d, err := NewDedup(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := Event{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ProcessID: 1, ExecutionTime: 100.5, Decision: "BLOCK_BINARY"}}
+	other := ev
+	other.Event.ProcessID = 2
+	// the receive time is not part of the fingerprint.
+	retried := ev
+	retried.ReceivedAt = time.Now()
+
+	fresh, duplicates := d.Filter([]Event{ev, ev, other})
+	if len(fresh) != 2 || duplicates != 1 {
+		t.Fatalf("have %d fresh and %d duplicates, want 2 and 1\n", len(fresh), duplicates)
+	}
+	if err := d.Record(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// fingerprints survive a restart.
+	d, err = NewDedup(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if fresh, duplicates := d.Filter([]Event{retried, other}); len(fresh) != 0 || duplicates != 2 {
+		t.Errorf("have %d fresh and %d duplicates after restart, want 0 and 2\n", len(fresh), duplicates)
+	}
+
+	expired, err := NewDedup(path, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expired.Close()
+	if fresh, _ := expired.Filter([]Event{ev}); len(fresh) != 1 {
+		t.Errorf("want fingerprints outside the window forgotten\n")
+	}
+}
+
+func TestDedupReserve(t *testing.T) {
+	d, err := NewDedup(filepath.Join(t.TempDir(), "fingerprints"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	ev := Event{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100}}
+
+	// a concurrent upload of the same event is dropped while the first is stored.
+	if fresh, _ := d.Filter([]Event{ev}); len(fresh) != 1 {
+		t.Fatalf("have %d fresh events, want 1\n", len(fresh))
+	}
+	if fresh, duplicates := d.Filter([]Event{ev}); len(fresh) != 0 || duplicates != 1 {
+		t.Errorf("have %d fresh and %d duplicates for a reserved event, want 0 and 1\n", len(fresh), duplicates)
+	}
+
+	// the client's retry is accepted once the failed upload is released.
+	d.Release([]Event{ev})
+	fresh, _ := d.Filter([]Event{ev})
+	if len(fresh) != 1 {
+		t.Fatalf("have %d fresh events after release, want 1\n", len(fresh))
+	}
+	if err := d.Record(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if fresh, _ := d.Filter([]Event{ev}); len(fresh) != 0 {
+		t.Errorf("have %d fresh events after record, want none\n", len(fresh))
+	}
+}
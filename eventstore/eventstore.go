@@ -0,0 +1,220 @@
+// Package eventstore persists the execution events uploaded by Santa clients.
+package eventstore
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/santa"
+)
+
+// Event is an event uploaded by a machine.
+type Event struct {
+	// ID identifies the event within the store which returned it.
+	ID         string                 `json:"id,omitempty"`
+	MachineID  string                 `json:"machine_id"`
+	ReceivedAt time.Time              `json:"received_at"`
+	Event      santa.EventUploadEvent `json:"event"`
+
+	// Groups, Hostname and ClientMode describe the machine when the event is
+	// forwarded to sinks, from its last preflight. Stores don't keep them.
+	Groups     []string `json:"groups,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	ClientMode string   `json:"client_mode,omitempty"`
+}
+
+// ExecutedAt returns the time the event was executed on the machine.
+func (e Event) ExecutedAt() time.Time {
+	sec := int64(e.Event.ExecutionTime)
+	nsec := int64((e.Event.ExecutionTime - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec).UTC()
+}
+
+// ErrInvalidCursor is returned by stores for a Query with a cursor they didn't return.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	// DefaultLimit is the page size used when a Query doesn't set one.
+	DefaultLimit = 100
+	// MaxLimit is the largest page size a store returns.
+	MaxLimit = 1000
+)
+
+// Query selects events. Empty fields match every event.
+// Since and Until bound the execution time of the event.
+type Query struct {
+	MachineID     string
+	SHA256        string
+	Decision      string
+	TeamID        string
+	SigningID     string
+	ExecutingUser string
+	// CertSHA256 selects events with the certificate anywhere in their signing chain.
+	CertSHA256 string
+	Since      time.Time
+	Until      time.Time
+	// Decisions selects events with one of the decisions, and
+	// ExcludeDecisions skips them.
+	Decisions        []string
+	ExcludeDecisions []string
+
+	// Limit and Cursor page through the results of a query, newest first.
+	// Cursor is the Next value of the previous Page.
+	Limit  int
+	Cursor string
+}
+
+// Page is a page of query results.
+// Next is empty when there are no more results.
+type Page struct {
+	Events []Event `json:"events"`
+	Next   string  `json:"next,omitempty"`
+}
+
+// Match reports whether e is selected by q.
+func (q Query) Match(e Event) bool {
+	switch {
+	case q.MachineID != "" && q.MachineID != e.MachineID:
+		return false
+	case q.SHA256 != "" && q.SHA256 != e.Event.FileSHA256:
+		return false
+	case q.Decision != "" && q.Decision != e.Event.Decision:
+		return false
+	case q.TeamID != "" && q.TeamID != e.Event.TeamID:
+		return false
+	case q.SigningID != "" && q.SigningID != e.Event.SigningID:
+		return false
+	case q.ExecutingUser != "" && q.ExecutingUser != e.Event.ExecutingUser:
+		return false
+	case q.CertSHA256 != "" && !signedBy(e, q.CertSHA256):
+		return false
+	case len(q.Decisions) > 0 && !slices.Contains(q.Decisions, e.Event.Decision):
+		return false
+	case slices.Contains(q.ExcludeDecisions, e.Event.Decision):
+		return false
+	}
+	executed := e.ExecutedAt()
+	if !q.Since.IsZero() && executed.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !executed.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+func signedBy(e Event, certSHA256 string) bool {
+	for _, cert := range e.Event.SigningChain {
+		if cert.SHA256 == certSHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// PageLimit returns the page size of q, bounded by MaxLimit.
+func (q Query) PageLimit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+// pageCollector keeps the matching events which may be on the page selected
+// by q, for stores which scan every event. Like the SQLStore cursor, the
+// cursor holds the execution time and ID of the last event of the previous
+// page, so at most twice the events of a page are held while scanning.
+type pageCollector struct {
+	after  *cursor
+	limit  int
+	events []Event
+}
+
+func newPageCollector(q Query) (*pageCollector, error) {
+	c := &pageCollector{limit: q.PageLimit()}
+	if q.Cursor != "" {
+		after, err := parseCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		c.after = &after
+	}
+	return c, nil
+}
+
+// add collects ev, dropping the events which sort after the next page cursor.
+func (c *pageCollector) add(ev Event) {
+	if c.after != nil && !c.after.before(ev) {
+		return
+	}
+	c.events = append(c.events, ev)
+	// one more event than the page tells whether there is a next page.
+	keep := c.limit + 1
+	if len(c.events) >= 2*keep {
+		sortEvents(c.events)
+		c.events = c.events[:keep]
+	}
+}
+
+// page returns the page of the collected events.
+func (c *pageCollector) page() Page {
+	sortEvents(c.events)
+	page := Page{Events: c.events}
+	if len(c.events) > c.limit {
+		page.Events = c.events[:c.limit]
+		page.Next = newCursor(page.Events[c.limit-1]).String()
+	}
+	if page.Events == nil {
+		page.Events = []Event{}
+	}
+	return page
+}
+
+// cursor is the position of the last event of a page: its execution time
+// and ID.
+type cursor struct {
+	executionTime float64
+	id            string
+}
+
+func newCursor(ev Event) cursor {
+	return cursor{executionTime: ev.Event.ExecutionTime, id: ev.ID}
+}
+
+// parseCursor parses a cursor. Execution times never hold a colon, IDs may.
+func parseCursor(s string) (cursor, error) {
+	executionTime, id, ok := strings.Cut(s, ":")
+	t, err := strconv.ParseFloat(executionTime, 64)
+	if !ok || err != nil {
+		return cursor{}, errors.Wrapf(ErrInvalidCursor, "cursor %q", s)
+	}
+	return cursor{executionTime: t, id: id}, nil
+}
+
+func (c cursor) String() string {
+	return strconv.FormatFloat(c.executionTime, 'f', -1, 64) + ":" + c.id
+}
+
+// before reports whether the cursor sorts before ev, so ev is on a later page.
+func (c cursor) before(ev Event) bool {
+	t := ev.Event.ExecutionTime
+	return t < c.executionTime || t == c.executionTime && ev.ID > c.id
+}
+
+// sortEvents orders events newest first by execution time, then by ID.
+func sortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Event.ExecutionTime != events[j].Event.ExecutionTime {
+			return events[i].Event.ExecutionTime > events[j].Event.ExecutionTime
+		}
+		return events[i].ID < events[j].ID
+	})
+}
@@ -0,0 +1,271 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore writes each event to its own file, at
+// <dir>/<sha256>/<machine id>/<execution time>.json.
+// The file holds the event as uploaded by Santa. Its modification time is the receive time.
+// Events without a SHA-256 are written to the noSHA256Dir directory instead.
+type FileStore struct {
+	dir  string
+	sync bool
+}
+
+// FileOption configures a FileStore.
+type FileOption func(*FileStore)
+
+// WithFileSync makes the FileStore fsync every event file before Put returns.
+func WithFileSync() FileOption {
+	return func(f *FileStore) {
+		f.sync = true
+	}
+}
+
+// NewFileStore creates a FileStore rooted at dir.
+func NewFileStore(dir string, opts ...FileOption) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "create event directory %s", dir)
+	}
+	f := &FileStore{dir: dir}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f, nil
+}
+
+// noSHA256Dir holds the events uploaded without a file SHA-256.
+const noSHA256Dir = "_nosha256"
+
+// Put checks the path of every event before writing any, so that a rejected
+// batch doesn't leave events behind to be written again by the client's retry.
+func (f *FileStore) Put(ctx context.Context, events []Event) error {
+	for _, ev := range events {
+		if err := validPathElem(eventSHA256Dir(ev)); err != nil {
+			return err
+		}
+		if err := validPathElem(ev.MachineID); err != nil {
+			return err
+		}
+	}
+	for _, ev := range events {
+		if err := f.put(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventSHA256Dir returns the name of the directory holding the events of ev's file.
+func eventSHA256Dir(ev Event) string {
+	if ev.Event.FileSHA256 == "" {
+		return noSHA256Dir
+	}
+	return ev.Event.FileSHA256
+}
+
+func (f *FileStore) put(ev Event) error {
+	eventDir := filepath.Join(f.dir, eventSHA256Dir(ev), ev.MachineID)
+	if err := os.MkdirAll(eventDir, 0750); err != nil {
+		return errors.Wrapf(err, "create event directory %s", eventDir)
+	}
+
+	data, err := json.Marshal(ev.Event)
+	if err != nil {
+		return errors.Wrap(err, "marshal event info to json")
+	}
+
+	// events executed at the same time get a numbered suffix instead of overwriting each other.
+	name := fmt.Sprintf("%f", ev.Event.ExecutionTime)
+	var file *os.File
+	for i := 0; file == nil; i++ {
+		eventPath := filepath.Join(eventDir, name+".json")
+		if i > 0 {
+			eventPath = filepath.Join(eventDir, fmt.Sprintf("%s-%d.json", name, i))
+		}
+		file, err = os.OpenFile(eventPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "create event file %s", eventPath)
+		}
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(err, "write event to path %s", file.Name())
+	}
+	if f.sync {
+		if err := file.Sync(); err != nil {
+			return errors.Wrapf(err, "sync event file %s", file.Name())
+		}
+	}
+	return errors.Wrapf(file.Close(), "close event file %s", file.Name())
+}
+
+// walk calls fn with every event selected by q. Events are read from the
+// directories of the queried SHA-256 and machine ID only, when set.
+func (f *FileStore) walk(ctx context.Context, q Query, fn func(path string, ev Event) error) error {
+	root := f.dir
+	if q.SHA256 != "" {
+		if err := validPathElem(q.SHA256); err != nil {
+			return err
+		}
+		root = filepath.Join(root, q.SHA256)
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(f.dir, path)
+		if err != nil {
+			return err
+		}
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			if len(elems) == 2 && q.MachineID != "" && elems[1] != q.MachineID {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(elems) != 3 || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		ev := Event{ID: filepath.ToSlash(rel), MachineID: elems[1]}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "read event file %s", path)
+		}
+		if err := json.Unmarshal(data, &ev.Event); err != nil {
+			return errors.Wrapf(err, "decode event file %s", path)
+		}
+		if info, err := d.Info(); err == nil {
+			ev.ReceivedAt = info.ModTime().UTC()
+		}
+		if !q.Match(ev) {
+			return nil
+		}
+		return fn(path, ev)
+	})
+	return errors.Wrapf(err, "walk event directory %s", root)
+}
+
+// Query scans the event files. It is meant for small deployments.
+func (f *FileStore) Query(ctx context.Context, q Query) (Page, error) {
+	c, err := newPageCollector(q)
+	if err != nil {
+		return Page{}, err
+	}
+	err = f.walk(ctx, q, func(path string, ev Event) error {
+		c.add(ev)
+		return nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return c.page(), nil
+}
+
+// Delete removes the events selected by q, and the directories it leaves empty.
+func (f *FileStore) Delete(ctx context.Context, q Query) (int, error) {
+	var deleted int
+	err := f.walk(ctx, q, func(path string, ev Event) error {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "remove event file %s", path)
+		}
+		deleted++
+		// fails unless the directories are empty.
+		machineDir := filepath.Dir(path)
+		if os.Remove(machineDir) == nil {
+			os.Remove(filepath.Dir(machineDir))
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// LimitSize removes the events executed first until the event files use at
+// most max bytes. Event files are ordered by the execution time in their name,
+// so they aren't read.
+func (f *FileStore) LimitSize(ctx context.Context, max int64) (int, int64, error) {
+	type eventFile struct {
+		path     string
+		size     int64
+		executed float64
+	}
+	var (
+		files []eventFile
+		total int64
+	)
+	err := filepath.WalkDir(f.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(d.Name(), ".json")
+		if i := strings.IndexByte(name, '-'); i > 0 {
+			name = name[:i]
+		}
+		executed, _ := strconv.ParseFloat(name, 64)
+		files = append(files, eventFile{path: path, size: info.Size(), executed: executed})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, total, errors.Wrapf(err, "walk event directory %s", f.dir)
+	}
+	if total <= max {
+		return 0, total, nil
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].executed < files[j].executed })
+	var removed int
+	for _, file := range files {
+		if total <= max {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			return removed, total, errors.Wrapf(err, "remove event file %s", file.path)
+		}
+		total -= file.size
+		removed++
+		machineDir := filepath.Dir(file.path)
+		if os.Remove(machineDir) == nil {
+			os.Remove(filepath.Dir(machineDir))
+		}
+	}
+	return removed, total, nil
+}
+
+// validPathElem rejects values which can't safely be used as a file name.
+func validPathElem(s string) error {
+	if s == "" || s == "." || s == ".." || filepath.Base(s) != s {
+		return errors.Errorf("invalid event path element %q", s)
+	}
+	return nil
+}
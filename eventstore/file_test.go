@@ -0,0 +1,151 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	events := []Event{
+		{MachineID: "M1", ReceivedAt: now, Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100, Decision: "BLOCK_BINARY"}},
+		// executed at the same time, must not overwrite the first event.
+		{MachineID: "M1", ReceivedAt: now, Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100, Decision: "ALLOW_BINARY"}},
+		{MachineID: "M2", ReceivedAt: now, Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 200, Decision: "BLOCK_BINARY"}},
+		{MachineID: "M2", ReceivedAt: now, Event: santa.EventUploadEvent{FileSHA256: "bbb", ExecutionTime: 300, Decision: "ALLOW_UNKNOWN"}},
+	}
+	if err := store.Put(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := store.Query(ctx, Query{SHA256: "aaa", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 || page.Next == "" {
+		t.Fatalf("have %d events and next %q, want 2 and a cursor\n", len(page.Events), page.Next)
+	}
+	if have, want := page.Events[0].MachineID, "M2"; have != want {
+		t.Errorf("have newest event from %s, want %s\n", have, want)
+	}
+	page, err = store.Query(ctx, Query{SHA256: "aaa", Limit: 2, Cursor: page.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Next != "" {
+		t.Fatalf("have %d events and next %q on the last page, want 1 and none\n", len(page.Events), page.Next)
+	}
+
+	page, err = store.Query(ctx, Query{Decision: "BLOCK_BINARY", Since: time.Unix(150, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].MachineID != "M2" {
+		t.Errorf("have %+v, want the block on M2\n", page.Events)
+	}
+
+	deleted, err := store.Delete(ctx, Query{MachineID: "M1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("have %d events deleted, want 2\n", deleted)
+	}
+	page, err = store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 {
+		t.Errorf("have %d events left, want 2\n", len(page.Events))
+	}
+}
+
+func TestFileStorePutBatch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an event without a SHA-256 is kept, under a placeholder directory.
+	events := []Event{
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{ExecutionTime: 200}},
+	}
+	if err := store.Put(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Query(ctx, Query{MachineID: "M1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 {
+		t.Fatalf("have %d events, want 2\n", len(page.Events))
+	}
+	if page.Events[0].Event.FileSHA256 != "" {
+		t.Errorf("have SHA-256 %q for the hashless event, want none\n", page.Events[0].Event.FileSHA256)
+	}
+
+	// a batch with an invalid event is rejected before any event is written.
+	events = []Event{
+		{MachineID: "M2", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100}},
+		{MachineID: "M2", Event: santa.EventUploadEvent{FileSHA256: "../aaa", ExecutionTime: 200}},
+	}
+	if err := store.Put(ctx, events); err == nil {
+		t.Fatal("batch with an invalid SHA-256 stored without an error")
+	}
+	page, err = store.Query(ctx, Query{MachineID: "M2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 0 {
+		t.Errorf("have %d events from the rejected batch, want none\n", len(page.Events))
+	}
+}
+
+func TestFileStoreQueryPages(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// events executed at the same time are paged by ID.
+	var events []Event
+	for _, machineID := range []string{"M1", "M2", "M3", "M4", "M5"} {
+		events = append(events, Event{MachineID: machineID, Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100}})
+	}
+	if err := store.Put(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	q := Query{Limit: 2}
+	for {
+		page, err := store.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ev := range page.Events {
+			if seen[ev.MachineID] {
+				t.Errorf("have event of %s on two pages\n", ev.MachineID)
+			}
+			seen[ev.MachineID] = true
+		}
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	if len(seen) != len(events) {
+		t.Errorf("have %d events over the pages, want %d\n", len(seen), len(events))
+	}
+}
@@ -0,0 +1,649 @@
+package eventstore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// JSONLStore appends events to a log of newline delimited JSON segments.
+// The active segment is rotated when it grows past a size or age, and rotated
+// segments are gzip compressed in the background. An index of the segments and
+// the time range of the events they hold is kept in index.json, so old
+// segments can be pruned without reading them.
+type JSONLStore struct {
+	dir      string
+	maxBytes int64
+	interval time.Duration
+	maxAge   time.Duration
+	sync     bool
+	logger   log.Logger
+
+	// mtx guards the index and the active segment. It is held by Put, and
+	// only briefly by the maintenance of rotated segments.
+	mtx    sync.Mutex
+	index  []Segment
+	active *os.File
+
+	// maintMtx serializes the maintenance of rotated segments: compression,
+	// deletion and pruning. maint counts the maintenance runs started by Put.
+	maintMtx sync.Mutex
+	maint    sync.WaitGroup
+}
+
+// Segment is an index entry of the JSONLStore.
+type Segment struct {
+	Name       string    `json:"name"`
+	Opened     time.Time `json:"opened"`
+	Compressed bool      `json:"compressed"`
+	Events     int       `json:"events"`
+	Bytes      int64     `json:"bytes"`
+
+	// FirstReceived and LastReceived bound the receive time of the events in the segment.
+	FirstReceived time.Time `json:"first_received"`
+	LastReceived  time.Time `json:"last_received"`
+}
+
+// observe extends the receive time range of the segment to t.
+func (seg *Segment) observe(t time.Time) {
+	if seg.FirstReceived.IsZero() || t.Before(seg.FirstReceived) {
+		seg.FirstReceived = t
+	}
+	if t.After(seg.LastReceived) {
+		seg.LastReceived = t
+	}
+}
+
+// JSONLOption configures a JSONLStore.
+type JSONLOption func(*JSONLStore)
+
+// WithMaxSegmentBytes rotates the active segment once it is larger than n bytes.
+// The default is 64MB.
+func WithMaxSegmentBytes(n int64) JSONLOption {
+	return func(s *JSONLStore) {
+		s.maxBytes = n
+	}
+}
+
+// WithRotateInterval rotates the active segment once it is older than d.
+// The default is 24 hours.
+func WithRotateInterval(d time.Duration) JSONLOption {
+	return func(s *JSONLStore) {
+		s.interval = d
+	}
+}
+
+// WithMaxAge prunes segments which only hold events received more than d ago,
+// each time a segment is rotated. Segments are kept forever when d is zero.
+func WithMaxAge(d time.Duration) JSONLOption {
+	return func(s *JSONLStore) {
+		s.maxAge = d
+	}
+}
+
+// WithJSONLSync makes the JSONLStore fsync the active segment before Put returns.
+func WithJSONLSync() JSONLOption {
+	return func(s *JSONLStore) {
+		s.sync = true
+	}
+}
+
+// WithJSONLLogger logs the errors of the background maintenance of segments.
+func WithJSONLLogger(logger log.Logger) JSONLOption {
+	return func(s *JSONLStore) {
+		s.logger = logger
+	}
+}
+
+const indexName = "index.json"
+
+// NewJSONLStore opens the event log in dir. A segment left active by a
+// previous process is rotated.
+func NewJSONLStore(dir string, opts ...JSONLOption) (*JSONLStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "create event log directory %s", dir)
+	}
+	s := &JSONLStore{
+		dir:      dir,
+		maxBytes: 64 << 20,
+		interval: 24 * time.Hour,
+		logger:   log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, indexName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read event log index")
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.index); err != nil {
+			return nil, errors.Wrap(err, "decode event log index")
+		}
+	}
+	for i := range s.index {
+		seg := &s.index[i]
+		if seg.Compressed {
+			continue
+		}
+		// the process stopped before the segment was rotated, so its index entry may be stale.
+		if _, err := os.Stat(s.segmentPath(*seg)); os.IsNotExist(err) {
+			seg.Compressed = true
+			if err := s.recount(seg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := s.compress(seg); err != nil {
+			return nil, err
+		}
+	}
+	return s, s.saveIndex()
+}
+
+func (s *JSONLStore) segmentPath(seg Segment) string {
+	if seg.Compressed {
+		return filepath.Join(s.dir, seg.Name+".gz")
+	}
+	return filepath.Join(s.dir, seg.Name)
+}
+
+func (s *JSONLStore) Put(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now().UTC()
+	if s.rotateDue(now) {
+		if err := s.seal(); err != nil {
+			return err
+		}
+		s.maint.Add(1)
+		go s.maintain()
+	}
+	if s.active == nil {
+		if err := s.open(now); err != nil {
+			return err
+		}
+	}
+
+	seg := &s.index[len(s.index)-1]
+	var buf []byte
+	for _, ev := range events {
+		if ev.ReceivedAt.IsZero() {
+			ev.ReceivedAt = now
+		}
+		ev.ID = ""
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "marshal event to json")
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+		seg.observe(ev.ReceivedAt)
+	}
+	if _, err := s.active.Write(buf); err != nil {
+		return errors.Wrapf(err, "append to event log segment %s", seg.Name)
+	}
+	if s.sync {
+		if err := s.active.Sync(); err != nil {
+			return errors.Wrapf(err, "sync event log segment %s", seg.Name)
+		}
+	}
+	seg.Events += len(events)
+	seg.Bytes += int64(len(buf))
+	return nil
+}
+
+// open starts a new active segment.
+func (s *JSONLStore) open(now time.Time) error {
+	seg := Segment{
+		Name:   fmt.Sprintf("events-%d.jsonl", now.UnixNano()),
+		Opened: now,
+	}
+	f, err := os.OpenFile(s.segmentPath(seg), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "create event log segment %s", seg.Name)
+	}
+	s.active = f
+	s.index = append(s.index, seg)
+	return s.saveIndex()
+}
+
+// rotateDue reports whether the active segment is past its size or age.
+func (s *JSONLStore) rotateDue(now time.Time) bool {
+	if s.active == nil {
+		return false
+	}
+	seg := s.index[len(s.index)-1]
+	return seg.Bytes >= s.maxBytes || now.Sub(seg.Opened) >= s.interval
+}
+
+// seal closes the active segment, which is left for maintenance to compress.
+func (s *JSONLStore) seal() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return errors.Wrap(err, "close active event log segment")
+}
+
+// maintain compresses the sealed segments, then prunes the segments past the
+// maximum age. Put starts it in the background after a rotation, so uploads
+// never wait for a segment to be compressed.
+func (s *JSONLStore) maintain() {
+	defer s.maint.Done()
+	s.maintMtx.Lock()
+	defer s.maintMtx.Unlock()
+
+	if err := s.compressSealed(); err != nil {
+		level.Info(s.logger).Log("msg", "compress event log segment", "err", err)
+		return
+	}
+	if s.maxAge > 0 {
+		s.mtx.Lock()
+		_, err := s.prune(time.Now().Add(-s.maxAge))
+		s.mtx.Unlock()
+		if err != nil {
+			level.Info(s.logger).Log("msg", "prune event log segments", "err", err)
+		}
+	}
+}
+
+// compressSealed compresses the sealed segments which aren't yet. The caller
+// holds maintMtx, and mtx is only taken to swap the index entries.
+func (s *JSONLStore) compressSealed() error {
+	for _, seg := range s.sealed() {
+		if seg.Compressed {
+			continue
+		}
+		compressed, err := s.rewrite(seg, func(Event) bool { return true })
+		if err != nil {
+			return err
+		}
+		if err := s.swap(seg, &compressed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealed returns a copy of the index without the active segment.
+func (s *JSONLStore) sealed() []Segment {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	n := len(s.index)
+	if s.active != nil {
+		n--
+	}
+	return append([]Segment(nil), s.index[:n]...)
+}
+
+// swap replaces the index entry of seg with the rewritten segment, or removes
+// it when rewritten is nil, and removes the files seg no longer needs. Queries
+// which copied the index before the swap look a missing segment up again.
+func (s *JSONLStore) swap(seg Segment, rewritten *Segment) error {
+	s.mtx.Lock()
+	for i := range s.index {
+		if s.index[i].Name != seg.Name {
+			continue
+		}
+		if rewritten == nil {
+			s.index = append(s.index[:i], s.index[i+1:]...)
+		} else {
+			s.index[i] = *rewritten
+		}
+		break
+	}
+	err := s.saveIndex()
+	s.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var remove []string
+	if !seg.Compressed {
+		remove = append(remove, s.segmentPath(seg))
+	}
+	if rewritten == nil {
+		seg.Compressed = true
+		remove = append(remove, s.segmentPath(seg))
+	}
+	for _, path := range remove {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove event log segment %s", seg.Name)
+		}
+	}
+	return nil
+}
+
+// compress compresses an uncompressed segment in place, updating its index entry.
+func (s *JSONLStore) compress(seg *Segment) error {
+	compressed, err := s.rewrite(*seg, func(Event) bool { return true })
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.segmentPath(*seg)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove uncompressed event log segment %s", seg.Name)
+	}
+	*seg = compressed
+	return nil
+}
+
+// recount recomputes the index entry of a segment from its events.
+func (s *JSONLStore) recount(seg *Segment) error {
+	seg.Events = 0
+	seg.FirstReceived, seg.LastReceived = time.Time{}, time.Time{}
+	err := s.scan(*seg, func(ev Event) error {
+		seg.Events++
+		seg.observe(ev.ReceivedAt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.segmentPath(*seg)); err == nil {
+		seg.Bytes = info.Size()
+	}
+	return nil
+}
+
+// rewrite streams the events of seg which keep selects through gzip, line by
+// line, into the compressed file of the segment, and returns its index entry.
+// The uncompressed file of seg is left in place.
+func (s *JSONLStore) rewrite(seg Segment, keep func(Event) bool) (Segment, error) {
+	out := Segment{Name: seg.Name, Opened: seg.Opened, Compressed: true}
+	tmp, err := os.CreateTemp(s.dir, "."+seg.Name+"-*")
+	if err != nil {
+		return seg, errors.Wrap(err, "create temporary event log segment")
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	err = s.scan(seg, func(ev Event) error {
+		if !keep(ev) {
+			return nil
+		}
+		ev.ID = ""
+		if err := enc.Encode(ev); err != nil {
+			return errors.Wrapf(err, "compress event log segment %s", seg.Name)
+		}
+		out.Events++
+		out.observe(ev.ReceivedAt)
+		return nil
+	})
+	if err == nil {
+		err = errors.Wrapf(zw.Close(), "compress event log segment %s", seg.Name)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = errors.Wrapf(cerr, "compress event log segment %s", seg.Name)
+	}
+	if err != nil {
+		return seg, err
+	}
+	if info, err := os.Stat(tmp.Name()); err == nil {
+		out.Bytes = info.Size()
+	}
+	return out, errors.Wrapf(os.Rename(tmp.Name(), s.segmentPath(out)), "save event log segment %s", seg.Name)
+}
+
+func (s *JSONLStore) saveIndex() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal event log index")
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+indexName+"-*")
+	if err != nil {
+		return errors.Wrap(err, "create temporary event log index")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write event log index")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write event log index")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), filepath.Join(s.dir, indexName)), "save event log index")
+}
+
+// scan calls fn with every event of the segment. Event IDs are the segment
+// name and line number.
+func (s *JSONLStore) scan(seg Segment, fn func(Event) error) error {
+	f, err := os.Open(s.segmentPath(seg))
+	if err != nil {
+		return errors.Wrapf(err, "open event log segment %s", seg.Name)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if seg.Compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "open compressed event log segment %s", seg.Name)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			// a crash may leave a partial last line in the active segment.
+			if !seg.Compressed {
+				continue
+			}
+			return errors.Wrapf(err, "decode event log segment %s line %d", seg.Name, line)
+		}
+		ev.ID = seg.Name + ":" + strconv.Itoa(line)
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(scanner.Err(), "read event log segment %s", seg.Name)
+}
+
+// Query scans the segments which may hold events selected by q. The index is
+// copied under the lock and the segments are read without it, so a slow query
+// doesn't block Put.
+func (s *JSONLStore) Query(ctx context.Context, q Query) (Page, error) {
+	c, err := newPageCollector(q)
+	if err != nil {
+		return Page{}, err
+	}
+	for _, seg := range s.Segments() {
+		// an event is received after it is executed.
+		if !q.Since.IsZero() && seg.Events > 0 && seg.LastReceived.Before(q.Since) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return Page{}, err
+		}
+		err := s.scanSegment(seg, func(ev Event) error {
+			if q.Match(ev) {
+				c.add(ev)
+			}
+			return nil
+		})
+		if err != nil {
+			return Page{}, err
+		}
+	}
+	return c.page(), nil
+}
+
+// scanSegment scans a segment of an index copy. A segment missing from the
+// disk was compressed, rewritten or pruned since the copy, and is looked up
+// again in the index.
+func (s *JSONLStore) scanSegment(seg Segment, fn func(Event) error) error {
+	err := s.scan(seg, fn)
+	if err == nil || !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	s.mtx.Lock()
+	var (
+		current Segment
+		found   bool
+	)
+	for _, v := range s.index {
+		if v.Name == seg.Name {
+			current, found = v, true
+		}
+	}
+	s.mtx.Unlock()
+	if !found || current.Compressed == seg.Compressed {
+		return nil
+	}
+	return s.scan(current, fn)
+}
+
+// Delete rewrites the segments holding events selected by q, streaming each
+// one through gzip. The active segment is rotated first. Put only waits for
+// the rotation and the index updates, not for the rewrites.
+func (s *JSONLStore) Delete(ctx context.Context, q Query) (int, error) {
+	s.maintMtx.Lock()
+	defer s.maintMtx.Unlock()
+	s.mtx.Lock()
+	err := s.seal()
+	s.mtx.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for _, seg := range s.sealed() {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		var matched int
+		err := s.scan(seg, func(ev Event) error {
+			if q.Match(ev) {
+				matched++
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if matched == 0 && seg.Compressed {
+			continue
+		}
+		rewritten, err := s.rewrite(seg, func(ev Event) bool { return !q.Match(ev) })
+		if err != nil {
+			return deleted, err
+		}
+		if rewritten.Events == 0 {
+			err = s.swap(seg, nil)
+		} else {
+			err = s.swap(seg, &rewritten)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted += matched
+	}
+	return deleted, nil
+}
+
+// Prune removes the rotated segments which only hold events received before t.
+// It returns the number of events removed.
+func (s *JSONLStore) Prune(t time.Time) (int, error) {
+	s.maintMtx.Lock()
+	defer s.maintMtx.Unlock()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.prune(t)
+}
+
+func (s *JSONLStore) prune(t time.Time) (int, error) {
+	var (
+		pruned int
+		kept   []Segment
+	)
+	for _, seg := range s.index {
+		if !seg.Compressed || !seg.LastReceived.Before(t) {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := os.Remove(s.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return pruned, errors.Wrapf(err, "remove event log segment %s", seg.Name)
+		}
+		pruned += seg.Events
+	}
+	s.index = kept
+	return pruned, s.saveIndex()
+}
+
+// LimitSize removes the oldest rotated segments until the event log uses at
+// most max bytes. The active segment is never removed.
+func (s *JSONLStore) LimitSize(ctx context.Context, max int64) (int, int64, error) {
+	s.maintMtx.Lock()
+	defer s.maintMtx.Unlock()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var total int64
+	for _, seg := range s.index {
+		total += seg.Bytes
+	}
+	var (
+		removed int
+		kept    []Segment
+	)
+	for _, seg := range s.index {
+		if total <= max || !seg.Compressed {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := os.Remove(s.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return removed, total, errors.Wrapf(err, "remove event log segment %s", seg.Name)
+		}
+		total -= seg.Bytes
+		removed += seg.Events
+	}
+	if removed == 0 {
+		return 0, total, nil
+	}
+	s.index = kept
+	return removed, total, s.saveIndex()
+}
+
+// Segments returns the index of the event log.
+func (s *JSONLStore) Segments() []Segment {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]Segment(nil), s.index...)
+}
+
+// Close rotates the active segment, and compresses it once the maintenance
+// started by Put is done.
+func (s *JSONLStore) Close() error {
+	s.mtx.Lock()
+	err := s.seal()
+	s.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	s.maint.Wait()
+	s.maintMtx.Lock()
+	defer s.maintMtx.Unlock()
+	return s.compressSealed()
+}
@@ -0,0 +1,179 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestJSONLStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewJSONLStore(dir, WithMaxSegmentBytes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour).UTC()
+	put := func(receivedAt time.Time, sha string) {
+		ev := Event{MachineID: "M1", ReceivedAt: receivedAt, Event: santa.EventUploadEvent{FileSHA256: sha, ExecutionTime: float64(receivedAt.Unix())}}
+		if err := store.Put(ctx, []Event{ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(old, "aaa")
+	put(time.Now().UTC(), "bbb")
+	put(time.Now().UTC(), "aaa")
+
+	store.maint.Wait()
+	segments := store.Segments()
+	if len(segments) != 3 {
+		t.Fatalf("have %d segments, want one per put\n", len(segments))
+	}
+	if !segments[0].Compressed || segments[2].Compressed {
+		t.Errorf("want rotated segments compressed and the active one not\n")
+	}
+
+	// reopening rotates the active segment.
+	store, err = NewJSONLStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Query(ctx, Query{SHA256: "aaa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 {
+		t.Fatalf("have %d events, want 2\n", len(page.Events))
+	}
+
+	pruned, err := store.Prune(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 || len(store.Segments()) != 2 {
+		t.Errorf("have %d events pruned and %d segments left, want 1 and 2\n", pruned, len(store.Segments()))
+	}
+
+	deleted, err := store.Delete(ctx, Query{SHA256: "bbb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || len(page.Events) != 1 {
+		t.Errorf("have %d deleted and %d left, want 1 and 1\n", deleted, len(page.Events))
+	}
+}
+
+func TestJSONLStoreBackgroundCompression(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewJSONLStore(t.TempDir(), WithMaxSegmentBytes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow compression holds the maintenance lock, and Put doesn't wait for it.
+	store.maintMtx.Lock()
+	const total = 5
+	for i := 0; i < total; i++ {
+		ev := Event{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: float64(i)}}
+		if err := store.Put(ctx, []Event{ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, seg := range store.Segments() {
+		if seg.Compressed {
+			t.Errorf("have segment %s compressed while maintenance is held\n", seg.Name)
+		}
+	}
+	page, err := store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != total {
+		t.Errorf("have %d events before compression, want %d\n", len(page.Events), total)
+	}
+
+	store.maintMtx.Unlock()
+	store.maint.Wait()
+	segments := store.Segments()
+	for _, seg := range segments[:len(segments)-1] {
+		if !seg.Compressed || seg.Events != 1 {
+			t.Errorf("have segment %s compressed %v with %d events, want compressed with 1\n", seg.Name, seg.Compressed, seg.Events)
+		}
+	}
+	page, err = store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != total {
+		t.Errorf("have %d events after compression, want %d\n", len(page.Events), total)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range store.Segments() {
+		if !seg.Compressed {
+			t.Errorf("have segment %s uncompressed after close\n", seg.Name)
+		}
+	}
+}
+
+func TestJSONLStoreQueryPages(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewJSONLStore(t.TempDir(), WithMaxSegmentBytes(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more events than the query holds while scanning, spread over segments.
+	const total = 50
+	for i := 0; i < total; i++ {
+		ev := Event{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: float64(i)}}
+		if err := store.Put(ctx, []Event{ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.Segments()) < 2 {
+		t.Fatalf("have %d segments, want the events rotated\n", len(store.Segments()))
+	}
+
+	var executed []float64
+	q := Query{Limit: 7}
+	for {
+		page, err := store.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ev := range page.Events {
+			executed = append(executed, ev.Event.ExecutionTime)
+		}
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	if len(executed) != total {
+		t.Fatalf("have %d events over the pages, want %d\n", len(executed), total)
+	}
+	for i, v := range executed {
+		if want := float64(total - 1 - i); v != want {
+			t.Fatalf("have event executed at %v at position %d, want %v\n", v, i, want)
+		}
+	}
+
+	// offsets from clients aren't cursors, however large.
+	for _, cursor := range []string{"-1", "9223372036854775807", "ten:M1"} {
+		if _, err := store.Query(ctx, Query{Cursor: cursor}); errors.Cause(err) != ErrInvalidCursor {
+			t.Errorf("have error %v for cursor %q, want %v\n", err, cursor, ErrInvalidCursor)
+		}
+	}
+}
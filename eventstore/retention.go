@@ -0,0 +1,294 @@
+package eventstore
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// decisions are the event decisions reported by Santa, which decision
+// prefixes such as BLOCK expand to.
+var decisions = []string{
+	"ALLOW_BINARY", "ALLOW_CERTIFICATE", "ALLOW_SCOPE", "ALLOW_TEAMID", "ALLOW_SIGNINGID", "ALLOW_CDHASH", "ALLOW_UNKNOWN",
+	"BLOCK_BINARY", "BLOCK_CERTIFICATE", "BLOCK_SCOPE", "BLOCK_TEAMID", "BLOCK_SIGNINGID", "BLOCK_CDHASH", "BLOCK_UNKNOWN",
+	"BUNDLE_BINARY",
+}
+
+// RetentionPolicy bounds how long events are kept, and the space they use.
+type RetentionPolicy struct {
+	// MaxAge is how long events are kept after they were executed.
+	// Events are kept forever when 0.
+	MaxAge time.Duration
+	// Decisions overrides MaxAge for events with a decision. A key is a
+	// decision, ie. BLOCK_BINARY, or a prefix, ie. BLOCK for every BLOCK_ decision.
+	// An age of 0 keeps the events forever.
+	Decisions map[string]time.Duration
+	// MaxBytes is the space the store may use. The events executed first are
+	// removed to fit. The size is unlimited when 0.
+	MaxBytes int64
+}
+
+// ParseDecisionAges parses a comma separated list of decision=age, ie. BLOCK=8760h,ALLOW=168h.
+func ParseDecisionAges(s string) (map[string]time.Duration, error) {
+	ages := make(map[string]time.Duration)
+	for _, rule := range strings.Split(s, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		decision, age, ok := strings.Cut(rule, "=")
+		decision = strings.ToUpper(strings.TrimSpace(decision))
+		if !ok || decision == "" {
+			return nil, errors.Errorf("invalid decision retention %q, want decision=age", rule)
+		}
+		if !knownDecision(decision) {
+			return nil, errors.Errorf("unknown decision %q in retention %q, want a decision such as BLOCK_BINARY or a prefix such as BLOCK", decision, rule)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(age))
+		if err != nil || d < 0 {
+			return nil, errors.Errorf("invalid retention age %q for %s", age, decision)
+		}
+		ages[decision] = d
+	}
+	return ages, nil
+}
+
+// knownDecision reports whether key is a decision, or the prefix of one.
+func knownDecision(key string) bool {
+	for _, decision := range decisions {
+		if decision == key || strings.HasPrefix(decision, key+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// retentionRule is an age of the policy, applied to the events selected by q.
+// name is the decision keys of the policy sharing the age, joined by commas,
+// and default for MaxAge.
+type retentionRule struct {
+	name string
+	age  time.Duration
+	q    Query
+}
+
+// rules expands the policy into the deletions run by the janitor, one per
+// distinct age. A decision matching several keys follows the longest, most
+// specific one.
+func (p RetentionPolicy) rules() []retentionRule {
+	keys := make([]string, 0, len(p.Decisions))
+	for key := range p.Decisions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j]) || len(keys[i]) == len(keys[j]) && keys[i] < keys[j]
+	})
+
+	type group struct {
+		names     []string
+		decisions []string
+	}
+	var (
+		covered []string
+		groups  = make(map[time.Duration]*group)
+	)
+	cover := func(key, decision string) {
+		if slices.Contains(covered, decision) {
+			return
+		}
+		covered = append(covered, decision)
+		age := p.Decisions[key]
+		if age == 0 {
+			return
+		}
+		g := groups[age]
+		if g == nil {
+			g = &group{}
+			groups[age] = g
+		}
+		if !slices.Contains(g.names, key) {
+			g.names = append(g.names, key)
+		}
+		g.decisions = append(g.decisions, decision)
+	}
+	for _, key := range keys {
+		var prefix bool
+		for _, decision := range decisions {
+			if strings.HasPrefix(decision, key+"_") {
+				prefix = true
+				cover(key, decision)
+			}
+		}
+		if !prefix {
+			cover(key, key)
+		}
+	}
+
+	var rules []retentionRule
+	for age, g := range groups {
+		if age == p.MaxAge {
+			continue
+		}
+		sort.Strings(g.names)
+		rules = append(rules, retentionRule{name: strings.Join(g.names, ","), age: age, q: Query{Decisions: g.decisions}})
+	}
+	if p.MaxAge > 0 {
+		// decisions sharing the default age are pruned with the events no key covers.
+		names, exclude := []string{"default"}, covered
+		if g := groups[p.MaxAge]; g != nil {
+			sort.Strings(g.names)
+			names = append(g.names, names...)
+			exclude = slices.DeleteFunc(slices.Clone(covered), func(decision string) bool {
+				return slices.Contains(g.decisions, decision)
+			})
+		}
+		rules = append(rules, retentionRule{name: strings.Join(names, ","), age: p.MaxAge, q: Query{ExcludeDecisions: exclude}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].age < rules[j].age })
+	return rules
+}
+
+// Deleter deletes the events selected by a query.
+type Deleter interface {
+	Delete(ctx context.Context, q Query) (int, error)
+}
+
+// SizeLimiter is implemented by stores which can remove their oldest events
+// to fit in max bytes. It returns the number of events removed, and the size
+// of the store afterwards.
+type SizeLimiter interface {
+	LimitSize(ctx context.Context, max int64) (int, int64, error)
+}
+
+// RetentionStats describes what a Janitor pruned since the process started.
+type RetentionStats struct {
+	Store     string    `json:"store"`
+	Runs      int       `json:"runs"`
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+
+	// Pruned is the number of events removed. PrunedBy counts them by the rule
+	// which removed them: the decision keys of the policy sharing an age,
+	// joined by commas, "default" for MaxAge and "size" for MaxBytes.
+	Pruned   int            `json:"pruned"`
+	PrunedBy map[string]int `json:"pruned_by"`
+	// Bytes is the size of the store after the last run, when MaxBytes is set.
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// Janitor enforces a RetentionPolicy on a store, from its Run method.
+type Janitor struct {
+	store    Deleter
+	policy   RetentionPolicy
+	interval time.Duration
+	logger   log.Logger
+
+	mtx   sync.Mutex
+	stats RetentionStats
+}
+
+// NewJanitor creates a Janitor which prunes the store named name every interval.
+// A policy with MaxBytes requires a store which implements SizeLimiter.
+func NewJanitor(name string, store Deleter, policy RetentionPolicy, interval time.Duration, logger log.Logger) (*Janitor, error) {
+	if _, ok := store.(SizeLimiter); policy.MaxBytes > 0 && !ok {
+		return nil, errors.Errorf("the %s event store doesn't support a maximum size", name)
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Janitor{
+		store:    store,
+		policy:   policy,
+		interval: interval,
+		logger:   log.With(logger, "component", "event-janitor", "store", name),
+		stats:    RetentionStats{Store: name, PrunedBy: make(map[string]int)},
+	}, nil
+}
+
+// Run prunes the store when it starts, then every interval until ctx is cancelled.
+func (j *Janitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.Prune(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			level.Info(j.logger).Log("msg", "prune events", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Prune removes the events which are past their age at now, then the oldest
+// events if the store is larger than MaxBytes.
+func (j *Janitor) Prune(ctx context.Context, now time.Time) error {
+	pruned := make(map[string]int)
+	var size int64
+	err := func() error {
+		for _, rule := range j.policy.rules() {
+			q := rule.q
+			q.Until = now.Add(-rule.age)
+			n, err := j.store.Delete(ctx, q)
+			pruned[rule.name] += n
+			if err != nil {
+				return errors.Wrapf(err, "prune %s events", rule.name)
+			}
+		}
+		if j.policy.MaxBytes > 0 {
+			n, bytes, err := j.store.(SizeLimiter).LimitSize(ctx, j.policy.MaxBytes)
+			pruned["size"] += n
+			size = bytes
+			if err != nil {
+				return errors.Wrap(err, "limit event store size")
+			}
+		}
+		return nil
+	}()
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.stats.Runs++
+	j.stats.LastRun = now
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.LastError = err.Error()
+	}
+	if j.policy.MaxBytes > 0 {
+		j.stats.Bytes = size
+	}
+	var total int
+	for name, n := range pruned {
+		if n > 0 {
+			j.stats.PrunedBy[name] += n
+			total += n
+		}
+	}
+	j.stats.Pruned += total
+	if total > 0 {
+		level.Info(j.logger).Log("msg", "pruned events", "count", total)
+	}
+	return err
+}
+
+// Stats returns what the janitor pruned so far.
+func (j *Janitor) Stats() RetentionStats {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	stats := j.stats
+	stats.PrunedBy = make(map[string]int, len(j.stats.PrunedBy))
+	for name, n := range j.stats.PrunedBy {
+		stats.PrunedBy[name] = n
+	}
+	return stats
+}
@@ -0,0 +1,155 @@
+package eventstore
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestJanitor(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) float64 { return float64(now.AddDate(0, 0, -days).Unix()) }
+	events := []Event{
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: daysAgo(400), Decision: "BLOCK_BINARY"}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: daysAgo(200), Decision: "BLOCK_BINARY"}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "bbb", ExecutionTime: daysAgo(10), Decision: "ALLOW_BINARY"}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "bbb", ExecutionTime: daysAgo(3), Decision: "ALLOW_CERTIFICATE"}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "ccc", ExecutionTime: daysAgo(60), Decision: "BUNDLE_BINARY"}},
+		{MachineID: "M1", Event: santa.EventUploadEvent{FileSHA256: "ccc", ExecutionTime: daysAgo(20), Decision: "BUNDLE_BINARY"}},
+	}
+	if err := store.Put(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	ages, err := ParseDecisionAges("BLOCK=8760h, allow=168h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RetentionPolicy{MaxAge: 30 * 24 * time.Hour, Decisions: ages}
+	janitor, err := NewJanitor("file", store, policy, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := janitor.Prune(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []float64
+	for _, ev := range page.Events {
+		kept = append(kept, ev.Event.ExecutionTime)
+	}
+	want := []float64{daysAgo(3), daysAgo(20), daysAgo(200)}
+	if len(kept) != len(want) {
+		t.Fatalf("have %d events kept, want %d\n", len(kept), len(want))
+	}
+	for i := range want {
+		if kept[i] != want[i] {
+			t.Errorf("have event executed at %v kept, want %v\n", kept[i], want[i])
+		}
+	}
+
+	stats := janitor.Stats()
+	if stats.Pruned != 3 || stats.PrunedBy["BLOCK"] != 1 || stats.PrunedBy["ALLOW"] != 1 || stats.PrunedBy["default"] != 1 {
+		t.Errorf("have stats %+v\n", stats)
+	}
+
+	// the size limit removes the events executed first.
+	policy = RetentionPolicy{MaxBytes: 1}
+	janitor, err = NewJanitor("file", store, policy, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := janitor.Prune(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if stats := janitor.Stats(); stats.PrunedBy["size"] != 3 || stats.Bytes != 0 {
+		t.Errorf("have stats %+v, want every event removed\n", stats)
+	}
+
+	if _, err := NewJanitor("sql", deleterFunc(nil), policy, time.Hour, log.NewNopLogger()); err == nil {
+		t.Errorf("want error for a size limit on a store which can't enforce it\n")
+	}
+}
+
+func TestJanitorDeletesPerAge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var queries []Query
+	store := deleterFunc(func(ctx context.Context, q Query) (int, error) {
+		queries = append(queries, q)
+		return 1, nil
+	})
+
+	ages, err := ParseDecisionAges("BLOCK=8760h,BLOCK_UNKNOWN=0s,ALLOW_BINARY=168h,ALLOW_CERTIFICATE=168h,BUNDLE=720h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RetentionPolicy{MaxAge: 720 * time.Hour, Decisions: ages}
+	janitor, err := NewJanitor("sql", store, policy, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := janitor.Prune(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// one delete for each of 168h, 720h and 8760h.
+	if len(queries) != 3 {
+		t.Fatalf("have %d deletes, want one per distinct age\n", len(queries))
+	}
+	week, month, year := queries[0], queries[1], queries[2]
+	if !week.Until.Equal(now.Add(-168*time.Hour)) || !sameDecisions(week.Decisions, "ALLOW_BINARY", "ALLOW_CERTIFICATE") {
+		t.Errorf("have week delete %+v\n", week)
+	}
+	// BUNDLE shares the default age, so it isn't excluded from it.
+	if len(month.Decisions) != 0 || !sameDecisions(month.ExcludeDecisions, "ALLOW_BINARY", "ALLOW_CERTIFICATE",
+		"BLOCK_BINARY", "BLOCK_CERTIFICATE", "BLOCK_SCOPE", "BLOCK_TEAMID", "BLOCK_SIGNINGID", "BLOCK_CDHASH", "BLOCK_UNKNOWN") {
+		t.Errorf("have default delete %+v\n", month)
+	}
+	if slices.Contains(year.Decisions, "BLOCK_UNKNOWN") || len(year.Decisions) != 6 {
+		t.Errorf("have year delete %+v, want the BLOCK decisions but the one kept forever\n", year)
+	}
+
+	stats := janitor.Stats()
+	if stats.PrunedBy["ALLOW_BINARY,ALLOW_CERTIFICATE"] != 1 || stats.PrunedBy["BUNDLE,default"] != 1 || stats.PrunedBy["BLOCK"] != 1 {
+		t.Errorf("have stats %+v\n", stats)
+	}
+}
+
+func sameDecisions(have []string, want ...string) bool {
+	return len(have) == len(want) && !slices.ContainsFunc(want, func(d string) bool { return !slices.Contains(have, d) })
+}
+
+type deleterFunc func(ctx context.Context, q Query) (int, error)
+
+func (f deleterFunc) Delete(ctx context.Context, q Query) (int, error) { return f(ctx, q) }
+
+func TestParseDecisionAges(t *testing.T) {
+	ages, err := ParseDecisionAges(" block_binary=1h,ALLOW=2h,BUNDLE_BINARY=0s ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ages) != 3 || ages["BLOCK_BINARY"] != time.Hour || ages["ALLOW"] != 2*time.Hour || ages["BUNDLE_BINARY"] != 0 {
+		t.Errorf("have ages %v\n", ages)
+	}
+	for _, s := range []string{"BLCOK=1h", "BLOCK_BIN=1h", "BLOCK", "=1h", "BLOCK=forever", "BLOCK=-1h"} {
+		if _, err := ParseDecisionAges(s); err == nil {
+			t.Errorf("%q parsed without an error\n", s)
+		}
+	}
+}
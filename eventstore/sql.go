@@ -0,0 +1,304 @@
+package eventstore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// SQLStore keeps events in a SQLite database, indexed for queries.
+type SQLStore struct {
+	db *sql.DB
+}
+
+const eventsSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	event_id       TEXT PRIMARY KEY,
+	machine_id     TEXT NOT NULL,
+	received_at    INTEGER NOT NULL,
+	execution_time REAL NOT NULL,
+	file_sha256    TEXT NOT NULL DEFAULT '',
+	decision       TEXT NOT NULL DEFAULT '',
+	team_id        TEXT NOT NULL DEFAULT '',
+	signing_id     TEXT NOT NULL DEFAULT '',
+	executing_user TEXT NOT NULL DEFAULT '',
+	data           TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_file_sha256 ON events (file_sha256, execution_time);
+CREATE INDEX IF NOT EXISTS events_team_id ON events (team_id, execution_time);
+CREATE INDEX IF NOT EXISTS events_signing_id ON events (signing_id, execution_time);
+CREATE INDEX IF NOT EXISTS events_decision ON events (decision, execution_time);
+CREATE INDEX IF NOT EXISTS events_machine_id ON events (machine_id, execution_time);
+CREATE INDEX IF NOT EXISTS events_executing_user ON events (executing_user, execution_time);
+CREATE INDEX IF NOT EXISTS events_execution_time ON events (execution_time);
+CREATE TABLE IF NOT EXISTS event_signing_chain (
+	event_id    TEXT NOT NULL,
+	position    INTEGER NOT NULL,
+	sha256      TEXT NOT NULL,
+	cn          TEXT NOT NULL DEFAULT '',
+	org         TEXT NOT NULL DEFAULT '',
+	ou          TEXT NOT NULL DEFAULT '',
+	valid_from  INTEGER NOT NULL DEFAULT 0,
+	valid_until INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (event_id, position)
+);
+CREATE INDEX IF NOT EXISTS event_signing_chain_sha256 ON event_signing_chain (sha256);
+CREATE TABLE IF NOT EXISTS event_machines (
+	machine_id      TEXT PRIMARY KEY,
+	first_received  INTEGER NOT NULL,
+	last_received   INTEGER NOT NULL,
+	events_received INTEGER NOT NULL
+);
+`
+
+// NewSQLStore creates a SQLStore, creating the events, event_signing_chain
+// and event_machines tables if they don't exist.
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	if err := storage.CreateTables(ctx, db, eventsSchema); err != nil {
+		return nil, errors.Wrap(err, "create events tables")
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Put(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	received := make(map[string][]time.Time)
+	for _, ev := range events {
+		id, err := newEventID()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(ev.Event)
+		if err != nil {
+			return errors.Wrap(err, "marshal event to json")
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO events
+			(event_id, machine_id, received_at, execution_time, file_sha256, decision, team_id, signing_id, executing_user, data)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, ev.MachineID, ev.ReceivedAt.UnixNano(), ev.Event.ExecutionTime, ev.Event.FileSHA256,
+			ev.Event.Decision, ev.Event.TeamID, ev.Event.SigningID, ev.Event.ExecutingUser, string(data),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "insert event from machine %s", ev.MachineID)
+		}
+		for i, cert := range ev.Event.SigningChain {
+			_, err := tx.ExecContext(ctx, `INSERT INTO event_signing_chain
+				(event_id, position, sha256, cn, org, ou, valid_from, valid_until)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				id, i, cert.SHA256, cert.CertificateName, cert.Organization, cert.OrganizationalUnit,
+				cert.ValidFrom, cert.ValidUntil,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "insert signing chain of event %s", id)
+			}
+		}
+		received[ev.MachineID] = append(received[ev.MachineID], ev.ReceivedAt)
+	}
+
+	for machineID, times := range received {
+		first, last := times[0], times[0]
+		for _, t := range times {
+			if t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE event_machines SET
+			last_received = ?, events_received = events_received + ?
+			WHERE machine_id = ?`, last.UnixNano(), len(times), machineID)
+		if err != nil {
+			return errors.Wrapf(err, "update event machine %s", machineID)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			_, err = tx.ExecContext(ctx, `INSERT INTO event_machines
+				(machine_id, first_received, last_received, events_received)
+				VALUES (?, ?, ?, ?)`, machineID, first.UnixNano(), last.UnixNano(), len(times))
+			if err != nil {
+				return errors.Wrapf(err, "insert event machine %s", machineID)
+			}
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit events")
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate event ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// where returns the SQL conditions and arguments selecting the events of q.
+func (q Query) where() (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	eq := func(column, value string) {
+		if value != "" {
+			conds = append(conds, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	eq("machine_id", q.MachineID)
+	eq("file_sha256", q.SHA256)
+	eq("decision", q.Decision)
+	eq("team_id", q.TeamID)
+	eq("signing_id", q.SigningID)
+	eq("executing_user", q.ExecutingUser)
+	if q.CertSHA256 != "" {
+		conds = append(conds, "event_id IN (SELECT event_id FROM event_signing_chain WHERE sha256 = ?)")
+		args = append(args, q.CertSHA256)
+	}
+	if len(q.Decisions) > 0 {
+		conds = append(conds, "decision IN (?"+strings.Repeat(", ?", len(q.Decisions)-1)+")")
+		for _, decision := range q.Decisions {
+			args = append(args, decision)
+		}
+	}
+	if len(q.ExcludeDecisions) > 0 {
+		conds = append(conds, "decision NOT IN (?"+strings.Repeat(", ?", len(q.ExcludeDecisions)-1)+")")
+		for _, decision := range q.ExcludeDecisions {
+			args = append(args, decision)
+		}
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, "execution_time >= ?")
+		args = append(args, unixSeconds(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, "execution_time < ?")
+		args = append(args, unixSeconds(q.Until))
+	}
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// Query pages through the events with a cursor on the execution time and ID
+// of the last event returned.
+func (s *SQLStore) Query(ctx context.Context, q Query) (Page, error) {
+	where, args := q.where()
+	if q.Cursor != "" {
+		after, err := parseCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		where += " AND (execution_time < ? OR (execution_time = ? AND event_id > ?))"
+		args = append(args, after.executionTime, after.executionTime, after.id)
+	}
+	limit := q.PageLimit()
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT event_id, machine_id, received_at, data FROM events
+		WHERE `+where+` ORDER BY execution_time DESC, event_id ASC LIMIT ?`, args...)
+	if err != nil {
+		return Page{}, errors.Wrap(err, "select events")
+	}
+	defer rows.Close()
+
+	page := Page{Events: []Event{}}
+	for rows.Next() {
+		var (
+			ev         Event
+			receivedAt int64
+			data       string
+		)
+		if err := rows.Scan(&ev.ID, &ev.MachineID, &receivedAt, &data); err != nil {
+			return Page{}, errors.Wrap(err, "scan event row")
+		}
+		if err := json.Unmarshal([]byte(data), &ev.Event); err != nil {
+			return Page{}, errors.Wrapf(err, "decode event %s", ev.ID)
+		}
+		ev.ReceivedAt = time.Unix(0, receivedAt).UTC()
+		page.Events = append(page.Events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, errors.Wrap(err, "iterate event rows")
+	}
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		last := page.Events[limit-1]
+		page.Next = newCursor(last).String()
+	}
+	return page, nil
+}
+
+func (s *SQLStore) Delete(ctx context.Context, q Query) (int, error) {
+	where, args := q.where()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM event_signing_chain
+		WHERE event_id IN (SELECT event_id FROM events WHERE `+where+`)`, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete signing chain entries")
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM events WHERE `+where, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete events")
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "count deleted events")
+	}
+	return int(deleted), errors.Wrap(tx.Commit(), "commit event deletion")
+}
+
+// MachineEvents is the number of events received from a machine.
+type MachineEvents struct {
+	MachineID      string    `json:"machine_id"`
+	FirstReceived  time.Time `json:"first_received"`
+	LastReceived   time.Time `json:"last_received"`
+	EventsReceived int       `json:"events_received"`
+}
+
+// Machines returns the machines which uploaded events, including deleted ones.
+func (s *SQLStore) Machines(ctx context.Context) ([]MachineEvents, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT machine_id, first_received, last_received, events_received
+		FROM event_machines ORDER BY machine_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "select event machines")
+	}
+	defer rows.Close()
+
+	var machines []MachineEvents
+	for rows.Next() {
+		var (
+			m           MachineEvents
+			first, last int64
+		)
+		if err := rows.Scan(&m.MachineID, &first, &last, &m.EventsReceived); err != nil {
+			return nil, errors.Wrap(err, "scan event machine row")
+		}
+		m.FirstReceived, m.LastReceived = time.Unix(0, first).UTC(), time.Unix(0, last).UTC()
+		machines = append(machines, m)
+	}
+	return machines, errors.Wrap(rows.Err(), "iterate event machine rows")
+}
@@ -0,0 +1,158 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/groob/moroz/santa"
+)
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewSQLStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	chain := []santa.SigningEntry{{SHA256: "cert", CertificateName: "Developer ID"}}
+	var events []Event
+	for i := 0; i < 5; i++ {
+		events = append(events, Event{
+			MachineID:  "M1",
+			ReceivedAt: now,
+			Event: santa.EventUploadEvent{
+				FileSHA256:    "aaa",
+				ExecutionTime: float64(1000 + i),
+				Decision:      "BLOCK_UNKNOWN",
+				SigningChain:  chain,
+			},
+		})
+	}
+	events = append(events, Event{MachineID: "M2", ReceivedAt: now, Event: santa.EventUploadEvent{FileSHA256: "bbb", ExecutionTime: 2000, Decision: "ALLOW_BINARY"}})
+	if err := store.Put(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []Event
+	q := Query{CertSHA256: "cert", Limit: 2}
+	for {
+		page, err := store.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, page.Events...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	if len(seen) != 5 {
+		t.Fatalf("have %d events through pages, want 5\n", len(seen))
+	}
+	if seen[0].Event.ExecutionTime != 1004 || seen[4].Event.ExecutionTime != 1000 {
+		t.Errorf("want events newest first, have %v first\n", seen[0].Event.ExecutionTime)
+	}
+	if !seen[0].ReceivedAt.Equal(now) {
+		t.Errorf("have received at %s, want %s\n", seen[0].ReceivedAt, now)
+	}
+
+	deleted, err := store.Delete(ctx, Query{Decision: "BLOCK_UNKNOWN", Until: time.Unix(1002, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("have %d events deleted, want 2\n", deleted)
+	}
+	page, err := store.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 4 {
+		t.Errorf("have %d events left, want 4\n", len(page.Events))
+	}
+	page, err = store.Query(ctx, Query{ExcludeDecisions: []string{"BLOCK_UNKNOWN", "BLOCK_BINARY"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].MachineID != "M2" {
+		t.Errorf("have %d events without a block decision, want the allow on M2\n", len(page.Events))
+	}
+	page, err = store.Query(ctx, Query{Decisions: []string{"ALLOW_BINARY", "BLOCK_BINARY"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].MachineID != "M2" {
+		t.Errorf("have %d events with an allow or block binary decision, want the allow on M2\n", len(page.Events))
+	}
+
+	machines, err := store.Machines(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 2 || machines[0].EventsReceived != 5 {
+		t.Errorf("have machines %+v, want M1 with 5 events and M2\n", machines)
+	}
+}
+
+func TestSQLStoreConcurrentDelete(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", storage.SQLiteDSN(filepath.Join(t.TempDir(), "events.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewSQLStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// uploads overlap with a janitor deleting old events.
+	old := time.Now().Add(-48 * time.Hour).UTC()
+	const uploads = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			events := []Event{
+				{MachineID: "M1", ReceivedAt: old, Event: santa.EventUploadEvent{FileSHA256: "old", ExecutionTime: float64(i)}},
+				{MachineID: "M1", ReceivedAt: time.Now().UTC(), Event: santa.EventUploadEvent{FileSHA256: "new", ExecutionTime: float64(i)}},
+			}
+			errs <- store.Put(ctx, events)
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := store.Delete(ctx, Query{SHA256: "old"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := store.Query(ctx, Query{SHA256: "new", Limit: 2 * uploads})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != uploads {
+		t.Errorf("have %d uploaded events, want %d\n", len(page.Events), uploads)
+	}
+}
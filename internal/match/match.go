@@ -0,0 +1,23 @@
+// Package match holds the filters shared by event sinks and alert rules,
+// where an empty filter matches every event.
+package match
+
+import "slices"
+
+// Value reports whether filter is empty or holds v.
+func Value(filter []string, v string) bool {
+	return len(filter) == 0 || slices.Contains(filter, v)
+}
+
+// Any reports whether filter is empty or holds one of values.
+func Any(filter, values []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if slices.Contains(filter, v) {
+			return true
+		}
+	}
+	return false
+}
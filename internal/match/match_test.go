@@ -0,0 +1,42 @@
+package match
+
+import "testing"
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter []string
+		v      string
+		want   bool
+	}{
+		{name: "empty filter", filter: nil, v: "BLOCK_BINARY", want: true},
+		{name: "empty filter and value", filter: nil, v: "", want: true},
+		{name: "held", filter: []string{"ALLOW_BINARY", "BLOCK_BINARY"}, v: "BLOCK_BINARY", want: true},
+		{name: "not held", filter: []string{"ALLOW_BINARY"}, v: "BLOCK_BINARY", want: false},
+		{name: "empty value", filter: []string{"ALLOW_BINARY"}, v: "", want: false},
+	}
+	for _, tt := range tests {
+		if have := Value(tt.filter, tt.v); have != tt.want {
+			t.Errorf("%s: have %v, want %v\n", tt.name, have, tt.want)
+		}
+	}
+}
+
+func TestAny(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter []string
+		values []string
+		want   bool
+	}{
+		{name: "empty filter", filter: nil, values: nil, want: true},
+		{name: "shared value", filter: []string{"eng", "ops"}, values: []string{"sales", "ops"}, want: true},
+		{name: "no shared value", filter: []string{"eng"}, values: []string{"sales"}, want: false},
+		{name: "no values", filter: []string{"eng"}, values: nil, want: false},
+	}
+	for _, tt := range tests {
+		if have := Any(tt.filter, tt.values); have != tt.want {
+			t.Errorf("%s: have %v, want %v\n", tt.name, have, tt.want)
+		}
+	}
+}
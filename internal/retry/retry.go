@@ -0,0 +1,33 @@
+// Package retry holds the waits between the delivery attempts of event
+// sinks and alert notifiers.
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff returns how long to wait before retrying a delivery which failed
+// attempt times in a row, doubling from one second up to five minutes.
+func Backoff(attempt int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempt && d < 5*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 5*time.Minute {
+		d = 5 * time.Minute
+	}
+	return d
+}
+
+// Sleep waits for d, and reports whether ctx was cancelled first.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return true
+	}
+}
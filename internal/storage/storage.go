@@ -0,0 +1,92 @@
+// Package storage holds the helpers shared by the file and SQL stores of
+// machines, events and the catalog.
+//
+// The SQL schemas and queries are written for SQLite. Queries use `?`
+// placeholders.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFileAtomic writes data to a temporary file in the directory of path,
+// and renames it to path, so a crash never leaves a truncated file.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write temporary file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write temporary file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "rename temporary file")
+}
+
+// CreateTables runs the statements of schema, separated by semicolons, one
+// at a time, as not every driver accepts several statements in one Exec.
+func CreateTables(ctx context.Context, db *sql.DB, schema string) error {
+	for _, stmt := range strings.Split(schema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upsert runs update, and insert when update didn't affect a row, in a
+// transaction. Both statements take the same arguments.
+func Upsert(ctx context.Context, db *sql.DB, update, insert string, args ...interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, update, args...)
+	if err != nil {
+		return errors.Wrap(err, "update")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+			return errors.Wrap(err, "insert")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// SQLiteDSN adds the parameters the SQL stores need to share a SQLite
+// database to dsn, unless it sets them already: connections wait up to 5
+// seconds for a lock instead of failing with SQLITE_BUSY, transactions take
+// the write lock when they begin, so they never fail to upgrade a read lock,
+// and the WAL journal lets readers run alongside a writer.
+func SQLiteDSN(dsn string) string {
+	for _, param := range []struct{ name, value string }{
+		{"busy_timeout", "_pragma=busy_timeout(5000)"},
+		{"journal_mode", "_pragma=journal_mode(WAL)"},
+		{"_txlock", "_txlock=immediate"},
+	} {
+		if strings.Contains(dsn, param.name) {
+			continue
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + param.value
+		} else {
+			dsn += "?" + param.value
+		}
+	}
+	return dsn
+}
@@ -0,0 +1,155 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// FileStore keeps one JSON file per machine in a directory.
+// Sync sessions are kept per machine in the sessions subdirectory.
+type FileStore struct {
+	mtx sync.RWMutex
+	dir string
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating the directory if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "sessions"), 0750); err != nil {
+		return nil, errors.Wrapf(err, "create machine directory %s", dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(machineID string) string {
+	return filepath.Join(f.dir, machineID+".json")
+}
+
+func (f *FileStore) Machine(ctx context.Context, machineID string) (Machine, error) {
+	var m Machine
+	if err := validMachineID(machineID); err != nil {
+		return m, err
+	}
+
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	data, err := os.ReadFile(f.path(machineID))
+	if os.IsNotExist(err) {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, errors.Wrapf(err, "read machine %s", machineID)
+	}
+	err = json.Unmarshal(data, &m)
+	return m, errors.Wrapf(err, "decode machine %s", machineID)
+}
+
+func (f *FileStore) AllMachines(ctx context.Context) ([]Machine, error) {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list machine directory %s", f.dir)
+	}
+	var machines []Machine
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read machine file %s", entry.Name())
+		}
+		var m Machine
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, errors.Wrapf(err, "decode machine file %s", entry.Name())
+		}
+		machines = append(machines, m)
+	}
+	return machines, nil
+}
+
+func (f *FileStore) SaveMachine(ctx context.Context, m Machine) error {
+	if err := validMachineID(m.MachineID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal machine to json")
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return errors.Wrapf(storage.WriteFileAtomic(f.path(m.MachineID), append(data, '\n')), "save machine %s", m.MachineID)
+}
+
+func (f *FileStore) sessionsPath(machineID string) string {
+	return filepath.Join(f.dir, "sessions", machineID+".json")
+}
+
+// Sessions returns the sync sessions of a machine, newest first.
+func (f *FileStore) Sessions(ctx context.Context, machineID string) ([]Session, error) {
+	if err := validMachineID(machineID); err != nil {
+		return nil, err
+	}
+
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return f.readSessions(machineID)
+}
+
+func (f *FileStore) readSessions(machineID string) ([]Session, error) {
+	data, err := os.ReadFile(f.sessionsPath(machineID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read sessions of machine %s", machineID)
+	}
+	var sessions []Session
+	err = json.Unmarshal(data, &sessions)
+	return sessions, errors.Wrapf(err, "decode sessions of machine %s", machineID)
+}
+
+// SaveSession creates or updates a sync session.
+// Only the newest sessions of each machine are kept.
+func (f *FileStore) SaveSession(ctx context.Context, s Session) error {
+	if err := validMachineID(s.MachineID); err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	sessions, err := f.readSessions(s.MachineID)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range sessions {
+		if sessions[i].ID == s.ID {
+			sessions[i] = s
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		sessions = append([]Session{s}, sessions...)
+	}
+	if len(sessions) > maxSessions {
+		sessions = sessions[:maxSessions]
+	}
+
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal sessions to json")
+	}
+	return errors.Wrapf(storage.WriteFileAtomic(f.sessionsPath(s.MachineID), append(data, '\n')), "save session %s", s.ID)
+}
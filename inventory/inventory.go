@@ -0,0 +1,85 @@
+// Package inventory records the machines syncing with moroz.
+package inventory
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/santa"
+)
+
+// ErrNotFound is returned by stores when a machine has never synced.
+var ErrNotFound = errors.New("machine not found")
+
+// Machine is the inventory record for a single Santa client.
+type Machine struct {
+	MachineID string    `json:"machine_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Preflight is the last preflight request sent by the machine.
+	Preflight santa.PreflightPayload `json:"preflight"`
+
+	// PushToken is the last push notification token the machine reported.
+	// It is kept when later preflight requests omit the token.
+	PushToken string `json:"push_token,omitempty"`
+
+	// Enrolled is set once the machine presented valid enrollment credentials.
+	Enrolled   bool      `json:"enrolled,omitempty"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+
+	// SyncStatus is the outcome of the last postflight verification.
+	SyncStatus         SyncStatus `json:"sync_status,omitempty"`
+	LastSuccessfulSync time.Time  `json:"last_successful_sync"`
+
+	// CleanSyncScheduled asks the machine for a clean sync in its next preflight.
+	CleanSyncScheduled bool `json:"clean_sync_scheduled,omitempty"`
+}
+
+// SyncStatus reports whether a machine applied all the rules it was sent.
+type SyncStatus string
+
+const (
+	SyncHealthy SyncStatus = "healthy"
+	SyncFailed  SyncStatus = "failed"
+)
+
+// Update records a preflight request received at time now.
+func (m *Machine) Update(p santa.PreflightPayload, now time.Time) {
+	if m.FirstSeen.IsZero() {
+		m.FirstSeen = now
+	}
+	m.LastSeen = now
+	m.Preflight = p
+	if p.PushNotificationToken != "" {
+		m.PushToken = p.PushNotificationToken
+	}
+}
+
+// RecordSync records the outcome of a sync verified at postflight time now.
+// A failed sync schedules a clean sync, until a sync succeeds.
+func (m *Machine) RecordSync(healthy bool, now time.Time) {
+	if !healthy {
+		m.SyncStatus = SyncFailed
+		m.CleanSyncScheduled = true
+		return
+	}
+	m.SyncStatus = SyncHealthy
+	m.LastSuccessfulSync = now
+	m.CleanSyncScheduled = false
+}
+
+// IsNotFound reports whether err means the machine is not in the inventory.
+func IsNotFound(err error) bool {
+	return errors.Cause(err) == ErrNotFound
+}
+
+// validMachineID rejects IDs which can't safely be used as a file name.
+func validMachineID(machineID string) error {
+	if machineID == "" || machineID == "." || machineID == ".." || filepath.Base(machineID) != machineID {
+		return errors.Errorf("invalid machine ID %q", machineID)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/groob/moroz/santa"
+)
+
+type store interface {
+	Machine(ctx context.Context, machineID string) (Machine, error)
+	AllMachines(ctx context.Context) ([]Machine, error)
+	SaveMachine(ctx context.Context, m Machine) error
+	Sessions(ctx context.Context, machineID string) ([]Session, error)
+	SaveSession(ctx context.Context, s Session) error
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "inventory.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewSQLStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func testStore(t *testing.T, s store) {
+	ctx := context.Background()
+	if _, err := s.Machine(ctx, "M1"); !IsNotFound(err) {
+		t.Fatalf("have error %v for a new machine, want not found\n", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	m := Machine{MachineID: "M1"}
+	m.Update(santa.PreflightPayload{Hostname: "mac-1", SantaVersion: "2024.9", PushNotificationToken: "token"}, now)
+	if err := s.SaveMachine(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	// a later preflight without a push token keeps the saved token.
+	m.Update(santa.PreflightPayload{Hostname: "mac-1", SantaVersion: "2025.1"}, now.Add(time.Hour))
+	if err := s.SaveMachine(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveMachine(ctx, Machine{MachineID: "M2", FirstSeen: now, LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := s.Machine(ctx, "M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.Preflight.SantaVersion != "2025.1" || have.PushToken != "token" || !have.FirstSeen.Equal(now) || !have.LastSeen.Equal(now.Add(time.Hour)) {
+		t.Errorf("have machine %+v, want the second preflight of M1\n", have)
+	}
+	all, err := s.AllMachines(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("have %d machines, want 2\n", len(all))
+	}
+
+	for i, status := range []SessionStatus{SessionComplete, SessionInProgress} {
+		sess := Session{ID: string(rune('a' + i)), MachineID: "M1", Status: status, StartedAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := s.SaveSession(ctx, sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSession(ctx, Session{ID: "b", MachineID: "M1", Status: SessionErrored, StartedAt: now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := s.Sessions(ctx, "M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "b" || sessions[0].Status != SessionErrored {
+		t.Errorf("have sessions %+v, want the updated session b first\n", sessions)
+	}
+}
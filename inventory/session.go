@@ -0,0 +1,47 @@
+package inventory
+
+import (
+	"time"
+)
+
+// SessionStatus is the outcome of a sync session.
+type SessionStatus string
+
+const (
+	SessionInProgress SessionStatus = "in_progress"
+	SessionComplete   SessionStatus = "complete"
+	SessionAbandoned  SessionStatus = "abandoned"
+	SessionErrored    SessionStatus = "errored"
+)
+
+// maxSessions is the number of sync sessions the FileStore keeps for each machine.
+const maxSessions = 100
+
+// Session correlates the preflight, rule download, event upload and postflight
+// requests of a single sync by a machine.
+type Session struct {
+	ID        string        `json:"id"`
+	MachineID string        `json:"machine_id"`
+	Status    SessionStatus `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	EndedAt   time.Time     `json:"ended_at"`
+
+	RulesSent      int `json:"rules_sent"`
+	PagesServed    int `json:"pages_served"`
+	EventsReceived int `json:"events_received"`
+
+	// EventsDuplicate counts the received events dropped as duplicates.
+	EventsDuplicate int `json:"events_duplicate"`
+
+	// RulesReceived and RulesProcessed are reported by the client in postflight.
+	RulesReceived  int `json:"rules_received"`
+	RulesProcessed int `json:"rules_processed"`
+}
+
+// Expire marks the session abandoned if it is still in progress after timeout.
+func (s *Session) Expire(now time.Time, timeout time.Duration) {
+	if s.Status == SessionInProgress && now.Sub(s.StartedAt) > timeout {
+		s.Status = SessionAbandoned
+	}
+}
@@ -0,0 +1,150 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/groob/moroz/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// SQLStore keeps machines in a SQLite database.
+type SQLStore struct {
+	db *sql.DB
+}
+
+const machinesSchema = `
+CREATE TABLE IF NOT EXISTS machines (
+	machine_id    TEXT PRIMARY KEY,
+	hostname      TEXT NOT NULL DEFAULT '',
+	serial_number TEXT NOT NULL DEFAULT '',
+	santa_version TEXT NOT NULL DEFAULT '',
+	first_seen    INTEGER NOT NULL,
+	last_seen     INTEGER NOT NULL,
+	data          TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS machines_hostname ON machines (hostname);
+CREATE INDEX IF NOT EXISTS machines_serial_number ON machines (serial_number);
+CREATE TABLE IF NOT EXISTS sync_sessions (
+	session_id TEXT PRIMARY KEY,
+	machine_id TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	started_at INTEGER NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sync_sessions_machine_id ON sync_sessions (machine_id, started_at);
+`
+
+// NewSQLStore creates a SQLStore, creating the machines and sync_sessions tables if they don't exist.
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	if err := storage.CreateTables(ctx, db, machinesSchema); err != nil {
+		return nil, errors.Wrap(err, "create machines tables")
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Machine(ctx context.Context, machineID string) (Machine, error) {
+	var (
+		m    Machine
+		data string
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM machines WHERE machine_id = ?`, machineID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, errors.Wrapf(err, "select machine %s", machineID)
+	}
+	err = json.Unmarshal([]byte(data), &m)
+	return m, errors.Wrapf(err, "decode machine %s", machineID)
+}
+
+func (s *SQLStore) AllMachines(ctx context.Context) ([]Machine, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM machines ORDER BY machine_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "select machines")
+	}
+	defer rows.Close()
+
+	var machines []Machine
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "scan machine row")
+		}
+		var m Machine
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, errors.Wrap(err, "decode machine row")
+		}
+		machines = append(machines, m)
+	}
+	return machines, errors.Wrap(rows.Err(), "iterate machine rows")
+}
+
+func (s *SQLStore) SaveMachine(ctx context.Context, m Machine) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "marshal machine to json")
+	}
+
+	err = storage.Upsert(ctx, s.db, `UPDATE machines SET
+		hostname = ?, serial_number = ?, santa_version = ?, first_seen = ?, last_seen = ?, data = ?
+		WHERE machine_id = ?`, `INSERT INTO machines
+		(hostname, serial_number, santa_version, first_seen, last_seen, data, machine_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		m.Preflight.Hostname,
+		m.Preflight.SerialNumber,
+		m.Preflight.SantaVersion,
+		m.FirstSeen.Unix(),
+		m.LastSeen.Unix(),
+		string(data),
+		m.MachineID,
+	)
+	return errors.Wrapf(err, "save machine %s", m.MachineID)
+}
+
+// Sessions returns the sync sessions of a machine, newest first.
+func (s *SQLStore) Sessions(ctx context.Context, machineID string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT data FROM sync_sessions WHERE machine_id = ? ORDER BY started_at DESC`, machineID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "select sessions of machine %s", machineID)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "scan session row")
+		}
+		var sess Session
+		if err := json.Unmarshal([]byte(data), &sess); err != nil {
+			return nil, errors.Wrap(err, "decode session row")
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, errors.Wrap(rows.Err(), "iterate session rows")
+}
+
+// SaveSession creates or updates a sync session.
+func (s *SQLStore) SaveSession(ctx context.Context, sess Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return errors.Wrap(err, "marshal session to json")
+	}
+
+	err = storage.Upsert(ctx, s.db, `UPDATE sync_sessions SET
+		machine_id = ?, status = ?, started_at = ?, data = ?
+		WHERE session_id = ?`, `INSERT INTO sync_sessions
+		(machine_id, status, started_at, data, session_id)
+		VALUES (?, ?, ?, ?, ?)`,
+		sess.MachineID,
+		string(sess.Status),
+		sess.StartedAt.Unix(),
+		string(data),
+		sess.ID,
+	)
+	return errors.Wrapf(err, "save session %s", sess.ID)
+}
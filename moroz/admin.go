@@ -0,0 +1,209 @@
+package moroz
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/inventory"
+)
+
+// AdminService exposes the server's inventory and reports to operators.
+type AdminService interface {
+	SantaVersionReport(ctx context.Context) (*SantaVersionReport, error)
+	Push(ctx context.Context, target PushTarget) (*PushResult, error)
+	MachineSessions(ctx context.Context, machineID string) ([]inventory.Session, error)
+	QueryEvents(ctx context.Context, q eventstore.Query) (*eventstore.Page, error)
+	EventRetention(ctx context.Context) ([]eventstore.RetentionStats, error)
+	Bundles(ctx context.Context) ([]catalog.Bundle, error)
+	Bundle(ctx context.Context, hash string) (*catalog.Bundle, error)
+	QueryBinaries(ctx context.Context, q catalog.BinaryQuery) (*catalog.BinaryPage, error)
+	Binary(ctx context.Context, sha256 string) (*catalog.Binary, error)
+	Certificates(ctx context.Context) ([]catalog.Certificate, error)
+	Certificate(ctx context.Context, sha256 string) (*catalog.Certificate, error)
+	CertificateExpiryReport(ctx context.Context, within time.Duration) (*CertificateExpiryReport, error)
+}
+
+type AdminEndpoints struct {
+	SantaVersionReportEndpoint endpoint.Endpoint
+	PushEndpoint               endpoint.Endpoint
+	MachineSessionsEndpoint    endpoint.Endpoint
+	QueryEventsEndpoint        endpoint.Endpoint
+	EventRetentionEndpoint     endpoint.Endpoint
+	BundlesEndpoint            endpoint.Endpoint
+	BundleEndpoint             endpoint.Endpoint
+	BundleRulesEndpoint        endpoint.Endpoint
+	QueryBinariesEndpoint      endpoint.Endpoint
+	BinaryEndpoint             endpoint.Endpoint
+	CertificatesEndpoint       endpoint.Endpoint
+	CertificateEndpoint        endpoint.Endpoint
+	CertificateExpiryEndpoint  endpoint.Endpoint
+}
+
+func MakeAdminEndpoints(svc AdminService) AdminEndpoints {
+	return AdminEndpoints{
+		SantaVersionReportEndpoint: makeSantaVersionReportEndpoint(svc),
+		PushEndpoint:               makePushEndpoint(svc),
+		MachineSessionsEndpoint:    makeMachineSessionsEndpoint(svc),
+		QueryEventsEndpoint:        makeQueryEventsEndpoint(svc),
+		EventRetentionEndpoint:     makeEventRetentionEndpoint(svc),
+		BundlesEndpoint:            makeBundlesEndpoint(svc),
+		BundleEndpoint:             makeBundleEndpoint(svc),
+		BundleRulesEndpoint:        makeBundleRulesEndpoint(svc),
+		QueryBinariesEndpoint:      makeQueryBinariesEndpoint(svc),
+		BinaryEndpoint:             makeBinaryEndpoint(svc),
+		CertificatesEndpoint:       makeCertificatesEndpoint(svc),
+		CertificateEndpoint:        makeCertificateEndpoint(svc),
+		CertificateExpiryEndpoint:  makeCertificateExpiryEndpoint(svc),
+	}
+}
+
+// AddAdminHTTPRoutes registers the admin API. Every request must carry
+// the token in an `Authorization: Bearer <token>` header.
+func AddAdminHTTPRoutes(r *mux.Router, e AdminEndpoints, logger log.Logger, token string) {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerAfter(
+			httptransport.SetContentType("application/json; charset=utf-8"),
+		),
+	}
+
+	// GET     /v1/admin/reports/santa-versions	machines grouped by santa version band.
+	// GET     /v1/admin/reports/certificate-expiry	certificate rules with expired or expiring certificates.
+	// POST    /v1/admin/push				ask machines to sync now.
+	// GET     /v1/admin/machines/:id/sessions	sync sessions of a machine, newest first.
+	// GET     /v1/admin/events			stored events, newest first.
+	// GET     /v1/admin/events/retention		events pruned from each event store.
+	// GET     /v1/admin/bundles			application bundles reported in events.
+	// GET     /v1/admin/bundles/:hash		a bundle and its binaries.
+	// GET     /v1/admin/bundles/:hash/rules	a rule for every binary of a bundle.
+	// GET     /v1/admin/binaries			binaries reported in events, seen on the most machines first.
+	// GET     /v1/admin/binaries/:sha256		a binary of the catalog.
+	// GET     /v1/admin/certificates		signing certificates reported in events.
+	// GET     /v1/admin/certificates/:sha256	a certificate of the catalog.
+
+	r.Methods("GET").Path("/v1/admin/reports/santa-versions").Handler(requireToken(token, httptransport.NewServer(
+		e.SantaVersionReportEndpoint,
+		decodeEmptyRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/reports/certificate-expiry").Handler(requireToken(token, httptransport.NewServer(
+		e.CertificateExpiryEndpoint,
+		decodeCertificateExpiryRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("POST").Path("/v1/admin/push").Handler(requireToken(token, httptransport.NewServer(
+		e.PushEndpoint,
+		decodePushRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/machines/{id}/sessions").Handler(requireToken(token, httptransport.NewServer(
+		e.MachineSessionsEndpoint,
+		decodeMachineSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/events").Handler(requireToken(token, httptransport.NewServer(
+		e.QueryEventsEndpoint,
+		decodeQueryEventsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/events/retention").Handler(requireToken(token, httptransport.NewServer(
+		e.EventRetentionEndpoint,
+		decodeEmptyRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/bundles").Handler(requireToken(token, httptransport.NewServer(
+		e.BundlesEndpoint,
+		decodeEmptyRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/bundles/{hash}").Handler(requireToken(token, httptransport.NewServer(
+		e.BundleEndpoint,
+		decodeBundleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/bundles/{hash}/rules").Handler(requireToken(token, httptransport.NewServer(
+		e.BundleRulesEndpoint,
+		decodeBundleRulesRequest,
+		encodeBundleRulesResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/binaries").Handler(requireToken(token, httptransport.NewServer(
+		e.QueryBinariesEndpoint,
+		decodeQueryBinariesRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/binaries/{sha256}").Handler(requireToken(token, httptransport.NewServer(
+		e.BinaryEndpoint,
+		decodeBinaryRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/certificates").Handler(requireToken(token, httptransport.NewServer(
+		e.CertificatesEndpoint,
+		decodeEmptyRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	r.Methods("GET").Path("/v1/admin/certificates/{sha256}").Handler(requireToken(token, httptransport.NewServer(
+		e.CertificateEndpoint,
+		decodeCertificateRequest,
+		encodeResponse,
+		options...,
+	)))
+}
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+
+	// errNoMachineStore is returned by reports which need the machine inventory when it is disabled.
+	errNoMachineStore = statusError{errors.New("machine inventory is disabled"), http.StatusNotImplemented}
+)
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			errorEncoder(r.Context(), statusError{errUnauthorized, http.StatusUnauthorized}, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeEmptyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return struct{}{}, nil
+}
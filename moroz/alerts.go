@@ -0,0 +1,53 @@
+package moroz
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/groob/moroz/alert"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+// Alerter evaluates alert rules against the events accepted by the service.
+type Alerter interface {
+	Evaluate(ctx context.Context, events []alert.Event)
+}
+
+// WithAlerter evaluates the alert rules of alerter on every uploaded event.
+func WithAlerter(alerter Alerter) Option {
+	return func(svc *SantaService) {
+		svc.alerter = alerter
+	}
+}
+
+// evaluateAlerts passes events to the alerter, with the certificates of their
+// signing chain which the configuration of the machine blocklists.
+func (svc *SantaService) evaluateAlerts(ctx context.Context, machineID string, events []eventstore.Event) {
+	if svc.alerter == nil || len(events) == 0 {
+		return
+	}
+	blocklisted := make(map[string]bool)
+	config, err := svc.config(ctx, machineID)
+	if err != nil {
+		level.Info(svc.logger).Log("msg", "get config for alert rules", "machine_id", machineID, "err", err)
+	}
+	for _, rule := range config.Rules {
+		if rule.RuleType == santa.Certificate && (rule.Policy == santa.Blocklist || rule.Policy == santa.SilentBlocklist) {
+			blocklisted[rule.Identifier] = true
+		}
+	}
+
+	alertEvents := make([]alert.Event, 0, len(events))
+	for _, ev := range events {
+		aev := alert.Event{Event: ev}
+		for _, cert := range ev.Event.SigningChain {
+			if blocklisted[cert.SHA256] {
+				aev.BlocklistedCertificates = append(aev.BlocklistedCertificates, cert.SHA256)
+			}
+		}
+		alertEvents = append(alertEvents, aev)
+	}
+	svc.alerter.Evaluate(ctx, alertEvents)
+}
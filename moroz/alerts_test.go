@@ -0,0 +1,53 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/groob/moroz/alert"
+	"github.com/groob/moroz/santa"
+)
+
+type recordingAlerter struct {
+	events []alert.Event
+}
+
+func (r *recordingAlerter) Evaluate(ctx context.Context, events []alert.Event) {
+	r.events = append(r.events, events...)
+}
+
+func TestEvaluateAlertsBlocklistedCertificates(t *testing.T) {
+	configs := staticConfigs{
+		"global": {MachineID: "global", Rules: []santa.Rule{
+			{RuleType: santa.Certificate, Policy: santa.Blocklist, Identifier: "blocked"},
+			{RuleType: santa.Certificate, Policy: santa.SilentBlocklist, Identifier: "silenced"},
+			{RuleType: santa.Certificate, Policy: santa.Allowlist, Identifier: "allowed"},
+		}},
+	}
+	alerter := &recordingAlerter{}
+	svc, err := NewService(configs, nil, WithAlerter(alerter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []santa.EventPayload{
+		{EventInfo: santa.EventUploadEvent{FileSHA256: "a", Decision: "ALLOW_BINARY", SigningChain: []santa.SigningEntry{{SHA256: "blocked"}, {SHA256: "root"}}}},
+		{EventInfo: santa.EventUploadEvent{FileSHA256: "b", Decision: "ALLOW_CERTIFICATE", SigningChain: []santa.SigningEntry{{SHA256: "allowed"}}}},
+		{EventInfo: santa.EventUploadEvent{FileSHA256: "c", Decision: "ALLOW_BINARY", SigningChain: []santa.SigningEntry{{SHA256: "silenced"}}}},
+	}
+	if _, err := svc.UploadEvent(context.Background(), "M1", events); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerter.events) != 3 {
+		t.Fatalf("have %d events evaluated, want 3\n", len(alerter.events))
+	}
+	if certs := alerter.events[0].BlocklistedCertificates; len(certs) != 1 || certs[0] != "blocked" {
+		t.Errorf("have blocklisted certificates %v, want blocked\n", certs)
+	}
+	if certs := alerter.events[1].BlocklistedCertificates; len(certs) != 0 {
+		t.Errorf("have blocklisted certificates %v, want none\n", certs)
+	}
+	// a silent blocklist blocks without notifying the user, and is still a blocklist.
+	if certs := alerter.events[2].BlocklistedCertificates; len(certs) != 1 || certs[0] != "silenced" {
+		t.Errorf("have blocklisted certificates %v, want silenced\n", certs)
+	}
+}
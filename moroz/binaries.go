@@ -0,0 +1,173 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/eventstore"
+)
+
+// BinaryStore persists the catalog of binaries reported in events.
+type BinaryStore interface {
+	Binary(ctx context.Context, sha256 string) (catalog.Binary, error)
+	Binaries(ctx context.Context, q catalog.BinaryQuery) (catalog.BinaryPage, error)
+	SaveBinary(ctx context.Context, b catalog.Binary) error
+	// AddBinaryMachine records that a machine reported a binary, and returns
+	// the number of machines which did.
+	AddBinaryMachine(ctx context.Context, sha256, machineID string) (int, error)
+}
+
+// WithBinaryStore aggregates the binaries reported in events into a catalog.
+func WithBinaryStore(store BinaryStore) Option {
+	return func(svc *SantaService) {
+		svc.binaries = store
+	}
+}
+
+// recordBinaries adds the events to the catalog of the binaries they executed.
+// Errors are logged, and never fail the upload.
+func (svc *SantaService) recordBinaries(ctx context.Context, machineID string, events []eventstore.Event) {
+	if svc.binaries == nil {
+		return
+	}
+	bySHA := make(map[string][]eventstore.Event)
+	var order []string
+	for _, ev := range events {
+		sha := ev.Event.FileSHA256
+		if sha == "" {
+			continue
+		}
+		if _, ok := bySHA[sha]; !ok {
+			order = append(order, sha)
+		}
+		bySHA[sha] = append(bySHA[sha], ev)
+	}
+
+	svc.catalogMtx.Lock()
+	defer svc.catalogMtx.Unlock()
+	for _, sha := range order {
+		b, err := svc.binaries.Binary(ctx, sha)
+		if err != nil && !catalog.IsNotFound(err) {
+			level.Info(svc.logger).Log("msg", "get catalog binary", "sha256", sha, "err", err)
+			continue
+		}
+		for _, ev := range bySHA[sha] {
+			b.Observe(machineID, ev.Event, ev.ReceivedAt)
+		}
+		// the binary lists a sample of the machines, the store counts all of them.
+		if n, err := svc.binaries.AddBinaryMachine(ctx, sha, machineID); err != nil {
+			level.Info(svc.logger).Log("msg", "add catalog binary machine", "sha256", sha, "err", err)
+		} else {
+			b.MachineCount = n
+		}
+		if err := svc.binaries.SaveBinary(ctx, b); err != nil {
+			level.Info(svc.logger).Log("msg", "save catalog binary", "sha256", sha, "err", err)
+		}
+	}
+}
+
+var errNoBinaryStore = statusError{errors.New("binary catalog is disabled"), http.StatusNotImplemented}
+
+// QueryBinaries returns the binaries of the catalog, seen on the most machines first.
+func (svc *SantaService) QueryBinaries(ctx context.Context, q catalog.BinaryQuery) (*catalog.BinaryPage, error) {
+	if svc.binaries == nil {
+		return nil, errNoBinaryStore
+	}
+	page, err := svc.binaries.Binaries(ctx, q)
+	if errors.Cause(err) == catalog.ErrInvalidCursor {
+		return nil, statusError{err, http.StatusBadRequest}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Binary returns a binary of the catalog.
+func (svc *SantaService) Binary(ctx context.Context, sha256 string) (*catalog.Binary, error) {
+	if svc.binaries == nil {
+		return nil, errNoBinaryStore
+	}
+	b, err := svc.binaries.Binary(ctx, sha256)
+	if catalog.IsNotFound(err) {
+		return nil, statusError{err, http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+type queryBinariesRequest struct {
+	Query catalog.BinaryQuery
+}
+
+type queryBinariesResponse struct {
+	*catalog.BinaryPage
+	Err error `json:"error,omitempty"`
+}
+
+func (r queryBinariesResponse) Failed() error { return r.Err }
+
+func makeQueryBinariesEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(queryBinariesRequest)
+		page, err := svc.QueryBinaries(ctx, req.Query)
+		if err != nil {
+			return queryBinariesResponse{Err: err}, nil
+		}
+		return queryBinariesResponse{BinaryPage: page}, nil
+	}
+}
+
+func decodeQueryBinariesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	v := r.URL.Query()
+	q := catalog.BinaryQuery{
+		TeamID:     v.Get("team_id"),
+		SigningID:  v.Get("signing_id"),
+		BundleID:   v.Get("bundle_id"),
+		Decision:   v.Get("decision"),
+		CertSHA256: v.Get("cert_sha256"),
+		Cursor:     v.Get("cursor"),
+	}
+	if limit := v.Get("limit"); limit != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			return nil, statusError{errors.Errorf("invalid limit %q", limit), http.StatusBadRequest}
+		}
+	}
+	return queryBinariesRequest{Query: q}, nil
+}
+
+type binaryRequest struct {
+	SHA256 string
+}
+
+type binaryResponse struct {
+	*catalog.Binary
+	Err error `json:"error,omitempty"`
+}
+
+func (r binaryResponse) Failed() error { return r.Err }
+
+func makeBinaryEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(binaryRequest)
+		b, err := svc.Binary(ctx, req.SHA256)
+		if err != nil {
+			return binaryResponse{Err: err}, nil
+		}
+		return binaryResponse{Binary: b}, nil
+	}
+}
+
+func decodeBinaryRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return binaryRequest{SHA256: mux.Vars(r)["sha256"]}, nil
+}
@@ -0,0 +1,116 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/santa"
+)
+
+func TestRecordBinaries(t *testing.T) {
+	ctx := context.Background()
+	store, err := catalog.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, nil, WithBinaryStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(sha, decision string) santa.EventPayload {
+		return santa.EventPayload{EventInfo: santa.EventUploadEvent{FileSHA256: sha, Decision: decision, TeamID: "EQHXZ8M8AV"}}
+	}
+	uploads := []struct {
+		machineID string
+		events    []santa.EventPayload
+	}{
+		{"M1", []santa.EventPayload{event("chrome", "BLOCK_UNKNOWN"), event("chrome", "BLOCK_UNKNOWN"), event("helper", "ALLOW_BINARY")}},
+		{"M2", []santa.EventPayload{event("chrome", "ALLOW_BINARY")}},
+		// a machine uploading the binary again isn't counted twice.
+		{"M1", []santa.EventPayload{event("chrome", "ALLOW_BINARY")}},
+	}
+	for _, u := range uploads {
+		if _, err := svc.UploadEvent(ctx, u.machineID, u.events); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := svc.Binary(ctx, "chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MachineCount != 2 || b.Decisions["BLOCK_UNKNOWN"] != 2 || b.Decisions["ALLOW_BINARY"] != 2 {
+		t.Errorf("have binary %+v, want 2 machines, 2 blocks and 2 allows\n", b)
+	}
+	if _, err := svc.Binary(ctx, "missing"); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("have error %v for a missing binary, want not found\n", err)
+	}
+
+	page, err := svc.QueryBinaries(ctx, catalog.BinaryQuery{TeamID: "EQHXZ8M8AV", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "chrome" || page.Next == "" {
+		t.Fatalf("have page %+v, want chrome first, with a cursor\n", page)
+	}
+	page, err = svc.QueryBinaries(ctx, catalog.BinaryQuery{TeamID: "EQHXZ8M8AV", Limit: 1, Cursor: page.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Binaries) != 1 || page.Binaries[0].SHA256 != "helper" || page.Next != "" {
+		t.Errorf("have page %+v, want helper last\n", page)
+	}
+	if _, err := svc.QueryBinaries(ctx, catalog.BinaryQuery{Cursor: "not-a-cursor"}); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("have error %v for an invalid cursor, want a bad request\n", err)
+	}
+
+	svc, err = NewService(staticConfigs{"global": {MachineID: "global"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.QueryBinaries(ctx, catalog.BinaryQuery{}); !isStatus(err, http.StatusNotImplemented) {
+		t.Errorf("have error %v without a binary store, want not implemented\n", err)
+	}
+}
+
+func TestDecodeQueryBinariesRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  catalog.BinaryQuery
+		err   bool
+	}{
+		{name: "empty", query: "", want: catalog.BinaryQuery{}},
+		{
+			name:  "filters",
+			query: "team_id=T1&signing_id=S1&bundle_id=B1&decision=BLOCK_BINARY&cert_sha256=ccc&cursor=2:aaa&limit=20",
+			want: catalog.BinaryQuery{
+				TeamID: "T1", SigningID: "S1", BundleID: "B1", Decision: "BLOCK_BINARY",
+				CertSHA256: "ccc", Cursor: "2:aaa", Limit: 20,
+			},
+		},
+		{name: "bad limit", query: "limit=ten", err: true},
+		{name: "negative limit", query: "limit=-1", err: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/catalog/binaries?"+tt.query, nil)
+		req, err := decodeQueryBinariesRequest(context.Background(), r)
+		if tt.err {
+			if !isStatus(err, http.StatusBadRequest) {
+				t.Errorf("%s: have error %v, want a bad request\n", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s\n", tt.name, err)
+			continue
+		}
+		if have := req.(queryBinariesRequest).Query; have != tt.want {
+			t.Errorf("%s: have query %+v, want %+v\n", tt.name, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/santa"
+)
+
+// bundleRequestRetry is how long to wait for the binaries of a bundle
+// before asking another machine to upload them.
+const bundleRequestRetry = 24 * time.Hour
+
+// BundleStore persists the application bundles reported in events.
+type BundleStore interface {
+	Bundle(ctx context.Context, hash string) (catalog.Bundle, error)
+	Bundles(ctx context.Context) ([]catalog.Bundle, error)
+	SaveBundle(ctx context.Context, b catalog.Bundle) error
+}
+
+// WithBundleStore records the bundles reported in events, and asks machines
+// with EnableBundles to upload the binaries of bundles which are new.
+func WithBundleStore(store BundleStore) Option {
+	return func(svc *SantaService) {
+		svc.bundles = store
+	}
+}
+
+// recordBundles updates the bundles of events, and returns the hashes of the
+// bundles the machine should upload the binaries of. Errors are logged, and
+// never fail the upload.
+func (svc *SantaService) recordBundles(ctx context.Context, machineID string, events []eventstore.Event) []string {
+	if svc.bundles == nil {
+		return nil
+	}
+	byHash := make(map[string][]eventstore.Event)
+	var order []string
+	for _, ev := range events {
+		hash := ev.Event.FileBundleHash
+		if hash == "" {
+			continue
+		}
+		if _, ok := byHash[hash]; !ok {
+			order = append(order, hash)
+		}
+		byHash[hash] = append(byHash[hash], ev)
+	}
+
+	var (
+		request []string
+		enabled *bool
+	)
+	svc.catalogMtx.Lock()
+	defer svc.catalogMtx.Unlock()
+	for _, hash := range order {
+		b, err := svc.bundles.Bundle(ctx, hash)
+		if err != nil && !catalog.IsNotFound(err) {
+			level.Info(svc.logger).Log("msg", "get bundle", "bundle_hash", hash, "err", err)
+			continue
+		}
+		var received time.Time
+		for _, ev := range byHash[hash] {
+			b.Observe(ev.Event, ev.ReceivedAt)
+			if ev.ReceivedAt.After(received) {
+				received = ev.ReceivedAt
+			}
+		}
+
+		if !b.Complete() && received.Sub(b.RequestedAt) > bundleRequestRetry {
+			if enabled == nil {
+				on := svc.bundlesEnabled(ctx, machineID)
+				enabled = &on
+			}
+			if *enabled {
+				b.RequestedAt = received
+				request = append(request, hash)
+			}
+		}
+		if err := svc.bundles.SaveBundle(ctx, b); err != nil {
+			level.Info(svc.logger).Log("msg", "save bundle", "bundle_hash", hash, "err", err)
+		}
+	}
+	return request
+}
+
+// bundlesEnabled reports whether the machine was configured with EnableBundles
+// in its last preflight.
+func (svc *SantaService) bundlesEnabled(ctx context.Context, machineID string) bool {
+	config, err := svc.config(ctx, machineID)
+	if err != nil {
+		return false
+	}
+	if svc.machines == nil || len(config.Overrides) == 0 {
+		return config.EnableBundles
+	}
+	m, err := svc.machines.Machine(ctx, machineID)
+	if err != nil {
+		return config.EnableBundles
+	}
+	pre, _, err := santa.ApplyOverrides(config.Preflight, config.Overrides, machineID, m.Preflight)
+	if err != nil {
+		return config.EnableBundles
+	}
+	return pre.EnableBundles
+}
+
+var errNoBundleStore = statusError{errors.New("bundle catalog is disabled"), http.StatusNotImplemented}
+
+// Bundles returns the bundles reported in events.
+func (svc *SantaService) Bundles(ctx context.Context) ([]catalog.Bundle, error) {
+	if svc.bundles == nil {
+		return nil, errNoBundleStore
+	}
+	bundles, err := svc.bundles.Bundles(ctx)
+	if bundles == nil {
+		bundles = []catalog.Bundle{}
+	}
+	return bundles, err
+}
+
+// Bundle returns a bundle with the binaries uploaded so far.
+func (svc *SantaService) Bundle(ctx context.Context, hash string) (*catalog.Bundle, error) {
+	if svc.bundles == nil {
+		return nil, errNoBundleStore
+	}
+	b, err := svc.bundles.Bundle(ctx, hash)
+	if catalog.IsNotFound(err) {
+		return nil, statusError{err, http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+type bundlesResponse struct {
+	Bundles []catalog.Bundle `json:"bundles"`
+	Err     error            `json:"error,omitempty"`
+}
+
+func (r bundlesResponse) Failed() error { return r.Err }
+
+func makeBundlesEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		bundles, err := svc.Bundles(ctx)
+		if err != nil {
+			return bundlesResponse{Err: err}, nil
+		}
+		return bundlesResponse{Bundles: bundles}, nil
+	}
+}
+
+type bundleRequest struct {
+	Hash string
+}
+
+type bundleResponse struct {
+	*catalog.Bundle
+	Complete bool  `json:"complete"`
+	Err      error `json:"error,omitempty"`
+}
+
+func (r bundleResponse) Failed() error { return r.Err }
+
+func makeBundleEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(bundleRequest)
+		b, err := svc.Bundle(ctx, req.Hash)
+		if err != nil {
+			return bundleResponse{Err: err}, nil
+		}
+		return bundleResponse{Bundle: b, Complete: b.Complete()}, nil
+	}
+}
+
+func decodeBundleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return bundleRequest{Hash: mux.Vars(r)["hash"]}, nil
+}
+
+type bundleRulesRequest struct {
+	Hash   string
+	Policy santa.Policy
+	TOML   bool
+}
+
+type bundleRulesResponse struct {
+	Rules []santa.Rule `json:"rules" toml:"rules"`
+	Err   error        `json:"error,omitempty" toml:"-"`
+
+	toml bool
+}
+
+func (r bundleRulesResponse) Failed() error { return r.Err }
+
+// makeBundleRulesEndpoint returns a rule for every binary of a bundle, to
+// allowlist or block a whole application at once.
+func makeBundleRulesEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(bundleRulesRequest)
+		b, err := svc.Bundle(ctx, req.Hash)
+		if err != nil {
+			return bundleRulesResponse{Err: err}, nil
+		}
+		if !b.Complete() {
+			err := errors.Errorf("bundle %s has %d of %d binaries", b.Hash, len(b.Binaries), b.BinaryCount)
+			return bundleRulesResponse{Err: statusError{err, http.StatusConflict}}, nil
+		}
+		return bundleRulesResponse{Rules: b.Rules(req.Policy), toml: req.TOML}, nil
+	}
+}
+
+func decodeBundleRulesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := bundleRulesRequest{Hash: mux.Vars(r)["hash"], Policy: santa.Allowlist}
+	if policy := r.URL.Query().Get("policy"); policy != "" {
+		if err := req.Policy.UnmarshalText([]byte(strings.ToUpper(policy))); err != nil || req.Policy == santa.PolicyUnknown || req.Policy == santa.Cel {
+			return nil, statusError{errors.Errorf("invalid policy %q", policy), http.StatusBadRequest}
+		}
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "toml":
+		req.TOML = true
+	default:
+		return nil, statusError{errors.Errorf("invalid format %q, want json or toml", format), http.StatusBadRequest}
+	}
+	return req, nil
+}
+
+// encodeBundleRulesResponse writes the rules as [[rules]] TOML tables when
+// asked to, ready to be added to a configuration file.
+func encodeBundleRulesResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(bundleRulesResponse)
+	if resp.Err != nil || !resp.toml {
+		return encodeResponse(ctx, w, response)
+	}
+	w.Header().Set("Content-Type", "application/toml; charset=utf-8")
+	return toml.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,89 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/santa"
+)
+
+func TestBundleBinaries(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {MachineID: "global", Preflight: santa.Preflight{EnableBundles: true}},
+	}
+	store, err := catalog.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles := &countingBundleStore{BundleStore: store}
+	svc, err := NewService(configs, nil, WithBundleStore(bundles))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := santa.EventPayload{EventInfo: santa.EventUploadEvent{
+		FileSHA256:            "main",
+		Decision:              "BLOCK_UNKNOWN",
+		FileBundleHash:        "bundle",
+		FileBundleID:          "com.example.App",
+		FileBundleBinaryCount: 2,
+	}}
+	result, err := svc.UploadEvent(ctx, "M1", []santa.EventPayload{blocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BundleBinaries) != 1 || result.BundleBinaries[0] != "bundle" {
+		t.Fatalf("have bundle binaries %v, want the new bundle\n", result.BundleBinaries)
+	}
+
+	// another machine isn't asked again while the first one uploads the binaries.
+	result, err = svc.UploadEvent(ctx, "M2", []santa.EventPayload{blocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BundleBinaries) != 0 {
+		t.Errorf("have bundle binaries %v, want none for a requested bundle\n", result.BundleBinaries)
+	}
+
+	var binaries []santa.EventPayload
+	for _, sha := range []string{"main", "helper"} {
+		binaries = append(binaries, santa.EventPayload{EventInfo: santa.EventUploadEvent{
+			FileSHA256:                  sha,
+			Decision:                    "BUNDLE_BINARY",
+			FileBundleHash:              "bundle",
+			FileBundleBinaryCount:       2,
+			FileBundleExecutableRelPath: "Contents/MacOS/" + sha,
+		}})
+	}
+	bundles.saves = 0
+	if _, err := svc.UploadEvent(ctx, "M1", binaries); err != nil {
+		t.Fatal(err)
+	}
+	if bundles.saves != 1 {
+		t.Errorf("have %d bundle saves for one upload, want 1\n", bundles.saves)
+	}
+	b, err := svc.Bundle(ctx, "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Complete() || b.BundleID != "com.example.App" {
+		t.Fatalf("have bundle %+v, want complete com.example.App\n", b)
+	}
+	rules := b.Rules(santa.Allowlist)
+	if len(rules) != 2 || rules[1].Identifier != "helper" || *rules[1].FileBundleHash != "bundle" {
+		t.Errorf("have rules %+v, want an allowlist rule per binary\n", rules)
+	}
+}
+
+// countingBundleStore counts the bundles saved.
+type countingBundleStore struct {
+	BundleStore
+	saves int
+}
+
+func (s *countingBundleStore) SaveBundle(ctx context.Context, b catalog.Bundle) error {
+	s.saves++
+	return s.BundleStore.SaveBundle(ctx, b)
+}
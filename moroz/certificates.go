@@ -0,0 +1,150 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/eventstore"
+)
+
+// CertificateStore persists the catalog of signing certificates reported in events.
+type CertificateStore interface {
+	Certificate(ctx context.Context, sha256 string) (catalog.Certificate, error)
+	Certificates(ctx context.Context) ([]catalog.Certificate, error)
+	SaveCertificate(ctx context.Context, c catalog.Certificate) error
+	// AddCertificateMachine records that a machine reported a certificate,
+	// and returns the number of machines which did.
+	AddCertificateMachine(ctx context.Context, sha256, machineID string) (int, error)
+}
+
+// WithCertificateStore aggregates the signing chains of events into a catalog of certificates.
+func WithCertificateStore(store CertificateStore) Option {
+	return func(svc *SantaService) {
+		svc.certificates = store
+	}
+}
+
+// recordCertificates adds the signing chains of events to the certificate catalog.
+// Errors are logged, and never fail the upload.
+func (svc *SantaService) recordCertificates(ctx context.Context, machineID string, events []eventstore.Event) {
+	if svc.certificates == nil {
+		return
+	}
+	type signature struct {
+		event    eventstore.Event
+		position int
+	}
+	bySHA := make(map[string][]signature)
+	var order []string
+	for _, ev := range events {
+		for i, entry := range ev.Event.SigningChain {
+			if entry.SHA256 == "" {
+				continue
+			}
+			if _, ok := bySHA[entry.SHA256]; !ok {
+				order = append(order, entry.SHA256)
+			}
+			bySHA[entry.SHA256] = append(bySHA[entry.SHA256], signature{ev, i})
+		}
+	}
+
+	svc.catalogMtx.Lock()
+	defer svc.catalogMtx.Unlock()
+	for _, sha := range order {
+		c, err := svc.certificates.Certificate(ctx, sha)
+		if err != nil && !catalog.IsNotFound(err) {
+			level.Info(svc.logger).Log("msg", "get catalog certificate", "sha256", sha, "err", err)
+			continue
+		}
+		for _, sig := range bySHA[sha] {
+			c.Observe(machineID, sig.event.Event, sig.position, sig.event.ReceivedAt)
+		}
+		// the certificate lists a sample of the machines, the store counts all of them.
+		if n, err := svc.certificates.AddCertificateMachine(ctx, sha, machineID); err != nil {
+			level.Info(svc.logger).Log("msg", "add catalog certificate machine", "sha256", sha, "err", err)
+		} else {
+			c.MachineCount = n
+		}
+		if err := svc.certificates.SaveCertificate(ctx, c); err != nil {
+			level.Info(svc.logger).Log("msg", "save catalog certificate", "sha256", sha, "err", err)
+		}
+	}
+}
+
+var errNoCertificateStore = statusError{errors.New("certificate catalog is disabled"), http.StatusNotImplemented}
+
+// Certificates returns the certificates of the catalog.
+func (svc *SantaService) Certificates(ctx context.Context) ([]catalog.Certificate, error) {
+	if svc.certificates == nil {
+		return nil, errNoCertificateStore
+	}
+	certs, err := svc.certificates.Certificates(ctx)
+	if certs == nil {
+		certs = []catalog.Certificate{}
+	}
+	return certs, err
+}
+
+// Certificate returns a certificate of the catalog.
+func (svc *SantaService) Certificate(ctx context.Context, sha256 string) (*catalog.Certificate, error) {
+	if svc.certificates == nil {
+		return nil, errNoCertificateStore
+	}
+	c, err := svc.certificates.Certificate(ctx, sha256)
+	if catalog.IsNotFound(err) {
+		return nil, statusError{err, http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+type certificatesResponse struct {
+	Certificates []catalog.Certificate `json:"certificates"`
+	Err          error                 `json:"error,omitempty"`
+}
+
+func (r certificatesResponse) Failed() error { return r.Err }
+
+func makeCertificatesEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		certs, err := svc.Certificates(ctx)
+		if err != nil {
+			return certificatesResponse{Err: err}, nil
+		}
+		return certificatesResponse{Certificates: certs}, nil
+	}
+}
+
+type certificateRequest struct {
+	SHA256 string
+}
+
+type certificateResponse struct {
+	*catalog.Certificate
+	Err error `json:"error,omitempty"`
+}
+
+func (r certificateResponse) Failed() error { return r.Err }
+
+func makeCertificateEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(certificateRequest)
+		c, err := svc.Certificate(ctx, req.SHA256)
+		if err != nil {
+			return certificateResponse{Err: err}, nil
+		}
+		return certificateResponse{Certificate: c}, nil
+	}
+}
+
+func decodeCertificateRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return certificateRequest{SHA256: mux.Vars(r)["sha256"]}, nil
+}
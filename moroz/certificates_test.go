@@ -0,0 +1,109 @@
+package moroz
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/santa"
+)
+
+func TestCertificateExpiryReport(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {MachineID: "global", Rules: []santa.Rule{
+			{RuleType: santa.Certificate, Policy: santa.Allowlist, Identifier: "expired"},
+			{RuleType: santa.Certificate, Policy: santa.Allowlist, Identifier: "expiring"},
+			{RuleType: santa.Certificate, Policy: santa.Allowlist, Identifier: "valid"},
+			{RuleType: santa.Certificate, Policy: santa.Blocklist, Identifier: "unseen"},
+			{RuleType: santa.Binary, Policy: santa.Allowlist, Identifier: "expired"},
+		}},
+		"M1": {MachineID: "M1", Rules: []santa.Rule{
+			{RuleType: santa.Certificate, Policy: santa.Allowlist, Identifier: "expiring"},
+		}},
+	}
+	certs, err := catalog.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(configs, nil, WithCertificateStore(certs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	until := map[string]time.Time{
+		"expired":  now.Add(-time.Hour),
+		"expiring": now.Add(7 * 24 * time.Hour),
+		"valid":    now.Add(365 * 24 * time.Hour),
+	}
+	var events []santa.EventPayload
+	for sha, validUntil := range until {
+		events = append(events, santa.EventPayload{EventInfo: santa.EventUploadEvent{
+			FileSHA256: "bin-" + sha,
+			Decision:   "ALLOW_CERTIFICATE",
+			SigningChain: []santa.SigningEntry{
+				{SHA256: sha, CertificateName: "Developer ID Application: " + sha, ValidUntil: int(validUntil.Unix())},
+				{SHA256: "root", CertificateName: "Apple Root CA"},
+			},
+		}})
+	}
+	if _, err := svc.UploadEvent(ctx, "M1", events); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := svc.Certificate(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Leaf || len(root.Binaries) != 3 {
+		t.Errorf("have root %+v, want an intermediate signing 3 binaries\n", root)
+	}
+
+	report, err := svc.CertificateExpiryReport(ctx, defaultExpiryWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Expired) != 1 || report.Expired[0].Identifier != "expired" {
+		t.Errorf("have expired %+v, want the expired rule\n", report.Expired)
+	}
+	if len(report.Expiring) != 1 || len(report.Expiring[0].Configs) != 2 {
+		t.Errorf("have expiring %+v, want the expiring rule in 2 configs\n", report.Expiring)
+	}
+	if len(report.Unknown) != 1 || report.Unknown[0].Identifier != "unseen" {
+		t.Errorf("have unknown %+v, want the unseen rule\n", report.Unknown)
+	}
+}
+
+func TestCertificateMachines(t *testing.T) {
+	ctx := context.Background()
+	certs, err := catalog.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, nil, WithCertificateStore(certs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a root certificate is reported by most of a fleet.
+	event := santa.EventPayload{EventInfo: santa.EventUploadEvent{
+		FileSHA256:   "bin",
+		Decision:     "ALLOW_BINARY",
+		SigningChain: []santa.SigningEntry{{SHA256: "leaf"}, {SHA256: "root", CertificateName: "Apple Root CA"}},
+	}}
+	for i := 0; i < 150; i++ {
+		if _, err := svc.UploadEvent(ctx, "M"+strconv.Itoa(i%120), []santa.EventPayload{event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := svc.Certificate(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Machines) != 100 || root.MachineCount != 120 {
+		t.Errorf("have %d machines listed of %d, want 100 of 120\n", len(root.Machines), root.MachineCount)
+	}
+}
@@ -0,0 +1,165 @@
+package moroz
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+// EnrollmentSecretHeader carries the pre-shared enrollment secret.
+// Santa clients can send it with the SyncExtraHeaders configuration key.
+const EnrollmentSecretHeader = "X-Moroz-Enrollment-Secret"
+
+// Enrollment requires machines to enroll before they are served their configuration.
+// A machine enrolls by presenting one of the secrets, or a TLS client certificate
+// verified by the server, with its first preflight request.
+type Enrollment struct {
+	Secrets []string
+
+	// QuarantineConfig names the configuration served to machines which are not enrolled.
+	// When empty, requests from machines which are not enrolled are refused with a 403.
+	QuarantineConfig string
+
+	// AnyCertificate enrolls a machine presenting any verified client
+	// certificate. By default, the certificate must name the machine ID in
+	// its common name or a subject alternative name.
+	AnyCertificate bool
+}
+
+// WithEnrollment requires machines to enroll. It needs a MachineStore.
+func WithEnrollment(e Enrollment) Option {
+	return func(svc *SantaService) {
+		svc.enrollment = &e
+	}
+}
+
+var errNotEnrolled = statusError{errors.New("machine is not enrolled"), http.StatusForbidden}
+
+// clientCredentials are the enrollment credentials sent with a request.
+type clientCredentials struct {
+	EnrollmentSecret string
+
+	// Certificate is the client certificate, if one was sent and verified by the TLS server.
+	Certificate *x509.Certificate
+}
+
+type credentialsKey struct{}
+
+// credentialsToContext is a ServerBefore function which makes the request
+// credentials available to the service.
+func credentialsToContext(ctx context.Context, r *http.Request) context.Context {
+	creds := clientCredentials{EnrollmentSecret: r.Header.Get(EnrollmentSecretHeader)}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		creds.Certificate = r.TLS.VerifiedChains[0][0]
+	}
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+func credentialsFromContext(ctx context.Context) clientCredentials {
+	creds, _ := ctx.Value(credentialsKey{}).(clientCredentials)
+	return creds
+}
+
+// validCredentials reports whether the request carries a valid enrollment
+// secret, or a client certificate for machineID.
+func (e *Enrollment) validCredentials(ctx context.Context, machineID string) bool {
+	creds := credentialsFromContext(ctx)
+	if creds.Certificate != nil && (e.AnyCertificate || certificateNames(creds.Certificate, machineID)) {
+		return true
+	}
+	if creds.EnrollmentSecret == "" {
+		return false
+	}
+	for _, secret := range e.Secrets {
+		if subtle.ConstantTimeCompare([]byte(creds.EnrollmentSecret), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateNames reports whether the common name, or a DNS, email or URI
+// subject alternative name of cert is machineID.
+func certificateNames(cert *x509.Certificate, machineID string) bool {
+	if machineID == "" {
+		return false
+	}
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if name == machineID {
+			return true
+		}
+	}
+	return false
+}
+
+// enrolled reports whether a machine has enrolled. It is always true when enrollment is disabled.
+func (svc *SantaService) enrolled(ctx context.Context, machineID string) (bool, error) {
+	if svc.enrollment == nil {
+		return true, nil
+	}
+	m, err := svc.machines.Machine(ctx, machineID)
+	if inventory.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "load machine %s", machineID)
+	}
+	return m.Enrolled, nil
+}
+
+// quarantineConfig returns the configuration for machines which are not enrolled,
+// or errNotEnrolled if they are refused.
+func (svc *SantaService) quarantineConfig(ctx context.Context) (santa.Config, error) {
+	if svc.enrollment.QuarantineConfig == "" {
+		return santa.Config{}, errNotEnrolled
+	}
+	return svc.repo.Config(ctx, svc.enrollment.QuarantineConfig)
+}
+
+// enroll registers a machine which is not enrolled yet and presents valid credentials.
+func (svc *SantaService) enroll(ctx context.Context, machineID string) error {
+	if svc.enrollment == nil || !svc.enrollment.validCredentials(ctx, machineID) {
+		return nil
+	}
+	svc.machineMtx.Lock()
+	defer svc.machineMtx.Unlock()
+	m, err := svc.machines.Machine(ctx, machineID)
+	if err != nil && !inventory.IsNotFound(err) {
+		return errors.Wrapf(err, "load machine %s", machineID)
+	}
+	if m.Enrolled {
+		return nil
+	}
+	m.MachineID = machineID
+	m.Enrolled = true
+	m.EnrolledAt = time.Now().UTC()
+	return errors.Wrapf(svc.machines.SaveMachine(ctx, m), "enroll machine %s", machineID)
+}
+
+// checkEnrolled refuses requests from machines which are not enrolled,
+// unless they are served a quarantine configuration.
+func (svc *SantaService) checkEnrolled(ctx context.Context, machineID string) error {
+	if svc.enrollment == nil || svc.enrollment.QuarantineConfig != "" {
+		return nil
+	}
+	enrolled, err := svc.enrolled(ctx, machineID)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		return errNotEnrolled
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package moroz
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func withCredentials(creds clientCredentials) context.Context {
+	return context.WithValue(context.Background(), credentialsKey{}, creds)
+}
+
+func TestEnrollment(t *testing.T) {
+	configs := staticConfigs{
+		"global":     {MachineID: "global", Rules: []santa.Rule{{RuleType: santa.Binary, Policy: santa.Blocklist, Identifier: "global"}}},
+		"quarantine": {MachineID: "quarantine", Rules: []santa.Rule{{RuleType: santa.Binary, Policy: santa.Blocklist, Identifier: "quarantine"}}},
+	}
+	cert := func(cn string, dnsNames ...string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: dnsNames}
+	}
+	tests := []struct {
+		name       string
+		enrollment Enrollment
+		creds      clientCredentials
+		wantRule   string // the rule served after preflight, empty when refused
+	}{
+		{name: "valid secret", creds: clientCredentials{EnrollmentSecret: "s3cret"}, wantRule: "global"},
+		{name: "wrong secret", creds: clientCredentials{EnrollmentSecret: "guess"}},
+		{name: "no credentials"},
+		{name: "certificate common name", creds: clientCredentials{Certificate: cert("M1")}, wantRule: "global"},
+		{name: "certificate alternative name", creds: clientCredentials{Certificate: cert("device", "M1")}, wantRule: "global"},
+		{name: "certificate for another machine", creds: clientCredentials{Certificate: cert("M2", "M2")}},
+		{name: "any certificate", enrollment: Enrollment{AnyCertificate: true}, creds: clientCredentials{Certificate: cert("M2")}, wantRule: "global"},
+		{name: "quarantine", enrollment: Enrollment{QuarantineConfig: "quarantine"}, wantRule: "quarantine"},
+	}
+	for _, tt := range tests {
+		machines, err := inventory.NewFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.enrollment.Secrets = []string{"s3cret"}
+		svc, err := NewService(configs, nil, WithMachineStore(machines), WithEnrollment(tt.enrollment))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := withCredentials(tt.creds)
+
+		_, err = svc.Preflight(ctx, "M1", santa.PreflightPayload{})
+		if tt.wantRule == "" {
+			if !isStatus(err, http.StatusForbidden) {
+				t.Errorf("%s: have preflight error %v, want 403\n", tt.name, err)
+			}
+			// the other requests of the sync are refused too.
+			if _, err := svc.UploadEvent(ctx, "M1", []santa.EventPayload{{}}); !isStatus(err, http.StatusForbidden) {
+				t.Errorf("%s: have event upload error %v, want 403\n", tt.name, err)
+			}
+			if _, err := svc.Postflight(ctx, "M1", santa.PostflightPayload{}); !isStatus(err, http.StatusForbidden) {
+				t.Errorf("%s: have postflight error %v, want 403\n", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s\n", tt.name, err)
+		}
+		rules, err := svc.RuleDownload(ctx, "M1")
+		if err != nil {
+			t.Fatalf("%s: %s\n", tt.name, err)
+		}
+		if len(rules) != 1 || rules[0].Identifier != tt.wantRule {
+			t.Errorf("%s: have rules %+v, want the %s rule\n", tt.name, rules, tt.wantRule)
+		}
+	}
+}
+
+func TestEnrollmentQuarantineConfigExists(t *testing.T) {
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := staticConfigs{"global": {MachineID: "global"}}
+	_, err = NewService(configs, nil, WithMachineStore(machines), WithEnrollment(Enrollment{QuarantineConfig: "quarantine"}))
+	if err == nil {
+		t.Errorf("service created with a missing quarantine configuration\n")
+	}
+}
+
+func isStatus(err error, code int) bool {
+	serr, ok := errors.Cause(err).(statusError)
+	return ok && serr.code == code
+}
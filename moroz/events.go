@@ -0,0 +1,138 @@
+package moroz
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+// EventStore persists the events uploaded by machines.
+type EventStore interface {
+	Put(ctx context.Context, events []eventstore.Event) error
+	Query(ctx context.Context, q eventstore.Query) (eventstore.Page, error)
+	Delete(ctx context.Context, q eventstore.Query) (int, error)
+}
+
+// Deduplicator drops events which were already ingested, such as events
+// uploaded again by a client retrying after a network error.
+type Deduplicator interface {
+	// Filter returns the events which were not ingested yet, and the number of duplicates.
+	// The returned events are reserved until they are recorded or released.
+	Filter(events []eventstore.Event) ([]eventstore.Event, int)
+	// Record remembers events once they are stored.
+	Record(events []eventstore.Event) error
+	// Release forgets events which couldn't be stored.
+	Release(events []eventstore.Event)
+}
+
+// WithDeduplicator drops duplicate events before they are stored.
+func WithDeduplicator(d Deduplicator) Option {
+	return func(svc *SantaService) {
+		svc.dedup = d
+	}
+}
+
+// RetentionJanitor prunes an event store, and reports what it pruned.
+type RetentionJanitor interface {
+	Stats() eventstore.RetentionStats
+}
+
+// WithRetentionJanitor reports the pruning of janitor in the admin API.
+func WithRetentionJanitor(janitor RetentionJanitor) Option {
+	return func(svc *SantaService) {
+		svc.janitors = append(svc.janitors, janitor)
+	}
+}
+
+// EventSink forwards events to an external system, such as a SIEM.
+type EventSink interface {
+	WriteEvents(ctx context.Context, events []eventstore.Event) error
+}
+
+// WithEventSinks forwards the events accepted by the service to sinks.
+// Sinks should queue events rather than block the upload.
+func WithEventSinks(sinks ...EventSink) Option {
+	return func(svc *SantaService) {
+		svc.sinks = append(svc.sinks, sinks...)
+	}
+}
+
+// tagMachine sets the groups, hostname and client mode of the machine on
+// events, for sinks and alert rules.
+func (svc *SantaService) tagMachine(ctx context.Context, machineID string, events []eventstore.Event) {
+	if svc.machines == nil || len(svc.sinks) == 0 && svc.alerter == nil {
+		return
+	}
+	m, err := svc.machines.Machine(ctx, machineID)
+	if err != nil {
+		return
+	}
+	groups, err := svc.machineGroups(ctx, m)
+	if err != nil {
+		level.Info(svc.logger).Log("msg", "evaluate machine groups", "machine_id", machineID, "err", err)
+	}
+	mode, _ := m.Preflight.ClientMode.MarshalText()
+	for i := range events {
+		events[i].Groups = groups
+		events[i].Hostname = m.Preflight.Hostname
+		events[i].ClientMode = string(mode)
+	}
+}
+
+// forwardEvents writes events to every sink.
+// Errors are logged, and never fail the upload.
+func (svc *SantaService) forwardEvents(ctx context.Context, machineID string, events []eventstore.Event) {
+	if len(svc.sinks) == 0 || len(events) == 0 {
+		return
+	}
+	for _, sink := range svc.sinks {
+		if err := sink.WriteEvents(ctx, events); err != nil {
+			level.Info(svc.logger).Log("msg", "forward events", "machine_id", machineID, "count", len(events), "err", err)
+		}
+	}
+}
+
+// MultiEventStore chains event stores. Events are written to every store,
+// and queried from the first one.
+func MultiEventStore(stores ...EventStore) EventStore {
+	if len(stores) == 1 {
+		return stores[0]
+	}
+	return multiEventStore(stores)
+}
+
+type multiEventStore []EventStore
+
+func (m multiEventStore) Put(ctx context.Context, events []eventstore.Event) error {
+	for _, store := range m {
+		if err := store.Put(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiEventStore) Query(ctx context.Context, q eventstore.Query) (eventstore.Page, error) {
+	if len(m) == 0 {
+		return eventstore.Page{}, errors.New("no event store configured")
+	}
+	return m[0].Query(ctx, q)
+}
+
+// Delete deletes the events from every store, and returns the count of the first one.
+func (m multiEventStore) Delete(ctx context.Context, q eventstore.Query) (int, error) {
+	var deleted int
+	for i, store := range m {
+		n, err := store.Delete(ctx, q)
+		if err != nil {
+			return deleted, err
+		}
+		if i == 0 {
+			deleted = n
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,59 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+)
+
+// groupMembers returns the machines in the inventory which belong to the named group.
+func (svc *SantaService) groupMembers(ctx context.Context, group string) ([]inventory.Machine, error) {
+	if svc.machines == nil {
+		return nil, errNoMachineStore
+	}
+	global, err := svc.repo.Config(ctx, "global")
+	if err != nil {
+		return nil, err
+	}
+	cond, ok := global.Groups[group]
+	if !ok {
+		return nil, statusError{errors.Errorf("group %q not found", group), http.StatusNotFound}
+	}
+	machines, err := svc.machines.AllMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var members []inventory.Machine
+	for _, m := range machines {
+		ok, err := cond.Matches(m.MachineID, m.Preflight)
+		if err != nil {
+			return nil, errors.Wrapf(err, "evaluate group %q", group)
+		}
+		if ok {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// machineGroups returns the names of the groups a machine belongs to.
+func (svc *SantaService) machineGroups(ctx context.Context, m inventory.Machine) ([]string, error) {
+	global, err := svc.repo.Config(ctx, "global")
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for name, cond := range global.Groups {
+		ok, err := cond.Matches(m.MachineID, m.Preflight)
+		if err != nil {
+			return nil, errors.Wrapf(err, "evaluate group %q", name)
+		}
+		if ok {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,116 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// MachineIDPolicy decides which machine ID a request is handled as, when the
+// ID in the URL path differs from the machine_id in the request body.
+type MachineIDPolicy string
+
+const (
+	// PreferPayloadID uses the machine_id from the request body.
+	PreferPayloadID MachineIDPolicy = "prefer-payload"
+
+	// PreferURLID uses the ID from the URL path.
+	PreferURLID MachineIDPolicy = "prefer-url"
+
+	// RejectMismatchedID refuses the request with a 400.
+	RejectMismatchedID MachineIDPolicy = "reject"
+
+	// CertificateID rejects mismatches, and requires the ID to match the common name
+	// or a subject alternative name of a verified TLS client certificate.
+	CertificateID MachineIDPolicy = "certificate"
+)
+
+// ParseMachineIDPolicy parses the name of a MachineIDPolicy.
+func ParseMachineIDPolicy(s string) (MachineIDPolicy, error) {
+	switch p := MachineIDPolicy(s); p {
+	case PreferPayloadID, PreferURLID, RejectMismatchedID, CertificateID:
+		return p, nil
+	default:
+		return "", errors.Errorf("unknown machine ID policy %q", s)
+	}
+}
+
+// HTTPOption configures the Santa sync HTTP routes.
+type HTTPOption func(*httpConfig)
+
+type httpConfig struct {
+	idPolicy MachineIDPolicy
+}
+
+// WithMachineIDPolicy sets the policy applied to requests with conflicting machine IDs.
+// The default is PreferPayloadID.
+func WithMachineIDPolicy(p MachineIDPolicy) HTTPOption {
+	return func(c *httpConfig) {
+		c.idPolicy = p
+	}
+}
+
+var (
+	errMachineIDMismatch = statusError{errors.New("machine ID in URL does not match request body"), http.StatusBadRequest}
+	errMachineIDIdentity = statusError{errors.New("machine ID does not match client certificate"), http.StatusForbidden}
+)
+
+type machineIDResolver struct {
+	policy     MachineIDPolicy
+	logger     log.Logger
+	remoteAddr string
+}
+
+type machineIDResolverKey struct{}
+
+// toContext is a ServerBefore function which makes the policy available to the request decoders.
+func (res machineIDResolver) toContext(ctx context.Context, r *http.Request) context.Context {
+	res.remoteAddr = r.RemoteAddr
+	return context.WithValue(ctx, machineIDResolverKey{}, res)
+}
+
+// resolveMachineID returns the ID a request is handled as, given the ID in the
+// URL path and the machine_id sent in the request body, which may be empty.
+func resolveMachineID(ctx context.Context, urlID, payloadID string) (string, error) {
+	res, ok := ctx.Value(machineIDResolverKey{}).(machineIDResolver)
+	if !ok {
+		res = machineIDResolver{policy: PreferPayloadID, logger: log.NewNopLogger()}
+	}
+
+	id := urlID
+	if payloadID != "" && payloadID != urlID {
+		level.Warn(res.logger).Log(
+			"msg", "machine ID mismatch",
+			"event", "security",
+			"url_machine_id", urlID,
+			"payload_machine_id", payloadID,
+			"policy", res.policy,
+			"remote_addr", res.remoteAddr,
+		)
+		switch res.policy {
+		case PreferPayloadID:
+			id = payloadID
+		case PreferURLID:
+		default:
+			return "", errMachineIDMismatch
+		}
+	}
+
+	if res.policy == CertificateID {
+		cert := credentialsFromContext(ctx).Certificate
+		if cert == nil || !certificateNames(cert, id) {
+			level.Warn(res.logger).Log(
+				"msg", "machine ID does not match client certificate",
+				"event", "security",
+				"machine_id", id,
+				"certificate_present", cert != nil,
+				"remote_addr", res.remoteAddr,
+			)
+			return "", errMachineIDIdentity
+		}
+	}
+	return id, nil
+}
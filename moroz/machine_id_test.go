@@ -0,0 +1,51 @@
+package moroz
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestResolveMachineID(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "M1"}, DNSNames: []string{"m1.example.com"}}
+	tests := []struct {
+		policy    MachineIDPolicy
+		urlID     string
+		payloadID string
+		cert      *x509.Certificate
+		want      string
+		wantCode  int
+	}{
+		{policy: PreferPayloadID, urlID: "M1", payloadID: "M1", want: "M1"},
+		{policy: PreferPayloadID, urlID: "M1", payloadID: "", want: "M1"},
+		{policy: PreferPayloadID, urlID: "M1", payloadID: "M2", want: "M2"},
+		{policy: PreferURLID, urlID: "M1", payloadID: "M1", want: "M1"},
+		{policy: PreferURLID, urlID: "M1", payloadID: "M2", want: "M1"},
+		{policy: RejectMismatchedID, urlID: "M1", payloadID: "M1", want: "M1"},
+		{policy: RejectMismatchedID, urlID: "M1", payloadID: "", want: "M1"},
+		{policy: RejectMismatchedID, urlID: "M1", payloadID: "M2", wantCode: http.StatusBadRequest},
+		{policy: CertificateID, urlID: "M1", payloadID: "M1", cert: cert, want: "M1"},
+		{policy: CertificateID, urlID: "m1.example.com", cert: cert, want: "m1.example.com"},
+		{policy: CertificateID, urlID: "M1", payloadID: "M2", cert: cert, wantCode: http.StatusBadRequest},
+		{policy: CertificateID, urlID: "M2", payloadID: "M2", cert: cert, wantCode: http.StatusForbidden},
+		{policy: CertificateID, urlID: "M1", payloadID: "M1", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		res := machineIDResolver{policy: tt.policy, logger: log.NewNopLogger()}
+		ctx := context.WithValue(withCredentials(clientCredentials{Certificate: tt.cert}), machineIDResolverKey{}, res)
+		have, err := resolveMachineID(ctx, tt.urlID, tt.payloadID)
+		if tt.wantCode != 0 {
+			if !isStatus(err, tt.wantCode) {
+				t.Errorf("%s with url %q, payload %q: have error %v, want %d\n", tt.policy, tt.urlID, tt.payloadID, err, tt.wantCode)
+			}
+			continue
+		}
+		if err != nil || have != tt.want {
+			t.Errorf("%s with url %q, payload %q: have %q, %v, want %q\n", tt.policy, tt.urlID, tt.payloadID, have, err, tt.want)
+		}
+	}
+}
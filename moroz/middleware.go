@@ -1,7 +1,12 @@
 package moroz
 
 import (
+	"context"
+
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/groob/moroz/audit"
 )
 
 type Middleware func(Service) Service
@@ -16,3 +21,23 @@ type logmw struct {
 	logger log.Logger
 	next   Service
 }
+
+// AuditMiddleware writes an audit record of every sync request to sink.
+// Errors writing records are logged, and never fail the request.
+func AuditMiddleware(sink audit.Sink, logger log.Logger) Middleware {
+	return func(next Service) Service {
+		return auditmw{sink, logger, next}
+	}
+}
+
+type auditmw struct {
+	sink   audit.Sink
+	logger log.Logger
+	next   Service
+}
+
+func (mw auditmw) write(ctx context.Context, r audit.Record) {
+	if err := mw.sink.Write(ctx, r); err != nil {
+		level.Info(mw.logger).Log("msg", "write audit record", "event_type", r.EventType(), "err", err)
+	}
+}
@@ -11,10 +11,17 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func AddHTTPRoutes(r *mux.Router, e Endpoints, logger log.Logger) {
+func AddHTTPRoutes(r *mux.Router, e Endpoints, logger log.Logger, opts ...HTTPOption) {
+	config := httpConfig{idPolicy: PreferPayloadID}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	resolver := machineIDResolver{policy: config.idPolicy, logger: logger}
+
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorLogger(logger),
 		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerBefore(credentialsToContext, resolver.toContext),
 		httptransport.ServerAfter(
 			httptransport.SetContentType("application/json; charset=utf-8"),
 		),
@@ -111,3 +118,11 @@ func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 
 	enc.Encode(errMap)
 }
+
+// statusError is an error which is encoded with a specific HTTP status code.
+type statusError struct {
+	error
+	code int
+}
+
+func (e statusError) StatusCode() int { return e.code }
@@ -2,9 +2,15 @@ package moroz
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/push"
 	"github.com/groob/moroz/santa"
 )
 
@@ -13,32 +19,134 @@ type ConfigStore interface {
 	Config(ctx context.Context, machineID string) (santa.Config, error)
 }
 
+// MachineStore persists the inventory of machines syncing with the server.
+type MachineStore interface {
+	Machine(ctx context.Context, machineID string) (inventory.Machine, error)
+	AllMachines(ctx context.Context) ([]inventory.Machine, error)
+	SaveMachine(ctx context.Context, m inventory.Machine) error
+}
+
+// SessionStore persists sync sessions.
+type SessionStore interface {
+	Sessions(ctx context.Context, machineID string) ([]inventory.Session, error)
+	SaveSession(ctx context.Context, s inventory.Session) error
+}
+
+// Pusher delivers push notifications asking machines to sync.
+type Pusher interface {
+	// Push returns the number of notifications delivered, the first ones,
+	// when it fails partway.
+	Push(ctx context.Context, notifications []push.Notification) (int, error)
+}
+
 type SantaService struct {
-	global          santa.Config
-	repo            ConfigStore
-	eventDir        string
-	flPersistEvents bool
+	global  santa.Config
+	repo    ConfigStore
+	events  EventStore
+	dedup   Deduplicator
+	sinks   []EventSink
+	alerter Alerter
+
+	janitors []RetentionJanitor
+
+	// catalogMtx serializes the catalog updates of concurrent uploads.
+	bundles      BundleStore
+	binaries     BinaryStore
+	certificates CertificateStore
+	catalogMtx   sync.Mutex
+
+	logger   log.Logger
+	machines MachineStore
+	// machineMtx serializes the read-modify-write updates of machine records.
+	machineMtx sync.Mutex
+	sessions   SessionStore
+	pusher     Pusher
+
+	enrollment *Enrollment
+
+	// syncs holds the sync session in progress for each machine.
+	mtx   sync.RWMutex
+	syncs map[string]*inventory.Session
+}
+
+// Option configures optional SantaService behavior.
+type Option func(*SantaService)
+
+// WithLogger sets the logger used to report changes the service makes to responses.
+func WithLogger(logger log.Logger) Option {
+	return func(svc *SantaService) {
+		svc.logger = logger
+	}
+}
+
+// WithMachineStore records every preflight request in the machine inventory.
+func WithMachineStore(store MachineStore) Option {
+	return func(svc *SantaService) {
+		svc.machines = store
+	}
+}
+
+// WithSessionStore records a sync session for every sync by a machine.
+func WithSessionStore(store SessionStore) Option {
+	return func(svc *SantaService) {
+		svc.sessions = store
+	}
 }
 
-func NewService(ds ConfigStore, eventDir string, flPersistEvents bool) (*SantaService, error) {
+// WithPusher enables the push API, delivering notifications with p.
+func WithPusher(p Pusher) Option {
+	return func(svc *SantaService) {
+		svc.pusher = p
+	}
+}
+
+// NewService creates a SantaService. Uploaded events are discarded when events is nil.
+func NewService(ds ConfigStore, events EventStore, opts ...Option) (*SantaService, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	global, err := ds.Config(ctx, "global")
 	if err != nil {
 		return nil, err
 	}
-	return &SantaService{
-		global:          global,
-		repo:            ds,
-		eventDir:        eventDir,
-		flPersistEvents: flPersistEvents,
-	}, nil
+	svc := &SantaService{
+		global: global,
+		repo:   ds,
+		events: events,
+		logger: log.NewNopLogger(),
+		syncs:  make(map[string]*inventory.Session),
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if svc.enrollment != nil && svc.machines == nil {
+		return nil, errors.New("enrollment requires a machine store")
+	}
+	if svc.enrollment != nil && svc.enrollment.QuarantineConfig != "" {
+		if _, err := ds.Config(ctx, svc.enrollment.QuarantineConfig); err != nil {
+			return nil, errors.Wrap(err, "load quarantine configuration")
+		}
+	}
+	return svc, nil
+}
+
+// santaVersion returns the Santa version reported in the machine's last preflight,
+// as recorded in the machine inventory, or the zero Version if it is unknown.
+func (svc *SantaService) santaVersion(ctx context.Context, machineID string) santa.Version {
+	if svc.machines == nil {
+		return santa.Version{}
+	}
+	m, err := svc.machines.Machine(ctx, machineID)
+	if err != nil {
+		return santa.Version{}
+	}
+	version, _ := santa.ParseVersion(m.Preflight.SantaVersion)
+	return version
 }
 
 type Service interface {
 	Preflight(ctx context.Context, machineID string, p santa.PreflightPayload) (*santa.Preflight, error)
 	RuleDownload(ctx context.Context, machineID string) ([]santa.Rule, error)
-	UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) error
+	UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) (*EventUploadResult, error)
 	Postflight(ctx context.Context, machineID string, p santa.PostflightPayload) (*santa.Postflight, error)
 }
 
@@ -0,0 +1,149 @@
+package moroz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+)
+
+// sessionTimeout is how long a sync session may stay in progress before it is
+// considered abandoned.
+const sessionTimeout = time.Hour
+
+// beginSession starts a sync session for the machine. A session the machine
+// left in progress is abandoned.
+// Sessions are tracked in memory even without a SessionStore, for postflight verification.
+func (svc *SantaService) beginSession(ctx context.Context, machineID string) {
+	id, err := newSessionID()
+	if err != nil {
+		level.Info(svc.logger).Log("msg", "start sync session", "machine_id", machineID, "err", err)
+		return
+	}
+	now := time.Now().UTC()
+
+	svc.mtx.Lock()
+	prev := svc.syncs[machineID]
+	svc.syncs[machineID] = &inventory.Session{
+		ID:        id,
+		MachineID: machineID,
+		Status:    inventory.SessionInProgress,
+		StartedAt: now,
+	}
+	svc.mtx.Unlock()
+
+	if prev != nil {
+		prev.Status = inventory.SessionAbandoned
+		prev.EndedAt = now
+		svc.saveSession(ctx, *prev)
+	}
+}
+
+// updateSession applies update to the machine's session in progress, unless
+// err is set, which ends the session as errored.
+func (svc *SantaService) updateSession(ctx context.Context, machineID string, err error, update func(*inventory.Session)) {
+	svc.mtx.Lock()
+	s, ok := svc.syncs[machineID]
+	if !ok {
+		svc.mtx.Unlock()
+		level.Debug(svc.logger).Log("msg", "no sync session in progress", "machine_id", machineID)
+		return
+	}
+	if err != nil {
+		s.Status = inventory.SessionErrored
+		s.Error = err.Error()
+	} else if update != nil {
+		update(s)
+	}
+	if s.Status != inventory.SessionInProgress {
+		s.EndedAt = time.Now().UTC()
+		delete(svc.syncs, machineID)
+	}
+	sess := *s
+	svc.mtx.Unlock()
+
+	svc.saveSession(ctx, sess)
+}
+
+// currentSession returns the machine's session in progress.
+func (svc *SantaService) currentSession(machineID string) (inventory.Session, bool) {
+	svc.mtx.RLock()
+	defer svc.mtx.RUnlock()
+	s, ok := svc.syncs[machineID]
+	if !ok {
+		return inventory.Session{}, false
+	}
+	return *s, true
+}
+
+// saveSession persists s. Failing to record a session does not fail the sync.
+func (svc *SantaService) saveSession(ctx context.Context, s inventory.Session) {
+	if svc.sessions == nil {
+		return
+	}
+	if err := svc.sessions.SaveSession(ctx, s); err != nil {
+		level.Info(svc.logger).Log("msg", "save sync session", "machine_id", s.MachineID, "session_id", s.ID, "err", err)
+	}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate session ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MachineSessions returns the sync sessions of a machine, newest first.
+// Sessions left in progress for longer than an hour are reported as abandoned.
+func (svc *SantaService) MachineSessions(ctx context.Context, machineID string) ([]inventory.Session, error) {
+	if svc.sessions == nil {
+		return nil, statusError{errors.New("sync sessions are not recorded"), http.StatusNotImplemented}
+	}
+	sessions, err := svc.sessions.Sessions(ctx, machineID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for i := range sessions {
+		sessions[i].Expire(now, sessionTimeout)
+	}
+	if sessions == nil {
+		sessions = []inventory.Session{}
+	}
+	return sessions, nil
+}
+
+type machineSessionsRequest struct {
+	MachineID string
+}
+
+type machineSessionsResponse struct {
+	Sessions []inventory.Session `json:"sessions"`
+	Err      error               `json:"error,omitempty"`
+}
+
+func (r machineSessionsResponse) Failed() error { return r.Err }
+
+func makeMachineSessionsEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(machineSessionsRequest)
+		sessions, err := svc.MachineSessions(ctx, req.MachineID)
+		if err != nil {
+			return machineSessionsResponse{Err: err}, nil
+		}
+		return machineSessionsResponse{Sessions: sessions}, nil
+	}
+}
+
+func decodeMachineSessionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return machineSessionsRequest{MachineID: mux.Vars(r)["id"]}, nil
+}
@@ -0,0 +1,71 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestSyncSessions(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {
+			MachineID: "global",
+			Rules: []santa.Rule{
+				{RuleType: santa.Binary, Policy: santa.Allowlist, Identifier: "a"},
+				{RuleType: santa.Binary, Policy: santa.Blocklist, Identifier: "b"},
+			},
+		},
+	}
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(configs, nil, WithMachineStore(machines), WithSessionStore(machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first sync is abandoned by the second one.
+	if _, err := svc.Preflight(ctx, "M1", santa.PreflightPayload{SantaVersion: "2024.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Preflight(ctx, "M1", santa.PreflightPayload{SantaVersion: "2024.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RuleDownload(ctx, "M1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UploadEvent(ctx, "M1", make([]santa.EventPayload, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Postflight(ctx, "M1", santa.PostflightPayload{RulesReceived: 2, RulesProcessed: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := svc.MachineSessions(ctx, "M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("have %d sessions, want 2\n", len(sessions))
+	}
+	if have, want := sessions[1].Status, inventory.SessionAbandoned; have != want {
+		t.Errorf("have first session %s, want %s\n", have, want)
+	}
+	want := inventory.Session{
+		Status:         inventory.SessionComplete,
+		RulesSent:      2,
+		PagesServed:    1,
+		EventsReceived: 3,
+		RulesReceived:  2,
+		RulesProcessed: 2,
+	}
+	have := sessions[0]
+	have.ID, have.MachineID, have.StartedAt, have.EndedAt = "", "", want.StartedAt, want.EndedAt
+	if have != want {
+		t.Errorf("have session %+v, want %+v\n", have, want)
+	}
+}
@@ -0,0 +1,140 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/catalog"
+	"github.com/groob/moroz/santa"
+)
+
+// defaultExpiryWindow is how soon a certificate must expire to be reported as expiring.
+const defaultExpiryWindow = 30 * 24 * time.Hour
+
+// CertificateExpiryReport lists the CERTIFICATE rules of every configuration
+// whose certificate expired, or expires before ExpiringBefore.
+type CertificateExpiryReport struct {
+	ExpiringBefore time.Time               `json:"expiring_before"`
+	Expired        []CertificateRuleStatus `json:"expired"`
+	Expiring       []CertificateRuleStatus `json:"expiring"`
+	// Unknown lists the rules of certificates no event was signed with yet,
+	// so their validity isn't known.
+	Unknown []CertificateRuleStatus `json:"unknown"`
+}
+
+// CertificateRuleStatus is a CERTIFICATE rule in a CertificateExpiryReport.
+type CertificateRuleStatus struct {
+	Identifier string       `json:"identifier"`
+	Policy     santa.Policy `json:"policy"`
+	// Configs are the configurations with the rule, ie. global.
+	Configs []string `json:"configs"`
+
+	CommonName   string     `json:"cn,omitempty"`
+	Organization string     `json:"org,omitempty"`
+	ValidUntil   *time.Time `json:"valid_until,omitempty"`
+	TeamIDs      []string   `json:"team_ids,omitempty"`
+	MachineCount int        `json:"machine_count"`
+}
+
+func (svc *SantaService) CertificateExpiryReport(ctx context.Context, within time.Duration) (*CertificateExpiryReport, error) {
+	if svc.certificates == nil {
+		return nil, errNoCertificateStore
+	}
+	configs, err := svc.repo.AllConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]*CertificateRuleStatus)
+	var identifiers []string
+	for _, conf := range configs {
+		for _, rule := range conf.Rules {
+			if rule.RuleType != santa.Certificate || rule.Policy == santa.Remove {
+				continue
+			}
+			status, ok := rules[rule.Identifier]
+			if !ok {
+				status = &CertificateRuleStatus{Identifier: rule.Identifier, Policy: rule.Policy}
+				rules[rule.Identifier] = status
+				identifiers = append(identifiers, rule.Identifier)
+			}
+			if !slices.Contains(status.Configs, conf.MachineID) {
+				status.Configs = append(status.Configs, conf.MachineID)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	report := &CertificateExpiryReport{
+		ExpiringBefore: now.Add(within),
+		Expired:        []CertificateRuleStatus{},
+		Expiring:       []CertificateRuleStatus{},
+		Unknown:        []CertificateRuleStatus{},
+	}
+	sort.Strings(identifiers)
+	for _, id := range identifiers {
+		status := rules[id]
+		c, err := svc.certificates.Certificate(ctx, id)
+		if catalog.IsNotFound(err) || err == nil && c.ValidUntil.IsZero() {
+			report.Unknown = append(report.Unknown, *status)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		status.CommonName = c.CommonName
+		status.Organization = c.Organization
+		status.ValidUntil = &c.ValidUntil
+		status.TeamIDs = c.TeamIDs
+		status.MachineCount = c.MachineCount
+		switch {
+		case c.Expired(now):
+			report.Expired = append(report.Expired, *status)
+		case c.Expired(report.ExpiringBefore):
+			report.Expiring = append(report.Expiring, *status)
+		}
+	}
+	for _, statuses := range [][]CertificateRuleStatus{report.Expired, report.Expiring} {
+		sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].ValidUntil.Before(*statuses[j].ValidUntil) })
+	}
+	return report, nil
+}
+
+type certificateExpiryRequest struct {
+	Within time.Duration
+}
+
+type certificateExpiryResponse struct {
+	*CertificateExpiryReport
+	Err error `json:"error,omitempty"`
+}
+
+func (r certificateExpiryResponse) Failed() error { return r.Err }
+
+func makeCertificateExpiryEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(certificateExpiryRequest)
+		report, err := svc.CertificateExpiryReport(ctx, req.Within)
+		if err != nil {
+			return certificateExpiryResponse{Err: err}, nil
+		}
+		return certificateExpiryResponse{CertificateExpiryReport: report}, nil
+	}
+}
+
+func decodeCertificateExpiryRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := certificateExpiryRequest{Within: defaultExpiryWindow}
+	if within := r.URL.Query().Get("within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d < 0 {
+			return nil, statusError{errors.Errorf("invalid within %q, want a duration such as 720h", within), http.StatusBadRequest}
+		}
+		req.Within = d
+	}
+	return req, nil
+}
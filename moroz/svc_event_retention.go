@@ -0,0 +1,37 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+// EventRetention reports what the retention janitor of each event store pruned.
+func (svc *SantaService) EventRetention(ctx context.Context) ([]eventstore.RetentionStats, error) {
+	if len(svc.janitors) == 0 {
+		return nil, statusError{errors.New("event retention is disabled"), http.StatusNotImplemented}
+	}
+	stats := make([]eventstore.RetentionStats, 0, len(svc.janitors))
+	for _, janitor := range svc.janitors {
+		stats = append(stats, janitor.Stats())
+	}
+	return stats, nil
+}
+
+type eventRetentionResponse struct {
+	Stores []eventstore.RetentionStats `json:"stores,omitempty"`
+	Err    error                       `json:"error,omitempty"`
+}
+
+func (r eventRetentionResponse) Failed() error { return r.Err }
+
+func makeEventRetentionEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		stats, err := svc.EventRetention(ctx)
+		return eventRetentionResponse{Stores: stats, Err: err}, nil
+	}
+}
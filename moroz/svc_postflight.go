@@ -8,13 +8,71 @@ import (
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/inventory"
 	"github.com/groob/moroz/santa"
 )
 
-func (svc *SantaService) Postflight(ctx context.Context, machineID string, p santa.PostflightPayload) (*santa.Postflight, error) {
+func (svc *SantaService) Postflight(ctx context.Context, machineID string, p santa.PostflightPayload) (_ *santa.Postflight, err error) {
+	defer func() {
+		svc.updateSession(ctx, machineID, err, func(s *inventory.Session) {
+			s.RulesReceived = p.RulesReceived
+			s.RulesProcessed = p.RulesProcessed
+			s.Status = inventory.SessionComplete
+		})
+	}()
+
+	if err := svc.checkEnrolled(ctx, machineID); err != nil {
+		return nil, err
+	}
+	if err := svc.verifySync(ctx, machineID, p); err != nil {
+		return nil, err
+	}
 	return &santa.Postflight{}, nil
 }
 
+// verifySync compares the rule counts reported in postflight with the rules
+// served during the sync, and records the outcome in the machine inventory.
+// Machines which are not in the inventory are skipped.
+func (svc *SantaService) verifySync(ctx context.Context, machineID string, p santa.PostflightPayload) error {
+	healthy := p.RulesProcessed == p.RulesReceived
+	sess, ok := svc.currentSession(machineID)
+	if ok {
+		healthy = healthy && p.RulesReceived == sess.RulesSent
+	} else {
+		level.Debug(svc.logger).Log("msg", "rules sent unknown, verifying rules processed only", "machine_id", machineID)
+	}
+	if !healthy {
+		level.Warn(svc.logger).Log(
+			"msg", "postflight rule counts do not match, scheduling clean sync",
+			"machine_id", machineID,
+			"rules_sent", sess.RulesSent,
+			"rules_received", p.RulesReceived,
+			"rules_processed", p.RulesProcessed,
+		)
+	}
+
+	if svc.machines == nil {
+		return nil
+	}
+	svc.machineMtx.Lock()
+	defer svc.machineMtx.Unlock()
+	m, err := svc.machines.Machine(ctx, machineID)
+	if inventory.IsNotFound(err) {
+		// machines are added by preflight, so a postflight alone doesn't create a record.
+		level.Debug(svc.logger).Log("msg", "postflight from machine not in inventory", "machine_id", machineID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "load machine %s", machineID)
+	}
+	m.RecordSync(healthy, time.Now().UTC())
+	return errors.Wrapf(svc.machines.SaveMachine(ctx, m), "save machine %s", machineID)
+}
+
 type postflightRequest struct {
 	MachineID string
 	payload   santa.PostflightPayload
@@ -53,8 +111,9 @@ func decodePostflightRequest(ctx context.Context, r *http.Request) (interface{},
 	if err := json.NewDecoder(zr).Decode(&req.payload); err != nil {
 		return nil, err
 	}
-	if req.payload.MachineID != "" {
-		req.MachineID = req.payload.MachineID
+	req.MachineID, err = resolveMachineID(ctx, id, req.payload.MachineID)
+	if err != nil {
+		return nil, err
 	}
 	return req, nil
 }
@@ -73,3 +132,16 @@ func (mw logmw) Postflight(ctx context.Context, machineID string, p santa.Postfl
 	pf, err = mw.next.Postflight(ctx, machineID, p)
 	return
 }
+
+func (mw auditmw) Postflight(ctx context.Context, machineID string, p santa.PostflightPayload) (pf *santa.Postflight, err error) {
+	defer func(begin time.Time) {
+		r := audit.NewRecord(audit.Postflight, machineID, begin, err)
+		r["sync_type"] = p.SyncType
+		r["rules_received"] = p.RulesReceived
+		r["rules_processed"] = p.RulesProcessed
+		mw.write(ctx, r)
+	}(time.Now())
+
+	pf, err = mw.next.Postflight(ctx, machineID, p)
+	return
+}
@@ -0,0 +1,77 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestPostflightVerification(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {
+			MachineID: "global",
+			Rules: []santa.Rule{
+				{RuleType: santa.Binary, Policy: santa.Allowlist, Identifier: "a"},
+				{RuleType: santa.Binary, Policy: santa.Blocklist, Identifier: "b"},
+			},
+		},
+	}
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(configs, nil, WithMachineStore(machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sync := func(received, processed int) *santa.Preflight {
+		pre, err := svc.Preflight(ctx, "M1", santa.PreflightPayload{SantaVersion: "2024.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.RuleDownload(ctx, "M1"); err != nil {
+			t.Fatal(err)
+		}
+		p := santa.PostflightPayload{RulesReceived: received, RulesProcessed: processed}
+		if _, err := svc.Postflight(ctx, "M1", p); err != nil {
+			t.Fatal(err)
+		}
+		return pre
+	}
+
+	sync(2, 1)
+	m, err := machines.Machine(ctx, "M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SyncStatus != inventory.SyncFailed || !m.LastSuccessfulSync.IsZero() {
+		t.Errorf("have status %s and last successful sync %s, want failed and none\n", m.SyncStatus, m.LastSuccessfulSync)
+	}
+
+	if pre := sync(2, 2); pre.SyncType != santa.SyncTypeClean {
+		t.Errorf("have sync type %v after failed sync, want clean\n", pre.SyncType)
+	}
+	m, err = machines.Machine(ctx, "M1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SyncStatus != inventory.SyncHealthy || m.LastSuccessfulSync.IsZero() || m.CleanSyncScheduled {
+		t.Errorf("have machine %+v, want healthy without clean sync scheduled\n", m)
+	}
+
+	if pre := sync(2, 2); pre.SyncType == santa.SyncTypeClean {
+		t.Errorf("have clean sync after healthy sync\n")
+	}
+
+	// a postflight from a machine without a preflight doesn't add it to the inventory.
+	if _, err := svc.Postflight(ctx, "ghost", santa.PostflightPayload{RulesReceived: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machines.Machine(ctx, "ghost"); !inventory.IsNotFound(err) {
+		t.Errorf("have error %v for a machine which only sent postflight, want not found\n", err)
+	}
+}
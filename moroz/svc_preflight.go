@@ -4,24 +4,87 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/inventory"
 	"github.com/groob/moroz/santa"
 )
 
-func (svc *SantaService) Preflight(ctx context.Context, machineID string, p santa.PreflightPayload) (*santa.Preflight, error) {
+func (svc *SantaService) Preflight(ctx context.Context, machineID string, p santa.PreflightPayload) (_ *santa.Preflight, err error) {
+	svc.beginSession(ctx, machineID)
+	defer func() { svc.updateSession(ctx, machineID, err, nil) }()
+
+	if err := svc.enroll(ctx, machineID); err != nil {
+		return nil, err
+	}
 	config, err := svc.config(ctx, machineID)
 	if err != nil {
 		return nil, err
 	}
-	pre := config.Preflight
+	version, err := santa.ParseVersion(p.SantaVersion)
+	if err != nil {
+		level.Info(svc.logger).Log("msg", "unknown santa version, shaping response for a current client", "machine_id", machineID, "err", err)
+	}
+	m, err := svc.recordMachine(ctx, machineID, p)
+	if err != nil {
+		return nil, err
+	}
+
+	pre, matched, err := santa.ApplyOverrides(config.Preflight, config.Overrides, machineID, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Overrides) > 0 {
+		level.Info(svc.logger).Log(
+			"msg", "evaluated preflight overrides",
+			"machine_id", machineID,
+			"matched", strings.Join(matched, ","),
+			"preflight", pre,
+		)
+	}
+
+	if config.SantaVersions != nil && config.SantaVersions.Enforce(&pre, p.SantaVersion) {
+		level.Info(svc.logger).Log(
+			"msg", "santa version below minimum or unknown",
+			"machine_id", machineID,
+			"santa_version", p.SantaVersion,
+			"minimum", config.SantaVersions.Minimum,
+		)
+	}
+
+	if m.CleanSyncScheduled && pre.SyncType != santa.SyncTypeCleanAll {
+		level.Info(svc.logger).Log("msg", "requesting clean sync after failed sync", "machine_id", machineID)
+		pre.SyncType = santa.SyncTypeClean
+	}
+
+	pre = santa.ShapePreflight(version, pre)
 	return &pre, nil
 }
 
+// recordMachine updates the machine inventory with the preflight request,
+// and returns the updated record.
+func (svc *SantaService) recordMachine(ctx context.Context, machineID string, p santa.PreflightPayload) (inventory.Machine, error) {
+	if svc.machines == nil {
+		return inventory.Machine{MachineID: machineID}, nil
+	}
+	svc.machineMtx.Lock()
+	defer svc.machineMtx.Unlock()
+	m, err := svc.machines.Machine(ctx, machineID)
+	if err != nil && !inventory.IsNotFound(err) {
+		return m, errors.Wrapf(err, "load machine %s", machineID)
+	}
+	m.MachineID = machineID
+	m.Update(p, time.Now().UTC())
+	return m, errors.Wrapf(svc.machines.SaveMachine(ctx, m), "save machine %s", machineID)
+}
+
 type preflightRequest struct {
 	MachineID string
 	payload   santa.PreflightPayload
@@ -60,15 +123,15 @@ func decodePreflightRequest(ctx context.Context, r *http.Request) (interface{},
 	if err := json.NewDecoder(zr).Decode(&req.payload); err != nil {
 		return nil, err
 	}
-	if req.payload.MachineID != "" {
-		req.MachineID = req.payload.MachineID
+	req.MachineID, err = resolveMachineID(ctx, id, req.payload.MachineID)
+	if err != nil {
+		return nil, err
 	}
 	return req, nil
 }
 
 func (mw logmw) Preflight(ctx context.Context, machineID string, p santa.PreflightPayload) (pf *santa.Preflight, err error) {
 	defer func(begin time.Time) {
-		// Original go-kit logging
 		_ = mw.logger.Log(
 			"method", "Preflight",
 			"machine_id", machineID,
@@ -76,38 +139,35 @@ func (mw logmw) Preflight(ctx context.Context, machineID string, p santa.Preflig
 			"err", err,
 			"took", time.Since(begin),
 		)
+	}(time.Now())
 
-		// Structured JSON logging for Loki ingestion
-		preflightLog := map[string]interface{}{
-			"event_type":             "preflight",
-			"machine_id":             machineID,
-			"hostname":               p.Hostname,
-			"os_version":             p.OSVersion,
-			"os_build":               p.OSBuild,
-			"model_identifier":       p.ModelIdentifier,
-			"santa_version":          p.SantaVersion,
-			"client_mode":            p.ClientMode,
-			"serial_number":          p.SerialNumber,
-			"primary_user":           p.PrimaryUser,
-			"binary_rule_count":      p.BinaryRuleCount,
-			"certificate_rule_count": p.CertificateRuleCount,
-			"compiler_rule_count":    p.CompilerRuleCount,
-			"transitive_rule_count":  p.TransitiveRuleCount,
-			"teamid_rule_count":      p.TeamIDRuleCount,
-			"signingid_rule_count":   p.SigningIDRuleCount,
-			"cdhash_rule_count":      p.CdHashRuleCount,
-			"request_clean_sync":     p.RequestCleanSync,
-			"timestamp":              time.Now().Format(time.RFC3339),
-			"took_ms":                time.Since(begin).Milliseconds(),
-		}
-
-		if err != nil {
-			preflightLog["error"] = err.Error()
-		}
+	pf, err = mw.next.Preflight(ctx, machineID, p)
+	return
+}
 
-		if logJSON, jsonErr := json.Marshal(preflightLog); jsonErr == nil {
-			fmt.Fprintln(os.Stdout, string(logJSON))
+func (mw auditmw) Preflight(ctx context.Context, machineID string, p santa.PreflightPayload) (pf *santa.Preflight, err error) {
+	defer func(begin time.Time) {
+		r := audit.NewRecord(audit.Preflight, machineID, begin, err)
+		r["hostname"] = p.Hostname
+		r["os_version"] = p.OSVersion
+		r["os_build"] = p.OSBuild
+		r["model_identifier"] = p.ModelIdentifier
+		r["santa_version"] = p.SantaVersion
+		r["client_mode"] = p.ClientMode
+		r["serial_number"] = p.SerialNumber
+		r["primary_user"] = p.PrimaryUser
+		r["binary_rule_count"] = p.BinaryRuleCount
+		r["certificate_rule_count"] = p.CertificateRuleCount
+		r["compiler_rule_count"] = p.CompilerRuleCount
+		r["transitive_rule_count"] = p.TransitiveRuleCount
+		r["teamid_rule_count"] = p.TeamIDRuleCount
+		r["signingid_rule_count"] = p.SigningIDRuleCount
+		r["cdhash_rule_count"] = p.CdHashRuleCount
+		r["request_clean_sync"] = p.RequestCleanSync
+		if pf != nil {
+			r["response_client_mode"] = pf.ClientMode
 		}
+		mw.write(ctx, r)
 	}(time.Now())
 
 	pf, err = mw.next.Preflight(ctx, machineID, p)
@@ -0,0 +1,118 @@
+package moroz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/push"
+)
+
+// PushTarget selects the machines asked to sync. Exactly one field must be set.
+type PushTarget struct {
+	MachineID string `json:"machine_id,omitempty"`
+	Group     string `json:"group,omitempty"`
+	All       bool   `json:"all,omitempty"`
+}
+
+// PushResult lists the machines a push notification was sent to, and the
+// targeted machines which never reported a push token. When the gateway fails
+// partway, Failed lists the machines which were not notified.
+type PushResult struct {
+	Pushed  []string `json:"pushed"`
+	NoToken []string `json:"no_token,omitempty"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+func (svc *SantaService) Push(ctx context.Context, target PushTarget) (*PushResult, error) {
+	if svc.pusher == nil {
+		return nil, statusError{errors.New("push notifications are not configured"), http.StatusNotImplemented}
+	}
+	if svc.machines == nil {
+		return nil, errNoMachineStore
+	}
+
+	var (
+		machines []inventory.Machine
+		err      error
+	)
+	switch {
+	case target.MachineID != "" && target.Group == "" && !target.All:
+		var m inventory.Machine
+		m, err = svc.machines.Machine(ctx, target.MachineID)
+		if inventory.IsNotFound(err) {
+			return nil, statusError{errors.Errorf("machine %q not found", target.MachineID), http.StatusNotFound}
+		}
+		machines = []inventory.Machine{m}
+	case target.Group != "" && target.MachineID == "" && !target.All:
+		machines, err = svc.groupMembers(ctx, target.Group)
+	case target.All && target.MachineID == "" && target.Group == "":
+		machines, err = svc.machines.AllMachines(ctx)
+	default:
+		return nil, statusError{errors.New("exactly one of machine_id, group or all must be set"), http.StatusBadRequest}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PushResult{Pushed: []string{}}
+	var notifications []push.Notification
+	for _, m := range machines {
+		if m.PushToken == "" {
+			result.NoToken = append(result.NoToken, m.MachineID)
+			continue
+		}
+		notifications = append(notifications, push.Notification{MachineID: m.MachineID, Token: m.PushToken})
+	}
+	sent, err := svc.pusher.Push(ctx, notifications)
+	if err != nil && sent == 0 {
+		return nil, errors.Wrap(err, "push notifications")
+	}
+	for i, n := range notifications {
+		if i < sent {
+			result.Pushed = append(result.Pushed, n.MachineID)
+		} else {
+			result.Failed = append(result.Failed, n.MachineID)
+		}
+	}
+	if err != nil {
+		level.Info(svc.logger).Log("msg", "push notifications", "pushed", sent, "failed", len(result.Failed), "err", err)
+	}
+	return result, nil
+}
+
+type pushRequest struct {
+	Target PushTarget
+}
+
+type pushResponse struct {
+	*PushResult
+	Err error `json:"error,omitempty"`
+}
+
+func (r pushResponse) Failed() error { return r.Err }
+
+func makePushEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(pushRequest)
+		result, err := svc.Push(ctx, req.Target)
+		if err != nil {
+			return pushResponse{Err: err}, nil
+		}
+		return pushResponse{PushResult: result}, nil
+	}
+}
+
+func decodePushRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	defer r.Body.Close()
+	var req pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req.Target); err != nil {
+		return nil, statusError{errors.Wrap(err, "decode push request"), http.StatusBadRequest}
+	}
+	return req, nil
+}
@@ -0,0 +1,119 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/push"
+	"github.com/groob/moroz/santa"
+)
+
+type staticConfigs map[string]santa.Config
+
+func (s staticConfigs) AllConfigs(ctx context.Context) ([]santa.Config, error) {
+	var configs []santa.Config
+	for _, conf := range s {
+		configs = append(configs, conf)
+	}
+	return configs, nil
+}
+
+func (s staticConfigs) Config(ctx context.Context, machineID string) (santa.Config, error) {
+	conf, ok := s[machineID]
+	if !ok {
+		return conf, errors.Errorf("configuration %q not found", machineID)
+	}
+	return conf, nil
+}
+
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {
+			MachineID: "global",
+			Groups: map[string]santa.Condition{
+				"laptops": {ModelIdentifier: "^MacBook"},
+			},
+		},
+	}
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher := new(push.Fake)
+	svc, err := NewService(configs, nil, WithMachineStore(machines), WithPusher(pusher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preflights := map[string]santa.PreflightPayload{
+		"laptop":    {ModelIdentifier: "MacBookPro18,1", PushNotificationToken: "token-laptop"},
+		"desktop":   {ModelIdentifier: "Mac14,13", PushNotificationToken: "token-desktop"},
+		"tokenless": {ModelIdentifier: "MacBookAir10,1"},
+	}
+	for id, p := range preflights {
+		if _, err := svc.Preflight(ctx, id, p); err != nil {
+			t.Fatalf("preflight %s: %s", id, err)
+		}
+	}
+
+	result, err := svc.Push(ctx, PushTarget{Group: "laptops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(result.Pushed), 1; have != want {
+		t.Errorf("have %d machines pushed, want %d\n", have, want)
+	}
+	if have, want := len(result.NoToken), 1; have != want {
+		t.Errorf("have %d machines without token, want %d\n", have, want)
+	}
+	if pushed := pusher.Pushed(); len(pushed) != 1 || pushed[0].Token != "token-laptop" {
+		t.Errorf("have pushed %+v, want token-laptop\n", pushed)
+	}
+
+	if _, err := svc.Push(ctx, PushTarget{All: true}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pusher.Pushed()), 3; have != want {
+		t.Errorf("have %d notifications, want %d\n", have, want)
+	}
+
+	if _, err := svc.Push(ctx, PushTarget{MachineID: "laptop", All: true}); err == nil {
+		t.Errorf("expected error for ambiguous push target\n")
+	}
+}
+
+func TestPushPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher := &push.Fake{Err: errors.New("gateway unavailable"), Accept: 1}
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, nil, WithMachineStore(machines), WithPusher(pusher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"M1", "M2"} {
+		if _, err := svc.Preflight(ctx, id, santa.PreflightPayload{PushNotificationToken: "token-" + id}); err != nil {
+			t.Fatalf("preflight %s: %s", id, err)
+		}
+	}
+
+	// the machines notified before the failure are reported, so they aren't pushed again.
+	result, err := svc.Push(ctx, PushTarget{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pushed) != 1 || len(result.Failed) != 1 || result.Pushed[0] == result.Failed[0] {
+		t.Errorf("have result %+v, want one machine pushed and one failed\n", result)
+	}
+
+	pusher.Accept = 0
+	if _, err := svc.Push(ctx, PushTarget{All: true}); err == nil {
+		t.Error("push which reached no machine succeeded")
+	}
+}
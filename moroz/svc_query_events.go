@@ -0,0 +1,91 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+func (svc *SantaService) QueryEvents(ctx context.Context, q eventstore.Query) (*eventstore.Page, error) {
+	if svc.events == nil {
+		return nil, statusError{errors.New("event persistence is disabled"), http.StatusNotImplemented}
+	}
+	page, err := svc.events.Query(ctx, q)
+	if errors.Cause(err) == eventstore.ErrInvalidCursor {
+		return nil, statusError{err, http.StatusBadRequest}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+type queryEventsRequest struct {
+	Query eventstore.Query
+}
+
+type queryEventsResponse struct {
+	*eventstore.Page
+	Err error `json:"error,omitempty"`
+}
+
+func (r queryEventsResponse) Failed() error { return r.Err }
+
+func makeQueryEventsEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(queryEventsRequest)
+		page, err := svc.QueryEvents(ctx, req.Query)
+		if err != nil {
+			return queryEventsResponse{Err: err}, nil
+		}
+		return queryEventsResponse{Page: page}, nil
+	}
+}
+
+func decodeQueryEventsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	v := r.URL.Query()
+	q := eventstore.Query{
+		MachineID:     v.Get("machine_id"),
+		SHA256:        v.Get("sha256"),
+		Decision:      v.Get("decision"),
+		TeamID:        v.Get("team_id"),
+		SigningID:     v.Get("signing_id"),
+		ExecutingUser: v.Get("executing_user"),
+		CertSHA256:    v.Get("cert_sha256"),
+		Cursor:        v.Get("cursor"),
+	}
+	var err error
+	if q.Since, err = parseQueryTime(v.Get("since")); err != nil {
+		return nil, statusError{errors.Wrap(err, "since"), http.StatusBadRequest}
+	}
+	if q.Until, err = parseQueryTime(v.Get("until")); err != nil {
+		return nil, statusError{errors.Wrap(err, "until"), http.StatusBadRequest}
+	}
+	if limit := v.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			return nil, statusError{errors.Errorf("invalid limit %q", limit), http.StatusBadRequest}
+		}
+	}
+	return queryEventsRequest{Query: q}, nil
+}
+
+// parseQueryTime accepts RFC 3339 times and unix timestamps in seconds.
+func parseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q, want RFC 3339 or unix seconds", s)
+	}
+	return t, nil
+}
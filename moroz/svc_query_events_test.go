@@ -0,0 +1,86 @@
+package moroz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/groob/moroz/eventstore"
+)
+
+func TestDecodeQueryEventsRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  eventstore.Query
+		err   bool
+	}{
+		{name: "empty", query: "", want: eventstore.Query{}},
+		{
+			name:  "filters",
+			query: "machine_id=M1&sha256=aaa&decision=BLOCK_BINARY&team_id=T1&signing_id=S1&executing_user=bob&cert_sha256=ccc&cursor=10",
+			want: eventstore.Query{
+				MachineID: "M1", SHA256: "aaa", Decision: "BLOCK_BINARY", TeamID: "T1",
+				SigningID: "S1", ExecutingUser: "bob", CertSHA256: "ccc", Cursor: "10",
+			},
+		},
+		{
+			name:  "unix times",
+			query: "since=1700000000&until=1700000000.5",
+			want:  eventstore.Query{Since: time.Unix(1700000000, 0).UTC(), Until: time.Unix(1700000000, 5e8).UTC()},
+		},
+		{
+			name:  "rfc 3339 times",
+			query: "since=2024-01-02T03:04:05Z",
+			want:  eventstore.Query{Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+		{name: "limit", query: "limit=20", want: eventstore.Query{Limit: 20}},
+		{name: "bad since", query: "since=yesterday", err: true},
+		{name: "bad until", query: "until=2024-01-02", err: true},
+		{name: "bad limit", query: "limit=ten", err: true},
+		{name: "negative limit", query: "limit=-1", err: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/events?"+tt.query, nil)
+		req, err := decodeQueryEventsRequest(context.Background(), r)
+		if tt.err {
+			if !isStatus(err, http.StatusBadRequest) {
+				t.Errorf("%s: have error %v, want a bad request\n", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s\n", tt.name, err)
+			continue
+		}
+		if have := req.(queryEventsRequest).Query; !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%s: have query %+v, want %+v\n", tt.name, have, tt.want)
+		}
+	}
+}
+
+func TestQueryEventsCursor(t *testing.T) {
+	ctx := context.Background()
+	events, err := eventstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.QueryEvents(ctx, eventstore.Query{Cursor: "not-a-cursor"}); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("have error %v for an invalid cursor, want a bad request\n", err)
+	}
+
+	svc, err = NewService(staticConfigs{"global": {MachineID: "global"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.QueryEvents(ctx, eventstore.Query{}); !isStatus(err, http.StatusNotImplemented) {
+		t.Errorf("have error %v without an event store, want not implemented\n", err)
+	}
+}
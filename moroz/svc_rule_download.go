@@ -11,15 +11,54 @@ import (
 	"compress/zlib"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/inventory"
 	"github.com/groob/moroz/santa"
 )
 
-func (svc *SantaService) RuleDownload(ctx context.Context, machineID string) ([]santa.Rule, error) {
+func (svc *SantaService) RuleDownload(ctx context.Context, machineID string) (rules []santa.Rule, err error) {
+	defer func() {
+		svc.updateSession(ctx, machineID, err, func(s *inventory.Session) {
+			s.PagesServed++
+			s.RulesSent += len(rules)
+		})
+	}()
+
 	config, err := svc.config(ctx, machineID)
-	return config.Rules, err
+	if err != nil {
+		return nil, err
+	}
+	version := svc.santaVersion(ctx, machineID)
+	rules, dropped := santa.ShapeRules(version, config.Rules)
+	if len(dropped) > 0 {
+		droppedByFeature := make(map[santa.Feature]int)
+		for _, rule := range dropped {
+			f, _ := rule.RequiredFeature()
+			droppedByFeature[f]++
+		}
+		for f, count := range droppedByFeature {
+			level.Info(svc.logger).Log(
+				"msg", "dropped rules unsupported by client",
+				"machine_id", machineID,
+				"santa_version", version,
+				"feature", f,
+				"count", count,
+			)
+		}
+	}
+	return rules, nil
 }
 
 func (svc *SantaService) config(ctx context.Context, machineID string) (santa.Config, error) {
+	enrolled, err := svc.enrolled(ctx, machineID)
+	if err != nil {
+		return santa.Config{}, err
+	}
+	if !enrolled {
+		return svc.quarantineConfig(ctx)
+	}
+
 	// try the machine ID config first, and if that fails return the global config instead
 	if config, err := svc.repo.Config(ctx, machineID); err == nil {
 		return config, nil
@@ -64,32 +103,31 @@ func decodeRuleRequest(ctx context.Context, r *http.Request) (interface{}, error
 
 	// Optional JSON payload (may be zlib-compressed) that carries cursor/machine_id.
 	// We accept empty bodies for backward compatibility.
-	bodyBytes, _ := io.ReadAll(r.Body)
-	if len(bodyBytes) == 0 {
-		return req, nil
-	}
-
-	payload := bodyBytes
-	if zr, zerr := zlib.NewReader(bytes.NewReader(bodyBytes)); zerr == nil {
-		defer zr.Close()
-		if decompressed, derr := io.ReadAll(zr); derr == nil {
-			payload = decompressed
-		}
-	}
-
-	var body struct {
+	type ruleRequestBody struct {
 		Cursor    string `json:"cursor"`
 		MachineID string `json:"machine_id"`
 	}
-	if jerr := json.Unmarshal(payload, &body); jerr == nil {
-		if body.Cursor != "" {
-			req.Cursor = body.Cursor
+	var body ruleRequestBody
+	bodyBytes, _ := io.ReadAll(r.Body)
+	if len(bodyBytes) > 0 {
+		payload := bodyBytes
+		if zr, zerr := zlib.NewReader(bytes.NewReader(bodyBytes)); zerr == nil {
+			defer zr.Close()
+			if decompressed, derr := io.ReadAll(zr); derr == nil {
+				payload = decompressed
+			}
 		}
-		if body.MachineID != "" {
-			req.MachineID = body.MachineID
+		var decoded ruleRequestBody
+		if jerr := json.Unmarshal(payload, &decoded); jerr == nil {
+			body = decoded
+			req.Cursor = body.Cursor
 		}
 	}
 
+	req.MachineID, err = resolveMachineID(ctx, id, body.MachineID)
+	if err != nil {
+		return nil, err
+	}
 	return req, nil
 }
 
@@ -106,3 +144,14 @@ func (mw logmw) RuleDownload(ctx context.Context, machineID string) (rules []san
 	rules, err = mw.next.RuleDownload(ctx, machineID)
 	return
 }
+
+func (mw auditmw) RuleDownload(ctx context.Context, machineID string) (rules []santa.Rule, err error) {
+	defer func(begin time.Time) {
+		r := audit.NewRecord(audit.RuleDownload, machineID, begin, err)
+		r["rules_sent"] = len(rules)
+		mw.write(ctx, r)
+	}(time.Now())
+
+	rules, err = mw.next.RuleDownload(ctx, machineID)
+	return
+}
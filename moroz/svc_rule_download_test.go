@@ -0,0 +1,53 @@
+package moroz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestRuleDownloadVersion(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {MachineID: "global", Rules: []santa.Rule{
+			{RuleType: santa.Binary, Policy: santa.Allowlist, Identifier: "aaa"},
+			{RuleType: santa.TeamID, Policy: santa.Allowlist, Identifier: "EQHXZ8M8AV"},
+		}},
+	}
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(configs, nil, WithMachineStore(machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the version is read from the inventory, so it survives a restart.
+	if _, err := svc.Preflight(ctx, "M1", santa.PreflightPayload{SantaVersion: "2021.5"}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err = NewService(configs, nil, WithMachineStore(machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		machineID string
+		want      int
+	}{
+		{"M1", 1},
+		// a machine without a preflight is treated as a current client.
+		{"M2", 2},
+	}
+	for _, tt := range tests {
+		rules, err := svc.RuleDownload(ctx, tt.machineID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != tt.want {
+			t.Errorf("%s: have %d rules, want %d\n", tt.machineID, len(rules), tt.want)
+		}
+	}
+}
@@ -4,40 +4,78 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 
+	"github.com/groob/moroz/audit"
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/inventory"
 	"github.com/groob/moroz/santa"
 )
 
-func (svc *SantaService) UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) error {
-	if !svc.flPersistEvents {
-		return nil
+// EventUploadResult reports how the events of an upload were ingested.
+type EventUploadResult struct {
+	Accepted   int
+	Duplicates int
+	// BundleBinaries are the hashes of the bundles the machine is asked to
+	// upload every binary of.
+	BundleBinaries []string
+}
+
+func (svc *SantaService) UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) (result *EventUploadResult, err error) {
+	defer func() {
+		svc.updateSession(ctx, machineID, err, func(s *inventory.Session) {
+			s.EventsReceived += len(events)
+			s.EventsDuplicate += result.Duplicates
+		})
+	}()
+
+	if err := svc.checkEnrolled(ctx, machineID); err != nil {
+		return nil, err
 	}
+	now := time.Now().UTC()
+	stored := make([]eventstore.Event, 0, len(events))
 	for _, ev := range events {
-		eventDir := filepath.Join(svc.eventDir, ev.FileSHA, machineID)
-		if err := os.MkdirAll(eventDir, 0750); err != nil {
-			return errors.Wrapf(err, "create event directory %s", eventDir)
-		}
-
-		eventPath := filepath.Join(eventDir, fmt.Sprintf("%f.json", ev.UnixTime))
+		stored = append(stored, eventstore.Event{
+			MachineID:  machineID,
+			ReceivedAt: now,
+			Event:      ev.EventInfo,
+		})
+	}
 
-		eventInfoJSON, err := json.Marshal(ev.EventInfo)
-		if err != nil {
-			return errors.Wrap(err, "marshal event info to json")
+	result = &EventUploadResult{}
+	if svc.dedup != nil {
+		stored, result.Duplicates = svc.dedup.Filter(stored)
+	}
+	result.Accepted = len(stored)
+	if len(stored) == 0 {
+		return result, nil
+	}
+	if svc.events != nil {
+		if err := svc.events.Put(ctx, stored); err != nil {
+			if svc.dedup != nil {
+				svc.dedup.Release(stored)
+			}
+			return nil, errors.Wrap(err, "store events")
 		}
-
-		if err := os.WriteFile(eventPath, append(eventInfoJSON, '\n'), 0644); err != nil {
-			return errors.Wrapf(err, "write event to path %s", eventPath)
+	}
+	svc.tagMachine(ctx, machineID, stored)
+	svc.forwardEvents(ctx, machineID, stored)
+	svc.evaluateAlerts(ctx, machineID, stored)
+	svc.recordBinaries(ctx, machineID, stored)
+	svc.recordCertificates(ctx, machineID, stored)
+	result.BundleBinaries = svc.recordBundles(ctx, machineID, stored)
+	if svc.dedup != nil {
+		// the events are stored, so a retry after an error would store them twice.
+		if err := svc.dedup.Record(stored); err != nil {
+			level.Info(svc.logger).Log("msg", "record event fingerprints", "machine_id", machineID, "err", err)
 		}
 	}
-	return nil
+	return result, nil
 }
 
 type eventRequest struct {
@@ -55,9 +93,13 @@ func (r eventResponse) Failed() error { return r.Err }
 func makeEventUploadEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(eventRequest)
-		err := svc.UploadEvent(ctx, req.MachineID, req.events)
+		result, err := svc.UploadEvent(ctx, req.MachineID, req.events)
+		resp := &santa.EventUploadResponse{}
+		if result != nil {
+			resp.EventUploadBundleBinaries = result.BundleBinaries
+		}
 		return eventResponse{
-			EventUploadResponse: &santa.EventUploadResponse{},
+			EventUploadResponse: resp,
 			Err:                 err,
 		}, nil
 	}
@@ -92,16 +134,16 @@ func decodeEventUpload(ctx context.Context, r *http.Request) (interface{}, error
 		events = append(events, payload)
 	}
 
-	reqID := id
-	if eventPayload.MachineID != "" {
-		reqID = eventPayload.MachineID
+	reqID, err := resolveMachineID(ctx, id, eventPayload.MachineID)
+	if err != nil {
+		return nil, err
 	}
 
 	req := eventRequest{MachineID: reqID, events: events}
 	return req, nil
 }
 
-func (mw logmw) UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) (err error) {
+func (mw logmw) UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) (result *EventUploadResult, err error) {
 	defer func(begin time.Time) {
 		for _, ev := range events {
 			_ = mw.logger.Log(
@@ -114,6 +156,29 @@ func (mw logmw) UploadEvent(ctx context.Context, machineID string, events []sant
 		}
 	}(time.Now())
 
-	err = mw.next.UploadEvent(ctx, machineID, events)
+	result, err = mw.next.UploadEvent(ctx, machineID, events)
+	return
+}
+
+func (mw auditmw) UploadEvent(ctx context.Context, machineID string, events []santa.EventPayload) (result *EventUploadResult, err error) {
+	defer func(begin time.Time) {
+		decisions := make(map[string]int)
+		for _, ev := range events {
+			decisions[ev.EventInfo.Decision]++
+		}
+		r := audit.NewRecord(audit.EventUpload, machineID, begin, err)
+		r["event_count"] = len(events)
+		r["decisions"] = decisions
+		if result != nil {
+			r["events_accepted"] = result.Accepted
+			r["events_duplicate"] = result.Duplicates
+			if len(result.BundleBinaries) > 0 {
+				r["bundles_requested"] = result.BundleBinaries
+			}
+		}
+		mw.write(ctx, r)
+	}(time.Now())
+
+	result, err = mw.next.UploadEvent(ctx, machineID, events)
 	return
 }
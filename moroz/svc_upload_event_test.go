@@ -0,0 +1,101 @@
+package moroz
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/eventstore"
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestUploadEventDedupRetry(t *testing.T) {
+	ctx := context.Background()
+	store, err := eventstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &failingEventStore{EventStore: store, fail: true}
+	dedup, err := eventstore.NewDedup(filepath.Join(t.TempDir(), "fingerprints"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dedup.Close()
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, events, WithDeduplicator(dedup))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := []santa.EventPayload{{EventInfo: santa.EventUploadEvent{FileSHA256: "aaa", ExecutionTime: 100}}}
+	if _, err := svc.UploadEvent(ctx, "M1", upload); err == nil {
+		t.Fatal("upload stored without an error")
+	}
+
+	// the retry isn't dropped as a duplicate of the failed upload.
+	events.fail = false
+	result, err := svc.UploadEvent(ctx, "M1", upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Accepted != 1 || result.Duplicates != 0 {
+		t.Errorf("have %d accepted and %d duplicates on retry, want 1 and 0\n", result.Accepted, result.Duplicates)
+	}
+
+	result, err = svc.UploadEvent(ctx, "M1", upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Accepted != 0 || result.Duplicates != 1 {
+		t.Errorf("have %d accepted and %d duplicates on a second retry, want 0 and 1\n", result.Accepted, result.Duplicates)
+	}
+}
+
+// failingEventStore fails to store events while fail is set.
+type failingEventStore struct {
+	EventStore
+	fail bool
+}
+
+func (s *failingEventStore) Put(ctx context.Context, events []eventstore.Event) error {
+	if s.fail {
+		return errors.New("store unavailable")
+	}
+	return s.EventStore.Put(ctx, events)
+}
+
+func TestUploadEventTagMachine(t *testing.T) {
+	ctx := context.Background()
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingSink{}
+	svc, err := NewService(staticConfigs{"global": {MachineID: "global"}}, nil, WithMachineStore(machines), WithEventSinks(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Preflight(ctx, "M1", santa.PreflightPayload{Hostname: "mac-01", ClientMode: santa.Lockdown}); err != nil {
+		t.Fatal(err)
+	}
+	upload := []santa.EventPayload{{EventInfo: santa.EventUploadEvent{FileSHA256: "aaa", Decision: "BLOCK_BINARY"}}}
+	if _, err := svc.UploadEvent(ctx, "M1", upload); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 1 || sink.events[0].Hostname != "mac-01" || sink.events[0].ClientMode != "LOCKDOWN" {
+		t.Errorf("have forwarded events %+v, want the hostname and client mode of M1\n", sink.events)
+	}
+}
+
+// recordingSink keeps the events written to it.
+type recordingSink struct {
+	events []eventstore.Event
+}
+
+func (s *recordingSink) WriteEvents(ctx context.Context, events []eventstore.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
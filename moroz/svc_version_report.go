@@ -0,0 +1,86 @@
+package moroz
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/groob/moroz/santa"
+)
+
+// SantaVersionReport groups the machine inventory by Santa version band.
+type SantaVersionReport struct {
+	Minimum     string                                 `json:"minimum,omitempty"`
+	Recommended string                                 `json:"recommended,omitempty"`
+	Bands       map[santa.VersionBand][]MachineVersion `json:"bands"`
+}
+
+// MachineVersion is a machine entry in a SantaVersionReport.
+type MachineVersion struct {
+	MachineID    string    `json:"machine_id"`
+	Hostname     string    `json:"hostname"`
+	SantaVersion string    `json:"santa_version"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+func (svc *SantaService) SantaVersionReport(ctx context.Context) (*SantaVersionReport, error) {
+	if svc.machines == nil {
+		return nil, errNoMachineStore
+	}
+	machines, err := svc.machines.AllMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := svc.repo.AllConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]santa.VersionPolicy, len(configs))
+	for _, conf := range configs {
+		if conf.SantaVersions != nil {
+			policies[conf.MachineID] = *conf.SantaVersions
+		} else {
+			policies[conf.MachineID] = santa.VersionPolicy{}
+		}
+	}
+
+	global := policies["global"]
+	report := &SantaVersionReport{
+		Minimum:     global.Minimum,
+		Recommended: global.Recommended,
+		Bands:       make(map[santa.VersionBand][]MachineVersion),
+	}
+	for _, m := range machines {
+		// machines with their own configuration are held to its policy, like in Preflight.
+		policy, ok := policies[m.MachineID]
+		if !ok {
+			policy = global
+		}
+		band := policy.Band(m.Preflight.SantaVersion)
+		report.Bands[band] = append(report.Bands[band], MachineVersion{
+			MachineID:    m.MachineID,
+			Hostname:     m.Preflight.Hostname,
+			SantaVersion: m.Preflight.SantaVersion,
+			LastSeen:     m.LastSeen,
+		})
+	}
+	return report, nil
+}
+
+type santaVersionReportResponse struct {
+	*SantaVersionReport
+	Err error `json:"error,omitempty"`
+}
+
+func (r santaVersionReportResponse) Failed() error { return r.Err }
+
+func makeSantaVersionReportEndpoint(svc AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		report, err := svc.SantaVersionReport(ctx)
+		if err != nil {
+			return santaVersionReportResponse{Err: err}, nil
+		}
+		return santaVersionReportResponse{SantaVersionReport: report}, nil
+	}
+}
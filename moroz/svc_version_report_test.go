@@ -0,0 +1,61 @@
+package moroz
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/groob/moroz/inventory"
+	"github.com/groob/moroz/santa"
+)
+
+func TestSantaVersionReport(t *testing.T) {
+	ctx := context.Background()
+	configs := staticConfigs{
+		"global": {MachineID: "global", SantaVersions: &santa.VersionPolicy{Minimum: "2024.1", Recommended: "2025.6"}},
+		"M4":     {MachineID: "M4"},
+	}
+	machines, err := inventory.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(configs, nil, WithMachineStore(machines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := map[string]string{"M1": "2023.9", "M2": "2025.6", "M3": "", "M4": "2023.1", "M5": "2024.9"}
+	for id, version := range versions {
+		pre, err := svc.Preflight(ctx, id, santa.PreflightPayload{SantaVersion: version})
+		if err != nil {
+			t.Fatalf("preflight %s: %s", id, err)
+		}
+		enforced := pre.EventDetailText != ""
+		if want := id == "M1" || id == "M3"; enforced != want {
+			t.Errorf("preflight %s with santa %q: have minimum enforced %t, want %t\n", id, version, enforced, want)
+		}
+	}
+
+	report, err := svc.SantaVersionReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// M4 is held to its own configuration, which has no version policy.
+	want := map[santa.VersionBand][]string{
+		santa.BandBelowMinimum:     {"M1"},
+		santa.BandCurrent:          {"M2", "M4"},
+		santa.BandUnknown:          {"M3"},
+		santa.BandBelowRecommended: {"M5"},
+	}
+	for band, ids := range want {
+		var have []string
+		for _, m := range report.Bands[band] {
+			have = append(have, m.MachineID)
+		}
+		sort.Strings(have)
+		if strings.Join(have, ",") != strings.Join(ids, ",") {
+			t.Errorf("have %s band %v, want %v\n", band, have, ids)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package push
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake records notifications in memory instead of delivering them.
+// It is meant for tests and for running moroz without a push gateway.
+type Fake struct {
+	mtx    sync.Mutex
+	pushed []Notification
+
+	// Err, when set, is returned by Push, after the first Accept
+	// notifications of the call were delivered.
+	Err    error
+	Accept int
+}
+
+func (f *Fake) Push(ctx context.Context, notifications []Notification) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.Err != nil {
+		if len(notifications) > f.Accept {
+			notifications = notifications[:f.Accept]
+		}
+		f.pushed = append(f.pushed, notifications...)
+		return len(notifications), f.Err
+	}
+	f.pushed = append(f.pushed, notifications...)
+	return len(notifications), nil
+}
+
+// Pushed returns every notification received so far.
+func (f *Fake) Pushed() []Notification {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]Notification(nil), f.pushed...)
+}
@@ -0,0 +1,79 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// maxBatch is the largest number of tokens sent in one request,
+// matching the FCM multicast limit.
+const maxBatch = 500
+
+// HTTPPusher posts notifications as JSON to a push gateway, which is
+// responsible for relaying them to the push service used by Santa.
+//
+// The request body is {"notifications": [{"machine_id": "...", "token": "..."}]}.
+type HTTPPusher struct {
+	URL string
+
+	// Token, when set, is sent in an `Authorization: Bearer` header.
+	Token string
+
+	Client *http.Client
+}
+
+// Push posts the notifications in batches of 500. It stops at the first
+// batch the gateway doesn't accept, and returns the number of notifications
+// sent in the batches before it.
+func (p *HTTPPusher) Push(ctx context.Context, notifications []Notification) (int, error) {
+	var sent int
+	for sent < len(notifications) {
+		n := len(notifications) - sent
+		if n > maxBatch {
+			n = maxBatch
+		}
+		if err := p.post(ctx, notifications[sent:sent+n]); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+func (p *HTTPPusher) post(ctx context.Context, notifications []Notification) error {
+	body, err := json.Marshal(struct {
+		Notifications []Notification `json:"notifications"`
+	}{notifications})
+	if err != nil {
+		return errors.Wrap(err, "marshal push notifications")
+	}
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create push request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send push request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("push gateway returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
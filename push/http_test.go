@@ -0,0 +1,103 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHTTPPusher(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		batches []int
+		auth    []string
+		fail    int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		var body struct {
+			Notifications []Notification `json:"notifications"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode push request: %s\n", err)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("have content type %q, want application/json\n", ct)
+		}
+		auth = append(auth, r.Header.Get("Authorization"))
+		batches = append(batches, len(body.Notifications))
+		if fail > 0 && len(batches) == fail {
+			http.Error(w, "token quota exceeded", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	notifications := make([]Notification, 1201)
+	for i := range notifications {
+		id := strconv.Itoa(i)
+		notifications[i] = Notification{MachineID: "M" + id, Token: "token-" + id}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		fail    int
+		sent    int
+		batches []int
+		auth    string
+	}{
+		{name: "batches", token: "secret", sent: 1201, batches: []int{500, 500, 201}, auth: "Bearer secret"},
+		{name: "no token", sent: 1201, batches: []int{500, 500, 201}, auth: ""},
+		{name: "second batch fails", token: "secret", fail: 2, sent: 500, batches: []int{500, 500}, auth: "Bearer secret"},
+		{name: "first batch fails", fail: 1, sent: 0, batches: []int{500}},
+	}
+	for _, tt := range tests {
+		mtx.Lock()
+		batches, auth, fail = nil, nil, tt.fail
+		mtx.Unlock()
+
+		p := &HTTPPusher{URL: srv.URL, Token: tt.token, Client: srv.Client()}
+		sent, err := p.Push(context.Background(), notifications)
+		if (err != nil) != (tt.fail > 0) {
+			t.Errorf("%s: have error %v, want error %t\n", tt.name, err, tt.fail > 0)
+		}
+		if sent != tt.sent {
+			t.Errorf("%s: have %d notifications sent, want %d\n", tt.name, sent, tt.sent)
+		}
+
+		mtx.Lock()
+		if len(batches) != len(tt.batches) {
+			t.Errorf("%s: have batches %v, want %v\n", tt.name, batches, tt.batches)
+		}
+		for i := range batches {
+			if i < len(tt.batches) && batches[i] != tt.batches[i] {
+				t.Errorf("%s: have batches %v, want %v\n", tt.name, batches, tt.batches)
+				break
+			}
+		}
+		for _, have := range auth {
+			if have != tt.auth {
+				t.Errorf("%s: have authorization %q, want %q\n", tt.name, have, tt.auth)
+			}
+		}
+		mtx.Unlock()
+	}
+}
+
+func TestHTTPPusherError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	p := &HTTPPusher{URL: srv.URL, Client: srv.Client()}
+	_, err := p.Push(context.Background(), []Notification{{MachineID: "M1", Token: "token"}})
+	if err == nil || err.Error() != "push gateway returned 400 Bad Request: invalid token" {
+		t.Errorf("have error %v, want the gateway status and message\n", err)
+	}
+}
@@ -0,0 +1,12 @@
+// Package push delivers "sync now" notifications to Santa clients.
+//
+// Pushers return the number of notifications they delivered along with an
+// error, so a push which fails partway reports the machines it reached: the
+// first notifications, up to that number.
+package push
+
+// Notification asks a single machine to sync.
+type Notification struct {
+	MachineID string `json:"machine_id"`
+	Token     string `json:"token"`
+}
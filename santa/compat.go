@@ -0,0 +1,114 @@
+package santa
+
+// Feature is a part of the sync protocol that only some Santa releases understand.
+type Feature int
+
+const (
+	// FeatureAllowlistNames covers the allowlist/blocklist renaming of preflight fields
+	// (allowed_path_regex, enable_bundles, enable_transitive_rules, push_notification_*).
+	// Older clients only read the whitelist/blacklist/fcm names.
+	FeatureAllowlistNames Feature = iota
+	FeatureTeamIDRules
+	FeatureSigningIDRules
+	FeatureCdHashRules
+	FeatureSyncType
+	FeatureCELRules
+)
+
+// CompatTable lists the first Santa release supporting each Feature.
+var CompatTable = map[Feature]Version{
+	FeatureAllowlistNames: {Major: 2021, Minor: 1},
+	FeatureTeamIDRules:    {Major: 2022, Minor: 1},
+	FeatureSigningIDRules: {Major: 2022, Minor: 4},
+	FeatureCdHashRules:    {Major: 2023, Minor: 5},
+	FeatureSyncType:       {Major: 2024, Minor: 1},
+	FeatureCELRules:       {Major: 2025, Minor: 6},
+}
+
+func (f Feature) String() string {
+	switch f {
+	case FeatureAllowlistNames:
+		return "allowlist_names"
+	case FeatureTeamIDRules:
+		return "teamid_rules"
+	case FeatureSigningIDRules:
+		return "signingid_rules"
+	case FeatureCdHashRules:
+		return "cdhash_rules"
+	case FeatureSyncType:
+		return "sync_type"
+	case FeatureCELRules:
+		return "cel_rules"
+	default:
+		return "unknown"
+	}
+}
+
+// Supports reports whether a client running version v understands feature f.
+// The zero Version, used when a client did not report a parseable version,
+// is treated as a current release, so rules such as blocklists are never
+// withheld from a client only because its version is unknown.
+func (v Version) Supports(f Feature) bool {
+	since, ok := CompatTable[f]
+	if !ok || v == (Version{}) {
+		return true
+	}
+	return !v.Less(since)
+}
+
+// RequiredFeature returns the Feature a client must support to apply the rule, if any.
+func (r Rule) RequiredFeature() (Feature, bool) {
+	if r.Policy == Cel {
+		return FeatureCELRules, true
+	}
+	switch r.RuleType {
+	case TeamID:
+		return FeatureTeamIDRules, true
+	case SigningID:
+		return FeatureSigningIDRules, true
+	case CdHash:
+		return FeatureCdHashRules, true
+	}
+	return 0, false
+}
+
+// ShapeRules splits rules into the ones a client running version v can apply
+// and the ones it does not understand.
+func ShapeRules(v Version, rules []Rule) (kept, dropped []Rule) {
+	for _, r := range rules {
+		if f, ok := r.RequiredFeature(); ok && !v.Supports(f) {
+			dropped = append(dropped, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, dropped
+}
+
+// ShapePreflight adapts a preflight response to a client running version v,
+// filling the deprecated fields older clients read, and folding SyncType into
+// CleanSync for clients which predate it.
+func ShapePreflight(v Version, pre Preflight) Preflight {
+	if !v.Supports(FeatureAllowlistNames) {
+		if pre.DeprecatedWhitelistRegex == "" {
+			pre.DeprecatedWhitelistRegex = pre.AllowedPathRegex
+		}
+		if pre.DeprecatedBlacklistRegex == "" {
+			pre.DeprecatedBlacklistRegex = pre.BlockedPathRegex
+		}
+		pre.DeprecatedBundlesEnabled = pre.DeprecatedBundlesEnabled || pre.EnableBundles
+		pre.DeprecatedEnabledTransitiveWhitelisting = pre.DeprecatedEnabledTransitiveWhitelisting || pre.EnableTransitiveRules
+		pre.DeprecatedTransitiveWhitelistingEnabled = pre.DeprecatedTransitiveWhitelistingEnabled || pre.EnableTransitiveRules
+		if pre.DeprecatedFcmFullSyncIntervalSeconds == 0 {
+			pre.DeprecatedFcmFullSyncIntervalSeconds = pre.PushNotificationFullSyncIntervalSeconds
+		}
+		if pre.DeprecatedFcmGlobalRuleSyncDeadlineSeconds == 0 {
+			pre.DeprecatedFcmGlobalRuleSyncDeadlineSeconds = pre.PushNotificationGlobalRuleSyncDeadlineSeconds
+		}
+	}
+	if !v.Supports(FeatureSyncType) {
+		pre.CleanSync = pre.CleanSync || pre.SyncType == SyncTypeClean || pre.SyncType == SyncTypeCleanAll
+		pre.SyncType = SyncTypeUnspecified
+	}
+	return pre
+}
@@ -0,0 +1,82 @@
+package santa
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{in: "2024.9", want: Version{Major: 2024, Minor: 9}},
+		{in: "2024.9.674285143", want: Version{Major: 2024, Minor: 9, Patch: 674285143}},
+		{in: "1.14.0", want: Version{Major: 1, Minor: 14}},
+		{in: "2025.6-beta", want: Version{Major: 2025, Minor: 6}},
+	}
+	for _, tt := range tests {
+		have, err := ParseVersion(tt.in)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) err = %q\n", tt.in, err)
+		}
+		if have != tt.want {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v\n", tt.in, have, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "santa", "v2024.9"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) expected error\n", in)
+		}
+	}
+}
+
+func TestShapeRules(t *testing.T) {
+	rules := []Rule{
+		{RuleType: Binary, Policy: Blocklist},
+		{RuleType: TeamID, Policy: Allowlist},
+		{RuleType: CdHash, Policy: Allowlist},
+		{RuleType: SigningID, Policy: Cel, CELExpr: "euid != 0"},
+	}
+
+	kept, dropped := ShapeRules(Version{Major: 2022, Minor: 1}, rules)
+	if have, want := len(kept), 2; have != want {
+		t.Errorf("have %d kept rules, want %d\n", have, want)
+	}
+	if have, want := len(dropped), 2; have != want {
+		t.Errorf("have %d dropped rules, want %d\n", have, want)
+	}
+
+	kept, _ = ShapeRules(Version{Major: 2025, Minor: 6}, rules)
+	if have, want := len(kept), len(rules); have != want {
+		t.Errorf("have %d kept rules, want %d\n", have, want)
+	}
+
+	// a client which didn't report its version keeps every rule.
+	kept, _ = ShapeRules(Version{}, rules)
+	if have, want := len(kept), len(rules); have != want {
+		t.Errorf("have %d kept rules for an unknown version, want %d\n", have, want)
+	}
+}
+
+func TestShapePreflight(t *testing.T) {
+	pre := Preflight{
+		AllowedPathRegex: "^/opt/.*",
+		EnableBundles:    true,
+		SyncType:         SyncTypeClean,
+	}
+
+	old := ShapePreflight(Version{Major: 1, Minor: 14}, pre)
+	if have, want := old.DeprecatedWhitelistRegex, pre.AllowedPathRegex; have != want {
+		t.Errorf("have whitelist_regex %q, want %q\n", have, want)
+	}
+	if !old.DeprecatedBundlesEnabled {
+		t.Errorf("bundles_enabled not set for old client\n")
+	}
+	if !old.CleanSync || old.SyncType != SyncTypeUnspecified {
+		t.Errorf("have clean_sync %t sync_type %d, want clean_sync for old client\n", old.CleanSync, old.SyncType)
+	}
+
+	current := ShapePreflight(Version{Major: 2025, Minor: 6}, pre)
+	if current.DeprecatedWhitelistRegex != "" || current.SyncType != SyncTypeClean {
+		t.Errorf("current client preflight was modified: %+v\n", current)
+	}
+}
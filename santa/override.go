@@ -0,0 +1,178 @@
+package santa
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Override conditionally changes the Preflight sent to machines whose
+// preflight request matches the If condition.
+// Overrides are applied in the order they are declared, so later overrides win.
+type Override struct {
+	Name string            `toml:"name"`
+	If   Condition         `toml:"if"`
+	Then PreflightOverride `toml:"then"`
+}
+
+// Condition matches attributes of a PreflightPayload.
+// Each non-empty field is a regular expression which must match the
+// corresponding preflight value. An empty Condition matches every machine.
+type Condition struct {
+	MachineID       string `toml:"machine_id,omitempty"`
+	Hostname        string `toml:"hostname,omitempty"`
+	SerialNumber    string `toml:"serial_num,omitempty"`
+	OSVersion       string `toml:"os_version,omitempty"`
+	OSBuild         string `toml:"os_build,omitempty"`
+	ModelIdentifier string `toml:"model_identifier,omitempty"`
+	SantaVersion    string `toml:"santa_version,omitempty"`
+	PrimaryUser     string `toml:"primary_user,omitempty"`
+
+	// compiled holds the patterns compiled by Compile, by field.
+	compiled map[string]*regexp.Regexp
+}
+
+// PreflightOverride holds the Preflight fields an Override replaces.
+// Unset fields are left unchanged.
+type PreflightOverride struct {
+	ClientMode                *ClientMode       `toml:"client_mode,omitempty"`
+	SyncType                  *SyncType         `toml:"sync_type,omitempty"`
+	BatchSize                 *int              `toml:"batch_size,omitempty"`
+	FullSyncIntervalSeconds   *int              `toml:"full_sync_interval_seconds,omitempty"`
+	BlockedPathRegex          *string           `toml:"blocked_path_regex,omitempty"`
+	AllowedPathRegex          *string           `toml:"allowed_path_regex,omitempty"`
+	BlockUSBMount             *bool             `toml:"block_usb_mount,omitempty"`
+	RemountUSBMode            []string          `toml:"remount_usb_mode,omitempty"`
+	OverrideFileAccessAction  *FileAccessAction `toml:"override_file_access_action,omitempty"`
+	EventDetailURL            *string           `toml:"event_detail_url,omitempty"`
+	EventDetailText           *string           `toml:"event_detail_text,omitempty"`
+	EnableAllEventUpload      *bool             `toml:"enable_all_event_upload,omitempty"`
+	DisableUnknownEventUpload *bool             `toml:"disable_unknown_event_upload,omitempty"`
+	EnableBundles             *bool             `toml:"enable_bundles,omitempty"`
+	EnableTransitiveRules     *bool             `toml:"enable_transitive_rules,omitempty"`
+}
+
+type conditionCheck struct {
+	field, pattern, value string
+}
+
+// checks pairs each pattern set in the condition with the preflight value it is matched against.
+func (c Condition) checks(machineID string, p PreflightPayload) []conditionCheck {
+	var checks []conditionCheck
+	for _, check := range []conditionCheck{
+		{"machine_id", c.MachineID, machineID},
+		{"hostname", c.Hostname, p.Hostname},
+		{"serial_num", c.SerialNumber, p.SerialNumber},
+		{"os_version", c.OSVersion, p.OSVersion},
+		{"os_build", c.OSBuild, p.OSBuild},
+		{"model_identifier", c.ModelIdentifier, p.ModelIdentifier},
+		{"santa_version", c.SantaVersion, p.SantaVersion},
+		{"primary_user", c.PrimaryUser, p.PrimaryUser},
+	} {
+		if check.pattern != "" {
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// Compile compiles every pattern in the condition, so that configurations
+// with an invalid pattern are rejected when they are loaded, and requests
+// don't compile the patterns again.
+func (c *Condition) Compile() error {
+	compiled := make(map[string]*regexp.Regexp)
+	for _, check := range c.checks("", PreflightPayload{}) {
+		re, err := regexp.Compile(check.pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s pattern", check.field)
+		}
+		compiled[check.field] = re
+	}
+	c.compiled = compiled
+	return nil
+}
+
+// Matches reports whether the preflight request sent by machineID satisfies the condition.
+// Patterns of a condition which wasn't compiled are compiled on every call.
+func (c Condition) Matches(machineID string, p PreflightPayload) (bool, error) {
+	for _, check := range c.checks(machineID, p) {
+		re, ok := c.compiled[check.field]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(check.pattern); err != nil {
+				return false, errors.Wrapf(err, "invalid %s pattern", check.field)
+			}
+		}
+		if !re.MatchString(check.value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Apply replaces the fields of pre set in the override.
+func (o PreflightOverride) Apply(pre *Preflight) {
+	if o.ClientMode != nil {
+		pre.ClientMode = *o.ClientMode
+	}
+	if o.SyncType != nil {
+		pre.SyncType = *o.SyncType
+	}
+	if o.BatchSize != nil {
+		pre.BatchSize = *o.BatchSize
+	}
+	if o.FullSyncIntervalSeconds != nil {
+		pre.FullSyncIntervalSeconds = *o.FullSyncIntervalSeconds
+	}
+	if o.BlockedPathRegex != nil {
+		pre.BlockedPathRegex = *o.BlockedPathRegex
+	}
+	if o.AllowedPathRegex != nil {
+		pre.AllowedPathRegex = *o.AllowedPathRegex
+	}
+	if o.BlockUSBMount != nil {
+		pre.BlockUSBMount = *o.BlockUSBMount
+	}
+	if o.RemountUSBMode != nil {
+		pre.RemountUSBMode = o.RemountUSBMode
+	}
+	if o.OverrideFileAccessAction != nil {
+		pre.OverrideFileAccessAction = *o.OverrideFileAccessAction
+	}
+	if o.EventDetailURL != nil {
+		pre.EventDetailURL = *o.EventDetailURL
+	}
+	if o.EventDetailText != nil {
+		pre.EventDetailText = *o.EventDetailText
+	}
+	if o.EnableAllEventUpload != nil {
+		pre.EnableAllEventUpload = *o.EnableAllEventUpload
+	}
+	if o.DisableUnknownEventUpload != nil {
+		pre.DisableUnknownEventUpload = *o.DisableUnknownEventUpload
+	}
+	if o.EnableBundles != nil {
+		pre.EnableBundles = *o.EnableBundles
+	}
+	if o.EnableTransitiveRules != nil {
+		pre.EnableTransitiveRules = *o.EnableTransitiveRules
+	}
+}
+
+// ApplyOverrides evaluates overrides in order against a preflight request and
+// returns the resulting Preflight along with the names of the overrides that matched.
+func ApplyOverrides(pre Preflight, overrides []Override, machineID string, p PreflightPayload) (Preflight, []string, error) {
+	var matched []string
+	for _, o := range overrides {
+		ok, err := o.If.Matches(machineID, p)
+		if err != nil {
+			return pre, matched, errors.Wrapf(err, "evaluate override %q", o.Name)
+		}
+		if !ok {
+			continue
+		}
+		o.Then.Apply(&pre)
+		matched = append(matched, o.Name)
+	}
+	return pre, matched, nil
+}
@@ -0,0 +1,84 @@
+package santa
+
+import "testing"
+
+func TestConditionMatches(t *testing.T) {
+	p := PreflightPayload{Hostname: "mac-finance-01", SerialNumber: "C02XYZ", SantaVersion: "2024.9"}
+	tests := []struct {
+		name string
+		cond Condition
+		want bool
+	}{
+		{name: "empty", cond: Condition{}, want: true},
+		{name: "hostname", cond: Condition{Hostname: "^mac-finance-"}, want: true},
+		{name: "every field", cond: Condition{Hostname: "finance", SantaVersion: `^2024\.`, MachineID: "^M1$"}, want: true},
+		{name: "one field differs", cond: Condition{Hostname: "finance", SerialNumber: "^FVF"}, want: false},
+		{name: "machine id", cond: Condition{MachineID: "^M2$"}, want: false},
+	}
+	for _, tt := range tests {
+		if err := tt.cond.Compile(); err != nil {
+			t.Fatalf("%s: %s\n", tt.name, err)
+		}
+		have, err := tt.cond.Matches("M1", p)
+		if err != nil {
+			t.Fatalf("%s: %s\n", tt.name, err)
+		}
+		if have != tt.want {
+			t.Errorf("%s: have match %t, want %t\n", tt.name, have, tt.want)
+		}
+	}
+
+	invalid := Condition{Hostname: "finance", SerialNumber: "(C02"}
+	if err := invalid.Compile(); err == nil {
+		t.Errorf("invalid serial_num pattern compiled\n")
+	}
+	if _, err := invalid.Matches("M1", p); err == nil {
+		t.Errorf("invalid serial_num pattern matched without an error\n")
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	lockdown, monitor := Lockdown, Monitor
+	batch := 10
+	overrides := []Override{
+		{Name: "finance", If: Condition{Hostname: "finance"}, Then: PreflightOverride{ClientMode: &lockdown, BatchSize: &batch}},
+		{Name: "beta", If: Condition{SantaVersion: "beta"}, Then: PreflightOverride{ClientMode: &lockdown}},
+		{Name: "pilot", If: Condition{MachineID: "^M1$"}, Then: PreflightOverride{ClientMode: &monitor}},
+	}
+	for i := range overrides {
+		if err := overrides[i].If.Compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := Preflight{ClientMode: Monitor, BatchSize: 100}
+
+	// later overrides win, and fields they don't set are kept.
+	pre, matched, err := ApplyOverrides(base, overrides, "M1", PreflightPayload{Hostname: "mac-finance-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.ClientMode != Monitor || pre.BatchSize != 10 || len(matched) != 2 || matched[0] != "finance" || matched[1] != "pilot" {
+		t.Errorf("have %v %d %v, want MONITOR with batch size 10 from finance and pilot\n", pre.ClientMode, pre.BatchSize, matched)
+	}
+
+	pre, matched, err = ApplyOverrides(base, overrides, "M2", PreflightPayload{Hostname: "mac-finance-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.ClientMode != Lockdown || len(matched) != 1 {
+		t.Errorf("have %v %v, want LOCKDOWN from finance\n", pre.ClientMode, matched)
+	}
+
+	pre, matched, err = ApplyOverrides(base, overrides, "M3", PreflightPayload{Hostname: "mac-eng-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.ClientMode != Monitor || pre.BatchSize != 100 || len(matched) != 0 {
+		t.Errorf("have %v %v, want the base preflight\n", pre.ClientMode, matched)
+	}
+
+	_, _, err = ApplyOverrides(base, []Override{{Name: "bad", If: Condition{Hostname: "("}}}, "M1", PreflightPayload{})
+	if err == nil {
+		t.Errorf("override with an invalid pattern applied without an error\n")
+	}
+}
@@ -10,7 +10,13 @@ import (
 type Config struct {
 	MachineID string `toml:"machine_id,omitempty"`
 	Preflight
-	Rules []Rule `toml:"rules"`
+	Rules         []Rule         `toml:"rules"`
+	Overrides     []Override     `toml:"overrides,omitempty"`
+	SantaVersions *VersionPolicy `toml:"santa_versions,omitempty"`
+
+	// Groups name sets of machines, by conditions on their last preflight request.
+	// Only groups declared in the global configuration are used.
+	Groups map[string]Condition `toml:"groups,omitempty"`
 }
 
 // Rule is a Santa rule.
@@ -24,6 +30,9 @@ type Rule struct {
 	FileBundleBinaryCount *int     `json:"file_bundle_binary_count,omitempty" toml:"file_bundle_binary_count,omitempty"`
 	FileBundleHash        *string  `json:"file_bundle_hash,omitempty" toml:"file_bundle_hash,omitempty"`
 	DeprecatedSHA256      *string  `json:"deprecated_sha256,omitempty" toml:"deprecated_sha256,omitempty"`
+
+	// CELExpr is the CEL expression evaluated by the client for rules with the CEL policy.
+	CELExpr string `json:"cel_expr,omitempty" toml:"cel_expr,omitempty"`
 }
 
 // Preflight represents sync response sent to a Santa client by the sync server.
@@ -0,0 +1,63 @@
+package santa
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version is a parsed Santa release version, as reported in PreflightPayload.SantaVersion.
+// Santa releases use a YYYY.N scheme (ie. 2024.9), with older releases using semver (ie. 1.14.0).
+// Components past the third one are ignored.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses a Santa version string such as "2024.9" or "2024.9.674285143".
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return v, errors.New("empty santa version")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		// tolerate suffixes such as "2025.6-beta"
+		digits := strings.IndexFunc(p, func(r rune) bool { return r < '0' || r > '9' })
+		if digits == -1 {
+			digits = len(p)
+		}
+		n, err := strconv.Atoi(p[:digits])
+		if err != nil {
+			return v, errors.Errorf("invalid santa version %q", s)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+// Less reports whether v is an older release than o.
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+func (v Version) String() string {
+	if v.Patch != 0 {
+		return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch)
+	}
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+}
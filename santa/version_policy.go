@@ -0,0 +1,81 @@
+package santa
+
+import (
+	"fmt"
+)
+
+// VersionPolicy declares which Santa versions machines are expected to run.
+type VersionPolicy struct {
+	Minimum     string `toml:"minimum,omitempty"`
+	Recommended string `toml:"recommended,omitempty"`
+
+	// BelowMinimum is applied to the preflight of machines older than Minimum.
+	// When it does not set event_detail_text, a message asking the user to update is used.
+	BelowMinimum PreflightOverride `toml:"below_minimum,omitempty"`
+}
+
+// VersionBand groups machines by how their Santa version compares to a VersionPolicy.
+type VersionBand string
+
+const (
+	BandUnknown          VersionBand = "unknown"
+	BandBelowMinimum     VersionBand = "below_minimum"
+	BandBelowRecommended VersionBand = "below_recommended"
+	BandCurrent          VersionBand = "current"
+)
+
+// Validate checks that the declared versions parse.
+func (vp VersionPolicy) Validate() error {
+	for _, v := range []string{vp.Minimum, vp.Recommended} {
+		if v == "" {
+			continue
+		}
+		if _, err := ParseVersion(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Band returns the VersionBand of a version reported by a client.
+// A missing or unparseable version is BandUnknown, whatever the policy.
+func (vp VersionPolicy) Band(santaVersion string) VersionBand {
+	v, err := ParseVersion(santaVersion)
+	if err != nil {
+		return BandUnknown
+	}
+	if min, err := ParseVersion(vp.Minimum); err == nil && v.Less(min) {
+		return BandBelowMinimum
+	}
+	if rec, err := ParseVersion(vp.Recommended); err == nil && v.Less(rec) {
+		return BandBelowRecommended
+	}
+	return BandCurrent
+}
+
+// Enforce applies BelowMinimum to pre when santaVersion is older than Minimum.
+// A client which doesn't report a parseable version can't be shown to meet
+// the minimum, so it is held to BelowMinimum too.
+// It reports whether BelowMinimum was applied.
+func (vp VersionPolicy) Enforce(pre *Preflight, santaVersion string) bool {
+	if vp.Minimum == "" {
+		return false
+	}
+	band := vp.Band(santaVersion)
+	if band != BandBelowMinimum && band != BandUnknown {
+		return false
+	}
+	vp.BelowMinimum.Apply(pre)
+	if vp.BelowMinimum.EventDetailText != nil {
+		return true
+	}
+	if band == BandUnknown {
+		pre.EventDetailText = fmt.Sprintf("This Santa version is not supported. Please update to Santa %s or newer.", vp.Minimum)
+	} else {
+		pre.EventDetailText = fmt.Sprintf(
+			"Santa %s is no longer supported. Please update to Santa %s or newer.",
+			santaVersion, vp.Minimum,
+		)
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package santa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionPolicyBand(t *testing.T) {
+	vp := VersionPolicy{Minimum: "2024.1", Recommended: "2025.6"}
+	tests := []struct {
+		policy  VersionPolicy
+		version string
+		want    VersionBand
+	}{
+		{policy: vp, version: "2023.9", want: BandBelowMinimum},
+		{policy: vp, version: "1.14.0", want: BandBelowMinimum},
+		{policy: vp, version: "2024.1", want: BandBelowRecommended},
+		{policy: vp, version: "2025.6.1", want: BandCurrent},
+		{policy: vp, version: "", want: BandUnknown},
+		{policy: vp, version: "santa", want: BandUnknown},
+		{policy: VersionPolicy{}, version: "1.0", want: BandCurrent},
+		{policy: VersionPolicy{}, version: "", want: BandUnknown},
+	}
+	for _, tt := range tests {
+		if have := tt.policy.Band(tt.version); have != tt.want {
+			t.Errorf("Band(%q) with %+v = %s, want %s\n", tt.version, tt.policy, have, tt.want)
+		}
+	}
+}
+
+func TestVersionPolicyEnforce(t *testing.T) {
+	monitor := Monitor
+	vp := VersionPolicy{Minimum: "2024.1", BelowMinimum: PreflightOverride{ClientMode: &monitor}}
+	tests := []struct {
+		policy  VersionPolicy
+		version string
+		want    bool
+		text    string
+	}{
+		{policy: vp, version: "2023.9", want: true, text: "Santa 2023.9 is no longer supported"},
+		{policy: vp, version: "", want: true, text: "This Santa version is not supported"},
+		{policy: vp, version: "garbage", want: true, text: "This Santa version is not supported"},
+		{policy: vp, version: "2024.1", want: false},
+		{policy: VersionPolicy{Recommended: "2025.6"}, version: "", want: false},
+	}
+	for _, tt := range tests {
+		pre := Preflight{ClientMode: Lockdown}
+		have := tt.policy.Enforce(&pre, tt.version)
+		if have != tt.want {
+			t.Errorf("Enforce(%q) = %t, want %t\n", tt.version, have, tt.want)
+			continue
+		}
+		if !have {
+			if pre.ClientMode != Lockdown || pre.EventDetailText != "" {
+				t.Errorf("Enforce(%q) changed the preflight %+v\n", tt.version, pre)
+			}
+			continue
+		}
+		if pre.ClientMode != Monitor || !strings.Contains(pre.EventDetailText, tt.text) {
+			t.Errorf("Enforce(%q) = %v %q, want MONITOR and %q\n", tt.version, pre.ClientMode, pre.EventDetailText, tt.text)
+		}
+	}
+}
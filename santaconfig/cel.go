@@ -0,0 +1,110 @@
+package santaconfig
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"github.com/pkg/errors"
+
+	"github.com/groob/moroz/santa"
+)
+
+// celTarget mirrors the `target` variable Santa exposes to CEL expressions.
+// https://northpole.dev/features/cel
+type celTarget struct {
+	SigningTime       time.Time `cel:"signing_time"`
+	SecureSigningTime time.Time `cel:"secure_signing_time"`
+}
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+)
+
+func newCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.NativeTypes(reflect.TypeOf(&celTarget{}), ext.ParseStructTags(true)),
+		cel.Variable("target", cel.ObjectType("santaconfig.celTarget")),
+		cel.Variable("args", cel.ListType(cel.StringType)),
+		cel.Variable("envs", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("euid", cel.IntType),
+		cel.Variable("cwd", cel.StringType),
+		cel.Constant("ALLOWLIST", cel.IntType, types.Int(santa.Allowlist)),
+		cel.Constant("ALLOWLIST_COMPILER", cel.IntType, types.Int(santa.AllowlistCompiler)),
+		cel.Constant("BLOCKLIST", cel.IntType, types.Int(santa.Blocklist)),
+		cel.Constant("SILENT_BLOCKLIST", cel.IntType, types.Int(santa.SilentBlocklist)),
+	)
+}
+
+// checkCELExpr parses and type-checks a rule expression against the variables
+// Santa makes available at execution time. Expressions must evaluate to a bool
+// or to one of the policy constants.
+func checkCELExpr(expr string) error {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = newCELEnv()
+	})
+	if celEnvErr != nil {
+		return errors.Wrap(celEnvErr, "create CEL environment")
+	}
+	ast, iss := celEnv.Compile(expr)
+	if iss.Err() != nil {
+		return iss.Err()
+	}
+	switch out := ast.OutputType(); {
+	case out.IsExactType(cel.BoolType):
+		return nil
+	case out.IsExactType(cel.IntType) && returnsPolicy(ast.NativeRep().Expr()):
+		return nil
+	default:
+		return errors.Errorf("expression returns %s, want bool or policy constant", out)
+	}
+}
+
+// policyConstants are the constants an int expression may return.
+var policyConstants = map[string]bool{
+	"ALLOWLIST":          true,
+	"ALLOWLIST_COMPILER": true,
+	"BLOCKLIST":          true,
+	"SILENT_BLOCKLIST":   true,
+}
+
+// returnsPolicy reports whether every value e can return is a policy
+// constant, through any nesting of conditionals. It rejects int expressions
+// such as `euid`, which Santa can't interpret as a policy.
+func returnsPolicy(e celast.Expr) bool {
+	switch e.Kind() {
+	case celast.IdentKind:
+		return policyConstants[e.AsIdent()]
+	case celast.CallKind:
+		call := e.AsCall()
+		if call.FunctionName() != operators.Conditional || len(call.Args()) != 3 {
+			return false
+		}
+		return returnsPolicy(call.Args()[1]) && returnsPolicy(call.Args()[2])
+	default:
+		return false
+	}
+}
+
+func validateRules(rules []santa.Rule) error {
+	for i, rule := range rules {
+		switch {
+		case rule.Policy == santa.Cel && rule.CELExpr == "":
+			return errors.Errorf("rule %d (%s): CEL policy requires cel_expr", i, rule.Identifier)
+		case rule.Policy != santa.Cel && rule.CELExpr != "":
+			return errors.Errorf("rule %d (%s): cel_expr is only valid with the CEL policy", i, rule.Identifier)
+		case rule.Policy == santa.Cel:
+			if err := checkCELExpr(rule.CELExpr); err != nil {
+				return errors.Wrapf(err, "rule %d (%s): invalid cel_expr", i, rule.Identifier)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package santaconfig
+
+import (
+	"testing"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestCheckCELExpr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: `target.signing_time >= timestamp('2025-05-31T00:00:00Z')`},
+		{expr: `'--inspect' in args ? BLOCKLIST : ALLOWLIST`},
+		{expr: `envs['DYLD_INSERT_LIBRARIES'] != '' || euid == 0`},
+		{expr: `cwd.startsWith('/Users')`},
+		{expr: `target.signing_tme > timestamp('2025-05-31T00:00:00Z')`, wantErr: true},
+		{expr: `unknown_var == 1`, wantErr: true},
+		{expr: `cwd`, wantErr: true},
+		{expr: `euid`, wantErr: true},
+		{expr: `euid == 0 ? BLOCKLIST : euid`, wantErr: true},
+		{expr: `euid == 0 ? BLOCKLIST : ('--x' in args ? SILENT_BLOCKLIST : ALLOWLIST)`},
+		{expr: `args[`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := checkCELExpr(tt.expr)
+		if have, want := err != nil, tt.wantErr; have != want {
+			t.Errorf("checkCELExpr(%q) err = %v, want error %t\n", tt.expr, err, want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	rules := []santa.Rule{
+		{RuleType: santa.SigningID, Policy: santa.Cel, Identifier: "EQHXZ8M8AV:com.google.Chrome", CELExpr: `euid != 0`},
+	}
+	if err := validateRules(rules); err != nil {
+		t.Errorf("valid CEL rule rejected: %s\n", err)
+	}
+
+	rules[0].CELExpr = ""
+	if err := validateRules(rules); err == nil {
+		t.Errorf("CEL rule without cel_expr accepted\n")
+	}
+
+	rules[0].Policy = santa.Allowlist
+	rules[0].CELExpr = `euid != 0`
+	if err := validateRules(rules); err == nil {
+		t.Errorf("ALLOWLIST rule with cel_expr accepted\n")
+	}
+}
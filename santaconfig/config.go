@@ -81,6 +81,25 @@ func loadConfigs(path string) ([]santa.Config, error) {
 			if err != nil {
 				return errors.Wrapf(err, "failed to decode %v, skipping \n", info.Name())
 			}
+			if err := validateRules(conf.Rules); err != nil {
+				return errors.Wrapf(err, "validating rules in %v", info.Name())
+			}
+			for i, o := range conf.Overrides {
+				if err := conf.Overrides[i].If.Compile(); err != nil {
+					return errors.Wrapf(err, "validating override %q in %v", o.Name, info.Name())
+				}
+			}
+			for name, cond := range conf.Groups {
+				if err := cond.Compile(); err != nil {
+					return errors.Wrapf(err, "validating group %q in %v", name, info.Name())
+				}
+				conf.Groups[name] = cond
+			}
+			if conf.SantaVersions != nil {
+				if err := conf.SantaVersions.Validate(); err != nil {
+					return errors.Wrapf(err, "validating santa_versions in %v", info.Name())
+				}
+			}
 			name := info.Name()
 			conf.MachineID = strings.TrimSuffix(name, filepath.Ext(name))
 			configs = append(configs, conf)
@@ -0,0 +1,46 @@
+package santaconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/groob/moroz/santa"
+)
+
+func TestLoadConfigsCompilesConditions(t *testing.T) {
+	dir := t.TempDir()
+	global := `
+[[overrides]]
+name = "finance"
+[overrides.if]
+hostname = "^mac-finance-"
+[overrides.then]
+client_mode = "LOCKDOWN"
+
+[groups.finance]
+hostname = "^mac-finance-"
+`
+	if err := os.WriteFile(filepath.Join(dir, "global.toml"), []byte(global), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewFileRepo(dir).Config(context.Background(), "global")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := santa.PreflightPayload{Hostname: "mac-finance-01"}
+	for _, cond := range []santa.Condition{conf.Overrides[0].If, conf.Groups["finance"]} {
+		if ok, err := cond.Matches("M1", p); err != nil || !ok {
+			t.Errorf("have match %t, %v, want the finance machine matched\n", ok, err)
+		}
+	}
+
+	invalid := "[groups.finance]\nhostname = \"(mac\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "global.toml"), []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileRepo(dir).AllConfigs(context.Background()); err == nil {
+		t.Errorf("configuration with an invalid group pattern loaded\n")
+	}
+}